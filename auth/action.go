@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"auth/httpx"
+)
+
+// ActionPurpose is the purpose of action tokens: single-use tokens for one
+// action, such as the link of a password reset mail.
+const ActionPurpose = "action"
+
+// RequireAction only lets through requests carrying an unused action token
+// for action, as a bearer token or in the token query parameter (for
+// links). The handler finds the token's claims in the context and spends
+// the token with ConsumeAction once it has checked the request, so an
+// attempt it refuses can be retried with the same token.
+func RequireAction(keys Verifier, revocations RevocationStore, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := r.URL.Query().Get("token")
+			if tokenStr == "" {
+				var err error
+				if tokenStr, err = BearerToken(r); err != nil {
+					code, msg := TokenError(err)
+					httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+					return
+				}
+			}
+			claims, err := ParsePurposeToken(tokenStr, keys, ActionPurpose)
+			if err != nil {
+				httpx.Log(r.Context()).Println("ERROR: invalid action token: ", err)
+				code, msg := TokenError(err)
+				httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+				return
+			}
+			if claims.Action != action {
+				httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeWrongAction, "the token is for another action")
+				return
+			}
+			used, err := revocations.IsRevoked(r.Context(), claims.ID)
+			if err != nil {
+				httpx.Log(r.Context()).Println("ERROR: checking revocation: ", err)
+				httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "revocations cannot be checked")
+				return
+			}
+			if used {
+				httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenRevoked, "the token has already been used")
+				return
+			}
+			ctx := context.WithValue(WithClaims(r.Context(), claims), actionStoreKey, revocations)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+const actionStoreKey contextKey = "action-revocations"
+
+// ConsumeAction spends the action token RequireAction let r through with,
+// in one atomic step, so of concurrent requests with it only one succeeds.
+// Handlers call it once the request is checked and before they act. When
+// it returns false the request has been answered: 401 token_revoked when
+// another request spent the token first, 503 when the revocation store
+// cannot be reached.
+func ConsumeAction(w http.ResponseWriter, r *http.Request) bool {
+	claims, _ := ClaimsFromContext(r.Context())
+	revocations, ok := r.Context().Value(actionStoreKey).(RevocationStore)
+	if !ok || claims == nil {
+		httpx.Log(r.Context()).Println("ERROR: ConsumeAction outside RequireAction")
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return false
+	}
+	first, err := revocations.Consume(r.Context(), claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: consuming action token: ", err)
+		httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "revocations cannot be checked")
+		return false
+	}
+	if !first {
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenRevoked, "the token has already been used")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func actionToken(t *testing.T, keys *Keyring, action string) string {
+	t.Helper()
+	claims := testClaims("alice")
+	claims.Purpose = ActionPurpose
+	claims.Action = action
+	return mustSign(t, keys, claims)
+}
+
+func TestRequireAction(t *testing.T) {
+	keys := StaticKeyring([]byte("action-secret"))
+	h := RequireAction(keys, NewMemoryRevocationStore(), "password_reset")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("refuse") != "" {
+			http.Error(w, "refused", http.StatusUnprocessableEntity)
+			return
+		}
+		if !ConsumeAction(w, r) {
+			return
+		}
+		claims, _ := ClaimsFromContext(r.Context())
+		w.Write([]byte(claims.Subject))
+	}))
+	do := func(token string, query ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/reset?"+strings.Join(query, "&"), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	token := actionToken(t, keys, "password_reset")
+	// a request the handler refuses does not spend the token
+	if rec := do(token, "refuse=1"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("refused use: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(token); rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("first use: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(token); rec.Code != http.StatusUnauthorized || !contains(rec, "token_revoked") {
+		t.Errorf("reuse: %d %s, want 401 token_revoked", rec.Code, rec.Body)
+	}
+
+	// a token for another action is refused without being spent
+	other := actionToken(t, keys, "confirm_email")
+	if rec := do(other); rec.Code != http.StatusForbidden || !contains(rec, "wrong_action") {
+		t.Errorf("other action: %d %s, want 403 wrong_action", rec.Code, rec.Body)
+	}
+	otherH := RequireAction(keys, NewMemoryRevocationStore(), "confirm_email")(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/confirm?token="+other, nil)
+	otherH.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("the token in a link, for its own action: %d %s", rec.Code, rec.Body)
+	}
+
+	// access tokens are no action tokens
+	if rec := do(mustSign(t, keys, testClaims("alice"))); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token: %d, want 401", rec.Code)
+	}
+}
+
+func TestConsumeActionOnce(t *testing.T) {
+	keys := StaticKeyring([]byte("action-secret"))
+	var succeeded atomic.Int32
+	h := RequireAction(keys, NewMemoryRevocationStore(), "password_reset")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ConsumeAction(w, r) {
+			succeeded.Add(1)
+		}
+	}))
+	token := actionToken(t, keys, "password_reset")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/reset", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+	if n := succeeded.Load(); n != 1 {
+		t.Errorf("%d concurrent requests consumed the token, want 1", n)
+	}
+
+	// outside RequireAction there is nothing to consume
+	rec := httptest.NewRecorder()
+	if ConsumeAction(rec, httptest.NewRequest("POST", "/reset", nil)) || rec.Code != http.StatusInternalServerError {
+		t.Errorf("ConsumeAction without RequireAction: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestActionTokenIsNoAccessToken(t *testing.T) {
+	keys := StaticKeyring([]byte("action-secret"))
+	if _, err := ParseToken(actionToken(t, keys, "password_reset"), keys); err == nil {
+		t.Error("an action token passes as an access token")
+	}
+}
+
+func contains(rec *httptest.ResponseRecorder, s string) bool {
+	return strings.Contains(rec.Body.String(), s)
+}
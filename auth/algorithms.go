@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Key is the key material of one signing algorithm. Signing is nil when
+// only the public half was loaded.
+type Key struct {
+	Signing      interface{}
+	Verification interface{}
+}
+
+// KeyProvider turns the contents of a key file into a Key for one
+// signing algorithm.
+type KeyProvider struct {
+	Method jwt.SigningMethod
+	// ParseSigning reads what the issuer signs with (secret or private key).
+	ParseSigning func(data []byte) (Key, error)
+	// ParseVerification reads what verifiers check with (secret or public key).
+	ParseVerification func(data []byte) (Key, error)
+	// Generate makes a new key in the format ParseSigning reads.
+	Generate func() ([]byte, error)
+}
+
+var providers = map[string]KeyProvider{}
+
+// RegisterKeyProvider makes an algorithm available under its JWT alg name.
+func RegisterKeyProvider(p KeyProvider) {
+	providers[p.Method.Alg()] = p
+}
+
+func keyProvider(alg string) (KeyProvider, error) {
+	p, ok := providers[alg]
+	if !ok {
+		return KeyProvider{}, fmt.Errorf("unsupported signing algorithm %q (supported: %v)", alg, Algorithms())
+	}
+	return p, nil
+}
+
+// Algorithms lists the registered algorithm names.
+func Algorithms() []string {
+	algs := make([]string, 0, len(providers))
+	for alg := range providers {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return algs
+}
+
+func init() {
+	RegisterKeyProvider(KeyProvider{
+		Method:            jwt.SigningMethodHS256,
+		ParseSigning:      parseSecret,
+		ParseVerification: parseSecret,
+		Generate: func() ([]byte, error) {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				return nil, err
+			}
+			return []byte(base64.RawURLEncoding.EncodeToString(b)), nil
+		},
+	})
+	RegisterKeyProvider(KeyProvider{
+		Method: jwt.SigningMethodRS256,
+		ParseSigning: func(data []byte) (Key, error) {
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return Key{}, err
+			}
+			return Key{Signing: priv, Verification: &priv.PublicKey}, nil
+		},
+		ParseVerification: func(data []byte) (Key, error) {
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return Key{}, err
+			}
+			return Key{Verification: pub}, nil
+		},
+		Generate: func() ([]byte, error) {
+			priv, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				return nil, err
+			}
+			return pemPKCS8(priv)
+		},
+	})
+	RegisterKeyProvider(KeyProvider{
+		Method:       jwt.SigningMethodEdDSA,
+		ParseSigning: parseEd25519Private,
+		ParseVerification: func(data []byte) (Key, error) {
+			pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return Key{}, err
+			}
+			return Key{Verification: pub}, nil
+		},
+		Generate: func() ([]byte, error) {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			return pemPKCS8(priv)
+		},
+	})
+}
+
+func pemPKCS8(priv interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseSecret(data []byte) (Key, error) {
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return Key{}, errors.New("secret is empty")
+	}
+	return Key{Signing: secret, Verification: secret}, nil
+}
+
+// parseEd25519Private accepts a PKCS#8 PEM key or a bare hex-encoded seed.
+func parseEd25519Private(data []byte) (Key, error) {
+	var priv ed25519.PrivateKey
+	if block, _ := pem.Decode(data); block != nil {
+		k, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return Key{}, err
+		}
+		priv = k.(ed25519.PrivateKey)
+	} else {
+		seed, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return Key{}, errors.New("ed25519 key must be a PEM private key or a 32-byte hex seed")
+		}
+		priv = ed25519.NewKeyFromSeed(seed)
+	}
+	return Key{Signing: priv, Verification: priv.Public()}, nil
+}
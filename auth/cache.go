@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// TokenCache remembers the claims of tokens it recently verified, so a
+// token presented again within ttl skips signature verification. Only the
+// signature and registered claims are cached: revocation and every other
+// check still have to run on each request. A cached token is only taken
+// while the key that signed it (by kid) is still live, so retiring or
+// rotating out a key ends its tokens' cache entries too.
+type TokenCache struct {
+	ttl time.Duration
+	max int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cachedToken
+}
+
+type cachedToken struct {
+	claims   *Claims
+	alg, kid string    // the key the token was verified with
+	expires  time.Time // the earlier of the cache deadline and exp
+}
+
+// NewTokenCache returns a cache holding at most max tokens for up to ttl.
+func NewTokenCache(ttl time.Duration, max int) *TokenCache {
+	return &TokenCache{ttl: ttl, max: max, entries: make(map[[sha256.Size]byte]cachedToken)}
+}
+
+// ParseToken is ParseToken answered from the cache when possible. Tokens
+// that fail verification are never cached. Cached tokens must have been
+// verified with the same keys and opts. Tokens without a kid are not
+// cached, as there is no telling whether their key is still live.
+func (c *TokenCache) ParseToken(tokenStr string, keys Verifier, opts ...jwt.ParserOption) (*Claims, error) {
+	sum := sha256.Sum256([]byte(tokenStr))
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[sum]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) && keyLive(keys, e.alg, e.kid) {
+		claims := *e.claims // callers get their own copy
+		return &claims, nil
+	}
+
+	claims, err := ParseToken(tokenStr, keys, opts...)
+	if err != nil {
+		c.forget(sum)
+		return nil, err
+	}
+	expires := now.Add(c.ttl)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expires) {
+		expires = claims.ExpiresAt.Time
+	}
+	alg, kid := tokenKey(tokenStr)
+	if now.Before(expires) && kid != "" {
+		c.add(sum, cachedToken{claims: claims, alg: alg, kid: kid, expires: expires}, now)
+	}
+	copied := *claims
+	return &copied, nil
+}
+
+// keyLive reports whether keys still hold the key named kid for alg.
+func keyLive(keys Verifier, alg, kid string) bool {
+	set, err := keys.VerificationKeysFor(alg, kid)
+	return err == nil && len(set.Keys) > 0
+}
+
+// tokenKey returns the alg and kid headers of a token that has been
+// verified already.
+func tokenKey(tokenStr string) (alg, kid string) {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenStr, &Claims{})
+	if err != nil {
+		return "", ""
+	}
+	kid, _ = token.Header["kid"].(string)
+	return token.Method.Alg(), kid
+}
+
+func (c *TokenCache) forget(sum [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, sum)
+}
+
+func (c *TokenCache) add(sum [sha256.Size]byte, e cachedToken, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.max {
+		for k, old := range c.entries {
+			if !now.Before(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return // full of live tokens; verify this one every time
+		}
+	}
+	c.entries[sum] = e
+}
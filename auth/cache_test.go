@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestTokenCacheHit(t *testing.T) {
+	keys := StaticKeyring([]byte("cache-secret"))
+	cache := NewTokenCache(time.Minute, 10)
+	token := mustSign(t, keys, testClaims("alice"))
+
+	first, err := cache.ParseToken(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Subject = "mallory" // callers get copies
+	second, err := cache.ParseToken(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Subject != "alice" {
+		t.Errorf("cached subject = %q, want alice", second.Subject)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("%d cached entries, want 1", len(cache.entries))
+	}
+}
+
+func TestTokenCacheNeverServesExpired(t *testing.T) {
+	keys := StaticKeyring([]byte("cache-secret"))
+	cache := NewTokenCache(time.Minute, 10)
+	claims := testClaims("alice")
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Second))
+	token := mustSign(t, keys, claims)
+
+	if _, err := cache.ParseToken(token, keys); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(claims.ExpiresAt.Time) + 10*time.Millisecond)
+	if _, err := cache.ParseToken(token, keys); err == nil {
+		t.Error("an expired token was served from the cache")
+	}
+}
+
+func TestTokenCacheTTL(t *testing.T) {
+	cache := NewTokenCache(20*time.Millisecond, 10)
+	keys := StaticKeyring([]byte("cache-secret"))
+	token := mustSign(t, keys, testClaims("alice"))
+	if _, err := cache.ParseToken(token, keys); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	// once the entry is old, the token is verified again: here, with the wrong key
+	if _, err := cache.ParseToken(token, StaticKeyring([]byte("other-secret"))); err == nil {
+		t.Error("a token was served from an expired cache entry")
+	}
+}
+
+func TestTokenCacheRetiredKey(t *testing.T) {
+	cache := NewTokenCache(time.Minute, 10)
+	token := mustSign(t, StaticKeyring([]byte("cache-secret")), testClaims("alice"))
+	if _, err := cache.ParseToken(token, StaticKeyring([]byte("cache-secret"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.ParseToken(token, StaticKeyring([]byte("other-secret"))); err == nil {
+		t.Error("a cached token was accepted without its key")
+	}
+	if len(cache.entries) != 0 {
+		t.Error("the failed token stays cached")
+	}
+}
+
+func TestTokenCacheSkipsInvalid(t *testing.T) {
+	cache := NewTokenCache(time.Minute, 1)
+	keys := StaticKeyring([]byte("cache-secret"))
+	if _, err := cache.ParseToken(mustSign(t, StaticKeyring([]byte("other-secret")), testClaims("mallory")), keys); err == nil {
+		t.Fatal("a token signed with another key verified")
+	}
+	if len(cache.entries) != 0 {
+		t.Error("an invalid token was cached")
+	}
+
+	// when full, tokens are still verified, just not remembered
+	for _, sub := range []string{"alice", "bob"} {
+		if _, err := cache.ParseToken(mustSign(t, keys, testClaims(sub)), keys); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("%d entries in a cache for one", len(cache.entries))
+	}
+}
+
+func BenchmarkParseTokenRS256(b *testing.B) {
+	signing, verifying, _ := rsaKeyrings(b)
+	token := mustSign(b, signing, testClaims("alice"))
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseToken(token, verifying); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cache := NewTokenCache(time.Minute, 10)
+		for i := 0; i < b.N; i++ {
+			if _, err := cache.ParseToken(token, verifying); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,244 @@
+// Package client logs in to the user service and calls the services with
+// the token it got, refreshing it before it expires:
+//
+//	c := client.New("http://localhost:8080/api/v1")
+//	if _, err := c.Login(ctx, "John Doe", "password"); err != nil { ... }
+//	req, _ := http.NewRequest("GET", "http://localhost:8081/api/v1/hello", nil)
+//	resp, err := c.Do(ctx, req)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"auth/httpx"
+)
+
+// Token is what a login or refresh returned.
+type Token struct {
+	AccessToken  string
+	RefreshToken string // empty when the service issued none
+	ExpiresAt    time.Time
+}
+
+// Client holds one login's tokens. It is safe for concurrent use;
+// refreshes are serialized, as each refresh token may be used only once.
+type Client struct {
+	// BaseURL is where the user service's API lives, e.g.
+	// http://localhost:8080/api/v1.
+	BaseURL string
+	// HTTP sends the requests; http.DefaultClient when nil.
+	HTTP *http.Client
+	// RefreshBefore is how long before expiry the token is refreshed.
+	RefreshBefore time.Duration
+
+	mu    sync.Mutex
+	token Token
+}
+
+// New returns a client for the user service at baseURL that refreshes
+// tokens a minute before they expire.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL, RefreshBefore: time.Minute}
+}
+
+// ErrNotLoggedIn is returned by Do before a successful Login.
+var ErrNotLoggedIn = errors.New("client: not logged in")
+
+// ErrMFARequired is returned by Login for users with two-factor login,
+// which this client does not do.
+var ErrMFARequired = errors.New("client: the user requires two-factor login")
+
+// Error is an error response of the user service.
+type Error struct {
+	Status    int        `json:"-"`
+	Code      httpx.Code `json:"code"`
+	Message   string     `json:"message"`
+	RequestID string     `json:"request_id"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: %d %s", e.Status, e.Code)
+	}
+	return fmt.Sprintf("client: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Login trades username and password for a token, used by Do from now on.
+func (c *Client) Login(ctx context.Context, username, password string) (Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tok, err := c.post(ctx, "/login", map[string]string{"username": username, "password": password})
+	if err != nil {
+		return Token{}, err
+	}
+	c.token = tok
+	return tok, nil
+}
+
+// Do sends req with the bearer token, refreshing the token first when it
+// is about to expire. A 401 gets the token refreshed and req sent once more,
+// if its body can be replayed (see http.Request.GetBody).
+func (c *Client) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	tok, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, req, tok)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	fresh, err := c.refresh(ctx, tok)
+	if err != nil {
+		return resp, nil // the 401 says more than the failed refresh
+	}
+	resp.Body.Close()
+	return c.send(ctx, req, fresh)
+}
+
+// Refresh trades the refresh token for a new token now, whether or not the
+// current one is about to expire.
+func (c *Client) Refresh(ctx context.Context) (Token, error) {
+	tok := c.Token()
+	if tok.AccessToken == "" {
+		return Token{}, ErrNotLoggedIn
+	}
+	return c.refresh(ctx, tok)
+}
+
+// Token returns the current token.
+func (c *Client) Token() Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Client) send(ctx context.Context, req *http.Request, tok Token) (*http.Response, error) {
+	out := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+	out.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	return c.httpClient().Do(out)
+}
+
+// current returns the token, refreshed first if it is near expiry and can
+// be. A failed refresh leaves the old token to be tried while it lasts.
+func (c *Client) current(ctx context.Context) (Token, error) {
+	c.mu.Lock()
+	tok := c.token
+	c.mu.Unlock()
+	if tok.AccessToken == "" {
+		return Token{}, ErrNotLoggedIn
+	}
+	if tok.RefreshToken == "" || time.Now().Before(tok.ExpiresAt.Add(-c.RefreshBefore)) {
+		return tok, nil
+	}
+	fresh, err := c.refresh(ctx, tok)
+	if err != nil && time.Now().Before(tok.ExpiresAt) {
+		return tok, nil
+	}
+	return fresh, err
+}
+
+// refresh replaces stale, unless another caller already has.
+func (c *Client) refresh(ctx context.Context, stale Token) (Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token.AccessToken != stale.AccessToken {
+		return c.token, nil
+	}
+	if c.token.RefreshToken == "" {
+		return Token{}, errors.New("client: no refresh token")
+	}
+	tok, err := c.post(ctx, "/refresh", map[string]string{"refresh_token": c.token.RefreshToken})
+	if err != nil {
+		return Token{}, err
+	}
+	c.token = tok
+	return tok, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, body any) (Token, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return Token{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(b))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, readError(resp)
+	}
+
+	var tr struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		MFARequired  bool   `json:"mfa_required"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return Token{}, fmt.Errorf("client: decoding %s response: %w", path, err)
+	}
+	if tr.MFARequired {
+		return Token{}, ErrMFARequired
+	}
+	if tr.AccessToken == "" {
+		return Token{}, fmt.Errorf("client: no access token in %s response", path)
+	}
+	return Token{
+		AccessToken:  tr.AccessToken,
+		RefreshToken: tr.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// CheckResponse returns nil for a 2xx response and the *Error it carries
+// otherwise, reading the body.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return readError(resp)
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{Status: resp.StatusCode}
+	var body struct {
+		Error *Error `json:"error"`
+	}
+	body.Error = e
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	json.Unmarshal(b, &body)
+	return e
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeUsers is a user service issuing tokens access-1, access-2, ... that
+// each last expiresIn seconds.
+type fakeUsers struct {
+	expiresIn int
+	issued    atomic.Int32
+	refreshes atomic.Int32
+}
+
+func (f *fakeUsers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	switch r.URL.Path {
+	case "/login":
+		if body["password"] != "password" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"code":"invalid_credentials","request_id":"req-1"}}`)
+			return
+		}
+		if body["username"] == "mfa" {
+			fmt.Fprint(w, `{"mfa_required":true,"mfa_token":"x"}`)
+			return
+		}
+	case "/refresh":
+		f.refreshes.Add(1)
+		if !strings.HasPrefix(body["refresh_token"], "refresh-") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	n := f.issued.Add(1)
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  fmt.Sprintf("access-%d", n),
+		"refresh_token": fmt.Sprintf("refresh-%d", n),
+		"expires_in":    f.expiresIn,
+	})
+}
+
+// fakeAPI answers 200 with the request body to the tokens accept says are
+// valid, and 401 to everything else.
+func fakeAPI(accept func(token string) bool) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !accept(token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, token+" ")
+		io.Copy(w, r.Body)
+	}))
+	return srv, &calls
+}
+
+func loggedIn(t *testing.T, users *fakeUsers) *Client {
+	t.Helper()
+	srv := httptest.NewServer(users)
+	t.Cleanup(srv.Close)
+	c := New(srv.URL)
+	if _, err := c.Login(context.Background(), "alice", "password"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func call(t *testing.T, c *Client, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := c.Do(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestDoAttachesToken(t *testing.T) {
+	users := &fakeUsers{expiresIn: 3600}
+	c := loggedIn(t, users)
+	api, _ := fakeAPI(func(token string) bool { return token == "access-1" })
+	defer api.Close()
+
+	req, _ := http.NewRequest("GET", api.URL, nil)
+	if status, body := call(t, c, req); status != http.StatusOK || body != "access-1" {
+		t.Errorf("got %d %q, want 200 from access-1", status, body)
+	}
+	if n := users.refreshes.Load(); n != 0 {
+		t.Errorf("%d refreshes of a fresh token", n)
+	}
+}
+
+func TestDoBeforeLogin(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://localhost", nil)
+	if _, err := New("http://localhost").Do(context.Background(), req); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("Do before Login: %v, want ErrNotLoggedIn", err)
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	srv := httptest.NewServer(&fakeUsers{expiresIn: 3600})
+	defer srv.Close()
+	c := New(srv.URL)
+
+	_, err := c.Login(context.Background(), "alice", "wrong")
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusUnauthorized || e.Code != "invalid_credentials" || e.RequestID != "req-1" {
+		t.Errorf("wrong password: %#v", err)
+	}
+	if _, err := c.Login(context.Background(), "mfa", "password"); !errors.Is(err, ErrMFARequired) {
+		t.Errorf("two-factor user: %v, want ErrMFARequired", err)
+	}
+	if c.Token().AccessToken != "" {
+		t.Error("a failed login left a token")
+	}
+}
+
+func TestRefreshNearExpiry(t *testing.T) {
+	users := &fakeUsers{expiresIn: 30} // within the default minute
+	c := loggedIn(t, users)
+	api, _ := fakeAPI(func(token string) bool { return token == "access-2" })
+	defer api.Close()
+
+	req, _ := http.NewRequest("GET", api.URL, nil)
+	if status, body := call(t, c, req); status != http.StatusOK || body != "access-2" {
+		t.Errorf("got %d %q, want 200 from the refreshed access-2", status, body)
+	}
+	if n := users.refreshes.Load(); n != 1 {
+		t.Errorf("%d refreshes, want 1", n)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	users := &fakeUsers{expiresIn: 30}
+	c := loggedIn(t, users)
+	users.expiresIn = 3600 // the refreshed token is good for long
+	api, _ := fakeAPI(func(token string) bool { return token == "access-2" })
+	defer api.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", api.URL, nil)
+			resp, err := c.Do(context.Background(), req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := users.refreshes.Load(); n != 1 {
+		t.Errorf("%d refreshes by concurrent callers, want 1", n)
+	}
+}
+
+func TestRetryOnceAfter401(t *testing.T) {
+	users := &fakeUsers{expiresIn: 3600}
+	c := loggedIn(t, users)
+	// the server stopped taking access-1 early, e.g. it was revoked
+	api, calls := fakeAPI(func(token string) bool { return token == "access-2" })
+	defer api.Close()
+
+	req, _ := http.NewRequest("POST", api.URL, strings.NewReader("payload"))
+	if status, body := call(t, c, req); status != http.StatusOK || body != "access-2 payload" {
+		t.Errorf("got %d %q, want 200 with the body replayed", status, body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d calls, want 2", n)
+	}
+}
+
+func TestRetryOnlyOnce(t *testing.T) {
+	users := &fakeUsers{expiresIn: 3600}
+	c := loggedIn(t, users)
+	api, calls := fakeAPI(func(string) bool { return false })
+	defer api.Close()
+
+	req, _ := http.NewRequest("GET", api.URL, nil)
+	if status, _ := call(t, c, req); status != http.StatusUnauthorized {
+		t.Errorf("status %d, want the 401", status)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d calls, want one retry", n)
+	}
+	if n := users.refreshes.Load(); n != 1 {
+		t.Errorf("%d refreshes, want 1", n)
+	}
+}
+
+func TestNoRetryWithUnreplayableBody(t *testing.T) {
+	c := loggedIn(t, &fakeUsers{expiresIn: 3600})
+	api, calls := fakeAPI(func(string) bool { return false })
+	defer api.Close()
+
+	req, _ := http.NewRequest("POST", api.URL, io.NopCloser(strings.NewReader("once")))
+	if status, _ := call(t, c, req); status != http.StatusUnauthorized {
+		t.Errorf("status %d, want the 401", status)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls for a body that cannot be sent again, want 1", n)
+	}
+}
@@ -0,0 +1,152 @@
+// Command client runs the whole flow against the two services: it logs in
+// at the user service, then calls the server's /hello with the token and
+// prints both responses:
+//
+//	go run ./cmd/client -username "John Doe" -password password
+//	go run ./cmd/client -refresh   # then refresh the token and call again
+//
+// It exits with 0 on success, 2 on bad usage, 3 when a service cannot be
+// reached, 4 for wrong credentials, 5 for an expired token, 6 when the
+// token is otherwise refused and 1 on any other error.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"auth/client"
+	"auth/config"
+	"auth/httpx"
+)
+
+const (
+	exitError          = 1
+	exitUsage          = 2
+	exitUnreachable    = 3
+	exitBadCredentials = 4
+	exitTokenExpired   = 5
+	exitRefused        = 6
+)
+
+// apiPrefix is where both services mount their API.
+const apiPrefix = "/api/v1"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Client is a client.Client that also knows the server.
+type Client struct {
+	*client.Client
+	ServerURL string
+}
+
+// Greet calls the server's /hello and returns its greeting.
+func (c *Client) Greet(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.ServerURL+"/hello", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := client.CheckResponse(resp); err != nil {
+		return "", err
+	}
+	var greeting string
+	if err := json.NewDecoder(resp.Body).Decode(&greeting); err != nil {
+		return "", fmt.Errorf("decoding greeting: %w", err)
+	}
+	return greeting, nil
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := config.NewFlagSet("client", os.Getenv)
+	var userURL, serverURL, username, password string
+	var refresh bool
+	var timeout time.Duration
+	fs.String(&userURL, "user-url", "USER_SERVICE_URL", "http://localhost:8080", "user service base URL")
+	fs.String(&serverURL, "server-url", "SERVER_URL", "http://localhost:8081", "server base URL")
+	fs.String(&username, "username", "CLIENT_USERNAME", "John Doe", "user to log in as")
+	fs.String(&password, "password", "CLIENT_PASSWORD", "password", "password to log in with")
+	fs.Bool(&refresh, "refresh", "CLIENT_REFRESH", false, "refresh the token after the first call and call again")
+	fs.Duration(&timeout, "timeout", "CLIENT_TIMEOUT", 10*time.Second, "deadline for the whole flow")
+	if err := fs.Parse(args); err != nil {
+		fs.Usage(stderr, err)
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := &Client{
+		Client:    client.New(strings.TrimSuffix(userURL, "/") + apiPrefix),
+		ServerURL: strings.TrimSuffix(serverURL, "/") + apiPrefix,
+	}
+
+	tok, err := c.Login(ctx, username, password)
+	if err != nil {
+		return fail(stderr, "login", err)
+	}
+	printToken(stdout, "login", tok)
+	if err := greet(ctx, c, stdout); err != nil {
+		return fail(stderr, "hello", err)
+	}
+
+	if refresh {
+		tok, err := c.Refresh(ctx)
+		if err != nil {
+			return fail(stderr, "refresh", err)
+		}
+		printToken(stdout, "refresh", tok)
+		if err := greet(ctx, c, stdout); err != nil {
+			return fail(stderr, "hello", err)
+		}
+	}
+	return 0
+}
+
+func greet(ctx context.Context, c *Client, stdout io.Writer) error {
+	greeting, err := c.Greet(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "hello: %s\n", greeting)
+	return nil
+}
+
+func printToken(w io.Writer, step string, tok client.Token) {
+	fmt.Fprintf(w, "%s: access token %s (expires %s)\n", step, tok.AccessToken, tok.ExpiresAt.Format(time.RFC3339))
+	if tok.RefreshToken != "" {
+		fmt.Fprintf(w, "%s: refresh token %s\n", step, tok.RefreshToken)
+	}
+}
+
+// fail reports err of step and returns the exit code telling its kind.
+func fail(stderr io.Writer, step string, err error) int {
+	fmt.Fprintf(stderr, "%s: %v\n", step, err)
+	var apiErr *client.Error
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == httpx.CodeInvalidCredentials:
+		return exitBadCredentials
+	case errors.As(err, &apiErr) && apiErr.Code == httpx.CodeTokenExpired:
+		return exitTokenExpired
+	case errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden):
+		return exitRefused
+	case errors.As(err, &opErr), errors.As(err, &netErr) && netErr.Timeout():
+		return exitUnreachable
+	}
+	return exitError
+}
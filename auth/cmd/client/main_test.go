@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auth/client"
+)
+
+// services starts a user service that takes "password" and a server
+// answering /hello with refusal, or the greeting when refusal is empty.
+func services(t *testing.T, refusal string) (users, server *httptest.Server) {
+	t.Helper()
+	users = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/login" && body["password"] == "password":
+			fmt.Fprint(w, `{"access_token":"access-1","refresh_token":"refresh-1","expires_in":3600}`)
+		case r.URL.Path == "/api/v1/refresh" && body["refresh_token"] == "refresh-1":
+			fmt.Fprint(w, `{"access_token":"access-2","refresh_token":"refresh-2","expires_in":3600}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"code":"invalid_credentials"}}`)
+		}
+	}))
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/hello" || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer access-") {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"code":"token_invalid"}}`)
+			return
+		}
+		if refusal != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"error":{"code":%q}}`, refusal)
+			return
+		}
+		json.NewEncoder(w).Encode("hi " + strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	}))
+	t.Cleanup(users.Close)
+	t.Cleanup(server.Close)
+	return users, server
+}
+
+func TestClientLoginAndGreet(t *testing.T) {
+	users, server := services(t, "")
+	c := &Client{Client: client.New(users.URL + apiPrefix), ServerURL: server.URL + apiPrefix}
+	ctx := context.Background()
+
+	tok, err := c.Login(ctx, "John Doe", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-1" || tok.RefreshToken != "refresh-1" {
+		t.Errorf("token %+v", tok)
+	}
+	greeting, err := c.Greet(ctx)
+	if err != nil || greeting != "hi access-1" {
+		t.Errorf("Greet = %q, %v", greeting, err)
+	}
+
+	_, err = c.Login(ctx, "John Doe", "wrong")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != "invalid_credentials" {
+		t.Errorf("wrong password: %v", err)
+	}
+}
+
+func TestClientGreetRefused(t *testing.T) {
+	users, server := services(t, "token_expired")
+	c := &Client{Client: client.New(users.URL + apiPrefix), ServerURL: server.URL + apiPrefix}
+	if _, err := c.Login(context.Background(), "John Doe", "password"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Greet(context.Background())
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || apiErr.Code != "token_expired" {
+		t.Errorf("Greet: %v, want a token_expired error", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for _, tc := range []struct {
+		name    string
+		refusal string
+		args    []string
+		want    int
+		output  []string
+	}{
+		{"greeted", "", nil, 0, []string{"login: access token access-1", "hello: hi access-1"}},
+		{"refresh", "", []string{"-refresh"}, 0, []string{"refresh: access token access-2", "hello: hi access-2"}},
+		{"wrong password", "", []string{"-password", "wrong"}, exitBadCredentials, nil},
+		{"expired token", "token_expired", nil, exitTokenExpired, nil},
+		{"refused token", "insufficient_scope", nil, exitRefused, nil},
+		{"unreachable", "", []string{"-user-url", closed.URL}, exitUnreachable, nil},
+		{"bad flag", "", []string{"-nope"}, exitUsage, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			users, server := services(t, tc.refusal)
+			args := append([]string{"-user-url", users.URL, "-server-url", server.URL}, tc.args...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, &stdout, &stderr); code != tc.want {
+				t.Errorf("exit code %d, want %d: %s", code, tc.want, stderr.String())
+			}
+			for _, want := range tc.output {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output lacks %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
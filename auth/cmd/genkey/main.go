@@ -0,0 +1,89 @@
+// Command genkey generates key material for the services:
+//
+//	go run ./cmd/genkey -alg eddsa -out jwt
+//
+// writes jwt.key (private, for the user service) and jwt.pub (public, for
+// the server). For hs256 a single jwt.secret is written, and for a256gcm
+// (token encryption) a single jwt.jwe.
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	alg := flag.String("alg", "hs256", "algorithm: hs256, rs256, eddsa or a256gcm")
+	out := flag.String("out", "jwt", "output file prefix")
+	flag.Parse()
+
+	err := generate(strings.ToLower(*alg), *out)
+	if errors.Is(err, errUnknownAlg) {
+		fmt.Fprintf(os.Stderr, "unknown algorithm %q\n", *alg)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+}
+
+var errUnknownAlg = errors.New("unknown algorithm")
+
+// generate writes the key material for alg to files named after out.
+func generate(alg, out string) error {
+	switch alg {
+	case "hs256":
+		return writeSecret(out + ".secret")
+	case "a256gcm":
+		return writeSecret(out + ".jwe")
+	case "rs256":
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		return writeKeyPair(out, priv, &priv.PublicKey)
+	case "eddsa":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		return writeKeyPair(out, priv, pub)
+	}
+	return errUnknownAlg
+}
+
+func writeSecret(path string) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(b)+"\n"), 0o600)
+}
+
+func writeKeyPair(prefix string, priv crypto.PrivateKey, pub crypto.PublicKey) error {
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(prefix+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(prefix+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644)
+}
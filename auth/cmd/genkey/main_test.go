@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"auth"
+	"auth/tokentest"
+)
+
+func TestGenerateEdDSA(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "jwt")
+	if err := generate("eddsa", out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the user service signs with the private key...
+	signing, err := auth.LoadSigningKeyring("EdDSA", out+".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signing.Sign(tokentest.Claims("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...and the server verifies with the public key, from its file or from
+	// the user service's JWKS
+	fromFile, err := auth.LoadVerificationKeyring("EdDSA", out+".pub", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(auth.JWKSHandler(signing))
+	defer jwks.Close()
+	fromJWKS, err := auth.LoadJWKSKeyring("EdDSA", jwks.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, keys := range map[string]*auth.Keyring{"public key file": fromFile, "JWKS": fromJWKS} {
+		claims, err := auth.ParseToken(token, keys)
+		if err != nil {
+			t.Errorf("verifying with the %s: %v", name, err)
+			continue
+		}
+		if claims.Subject != "alice" {
+			t.Errorf("verifying with the %s: sub = %q", name, claims.Subject)
+		}
+	}
+	if kty := signing.JWKS().Keys[0].Kty; kty != "OKP" {
+		t.Errorf("published kty = %q, want OKP", kty)
+	}
+
+	if _, err := auth.ParseToken(token, auth.StaticKeyring([]byte(auth.DefaultSecret))); err == nil {
+		t.Error("an EdDSA token verifies with an HS256 secret")
+	}
+}
+
+func TestGenerateUnknownAlg(t *testing.T) {
+	if err := generate("hs512", filepath.Join(t.TempDir(), "jwt")); err != errUnknownAlg {
+		t.Errorf("err = %v, want errUnknownAlg", err)
+	}
+}
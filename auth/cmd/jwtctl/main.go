@@ -0,0 +1,243 @@
+// Command jwtctl mints and inspects tokens offline, with the same keys as
+// the services:
+//
+//	go run ./cmd/jwtctl sign -sub alice -ttl 1h -roles admin -scope greet:read
+//	go run ./cmd/jwtctl verify <token>
+//	go run ./cmd/jwtctl decode <token>
+//
+// Keys are given like the services' (-alg, -secret, -secret-file, -key-file,
+// or JWT_ALG, JWT_SECRET, ... in the environment); without any the
+// tutorial's hardcoded HS256 secret is used. verify exits with status 1 for
+// an invalid or expired token, every command with 2 on bad usage.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"auth"
+	"auth/config"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+const usage = "usage: jwtctl sign|verify|decode [flags] [token]"
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "sign":
+		return runSign(args[1:], stdout, stderr)
+	case "verify":
+		return runVerify(args[1:], stdout, stderr)
+	case "decode":
+		return runDecode(args[1:], stdout, stderr)
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n%s\n", args[0], usage)
+	return 2
+}
+
+// keyFlags are the key settings shared by sign and verify.
+type keyFlags struct {
+	alg, secret, secretFile, keyFile string
+}
+
+func (k *keyFlags) register(fs *config.FlagSet) {
+	fs.String(&k.alg, "alg", "JWT_ALG", "HS256", "signing algorithm: "+strings.Join(auth.Algorithms(), ", "))
+	fs.String(&k.secret, "secret", "JWT_SECRET", "", "HS256 secret (defaults to the tutorial secret)")
+	fs.String(&k.secretFile, "secret-file", "JWT_SECRET_FILE", "", "file holding the HS256 secret")
+	fs.String(&k.keyFile, "key-file", "JWT_KEY_FILE", "", "RS256/EdDSA key file: private to sign, public or private to verify")
+}
+
+func (k *keyFlags) path() (string, error) {
+	switch {
+	case k.alg == "HS256":
+		return k.secretFile, nil
+	case k.keyFile == "":
+		return "", fmt.Errorf("%s needs -key-file", k.alg)
+	}
+	return k.keyFile, nil
+}
+
+func (k *keyFlags) static() *auth.Keyring {
+	secret := k.secret
+	if secret == "" {
+		secret = auth.DefaultSecret
+	}
+	return auth.StaticKeyring([]byte(secret))
+}
+
+func (k *keyFlags) signing() (*auth.Keyring, error) {
+	path, err := k.path()
+	if err != nil || path == "" {
+		return k.static(), err
+	}
+	return auth.LoadSigningKeyring(k.alg, path)
+}
+
+// verification also accepts a private key file, which holds the public key.
+func (k *keyFlags) verification() (*auth.Keyring, error) {
+	path, err := k.path()
+	if err != nil || path == "" {
+		return k.static(), err
+	}
+	keys, err := auth.LoadVerificationKeyring(k.alg, path, 0)
+	if err != nil {
+		if priv, privErr := auth.LoadSigningKeyring(k.alg, path); privErr == nil {
+			return priv, nil
+		}
+	}
+	return keys, err
+}
+
+func parseFlags(fs *config.FlagSet, args []string, stderr io.Writer) bool {
+	if err := fs.Parse(args); err != nil {
+		fs.Usage(stderr, err)
+		return false
+	}
+	return true
+}
+
+func runSign(args []string, stdout, stderr io.Writer) int {
+	fs := config.NewFlagSet("jwtctl sign", os.Getenv)
+	var keys keyFlags
+	keys.register(fs)
+	var sub, roles, scope string
+	var audience []string
+	var ttl time.Duration
+	fs.StringVar(&sub, "sub", "", "subject (username)")
+	fs.DurationVar(&ttl, "ttl", time.Hour, "token lifetime")
+	fs.StringVar(&roles, "roles", "", "comma-separated roles")
+	fs.StringVar(&scope, "scope", "", "space-separated scopes")
+	fs.Func("aud", "audience the token is for (repeat for several)", func(aud string) error {
+		audience = append(audience, aud)
+		return nil
+	})
+	if !parseFlags(fs, args, stderr) {
+		return 2
+	}
+	if sub == "" || ttl <= 0 || fs.NArg() > 0 {
+		fs.Usage(stderr, errors.New("need -sub and a positive -ttl, and no arguments"))
+		return 2
+	}
+
+	keyring, err := keys.signing()
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 2
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 1
+	}
+	now := time.Now()
+	claims := auth.Claims{
+		Scope:   scope,
+		Version: auth.ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Subject:   sub,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	if roles != "" {
+		claims.Roles = strings.Split(roles, ",")
+	}
+	signed, err := keyring.Sign(claims)
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, signed)
+	return 0
+}
+
+func runVerify(args []string, stdout, stderr io.Writer) int {
+	fs := config.NewFlagSet("jwtctl verify", os.Getenv)
+	var keys keyFlags
+	keys.register(fs)
+	if !parseFlags(fs, args, stderr) {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage(stderr, errors.New("need exactly one token"))
+		return 2
+	}
+	keyring, err := keys.verification()
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 2
+	}
+
+	token := fs.Arg(0)
+	header, claims, err := decode(token)
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 1
+	}
+	printJSON(stdout, "header", header)
+	printJSON(stdout, "claims", claims)
+
+	parsed, err := auth.ParseToken(token, keyring)
+	if err != nil {
+		fmt.Fprintln(stdout, "result: INVALID:", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "result: valid")
+	if parsed.ExpiresAt != nil {
+		fmt.Fprintln(stdout, "expires in:", time.Until(parsed.ExpiresAt.Time).Round(time.Second))
+	}
+	return 0
+}
+
+func runDecode(args []string, stdout, stderr io.Writer) int {
+	fs := config.NewFlagSet("jwtctl decode", os.Getenv)
+	if !parseFlags(fs, args, stderr) {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage(stderr, errors.New("need exactly one token"))
+		return 2
+	}
+	header, claims, err := decode(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, "ERROR:", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, "UNVERIFIED: the signature was not checked, do not trust these claims")
+	printJSON(stdout, "header", header)
+	printJSON(stdout, "claims", claims)
+	return 0
+}
+
+// decode splits a token into header and claims without checking anything.
+func decode(token string) (map[string]interface{}, jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	t, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.Header, claims, nil
+}
+
+func printJSON(w io.Writer, label string, v interface{}) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintf(w, "%s: %s\n", label, b)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"auth"
+	"auth/tokentest"
+)
+
+// jwtctl runs the command with args and returns its exit code and output.
+func jwtctl(args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func sign(t *testing.T, args ...string) string {
+	t.Helper()
+	code, stdout, stderr := jwtctl(append([]string{"sign"}, args...)...)
+	if code != 0 {
+		t.Fatalf("sign exited with %d: %s", code, stderr)
+	}
+	return strings.TrimSpace(stdout)
+}
+
+func TestSignAudience(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"sign", "-sub", "alice", "-aud", "server", "-aud", "reports"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("sign exited with %d: %s", code, stderr.String())
+	}
+	claims, err := auth.ParseToken(strings.TrimSpace(stdout.String()), auth.StaticKeyring([]byte(auth.DefaultSecret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"server", "reports"}; !slices.Equal(claims.Audience, want) {
+		t.Errorf("aud = %v, want %v", claims.Audience, want)
+	}
+}
+
+func TestSignWithoutAudience(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"sign", "-sub", "alice"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("sign exited with %d: %s", code, stderr.String())
+	}
+	claims, err := auth.ParseToken(strings.TrimSpace(stdout.String()), auth.StaticKeyring([]byte(auth.DefaultSecret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Audience) != 0 {
+		t.Errorf("aud = %v, want none", claims.Audience)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	token := sign(t, "-sub", "alice", "-ttl", "1h", "-secret", "cli-secret", "-roles", "admin,user")
+	claims, err := auth.ParseToken(token, auth.StaticKeyring([]byte("cli-secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || !slices.Equal(claims.Roles, []string{"admin", "user"}) || claims.ID == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	code, stdout, stderr := jwtctl("verify", "-secret", "cli-secret", token)
+	if code != 0 {
+		t.Fatalf("verify exited with %d: %s%s", code, stdout, stderr)
+	}
+	for _, want := range []string{`header: {`, `"alg": "HS256"`, `"sub": "alice"`, "result: valid", "expires in: "} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("verify output lacks %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := map[string][]string{
+		"wrong secret": {"-secret", "other-secret", sign(t, "-sub", "alice", "-secret", "cli-secret")},
+		"expired":      {"-secret", string(tokentest.Secret), tokentest.ExpiredToken("alice")},
+		"none alg":     {"-secret", string(tokentest.Secret), tokentest.NoneAlgToken("alice")},
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, stdout, _ := jwtctl(append([]string{"verify"}, args...)...)
+			if code != 1 || !strings.Contains(stdout, "result: INVALID") {
+				t.Errorf("verify exited with %d:\n%s", code, stdout)
+			}
+		})
+	}
+	if code, _, _ := jwtctl("verify", "not-a-token"); code != 1 {
+		t.Errorf("verifying garbage exited with %d, want 1", code)
+	}
+}
+
+func TestSignAndVerifyRS256(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	keyFile := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	token := sign(t, "-alg", "RS256", "-key-file", keyFile, "-sub", "alice")
+	if code, stdout, stderr := jwtctl("verify", "-alg", "RS256", "-key-file", keyFile, token); code != 0 {
+		t.Errorf("verify exited with %d: %s%s", code, stdout, stderr)
+	}
+	if code, _, _ := jwtctl("sign", "-alg", "RS256", "-sub", "alice"); code != 2 {
+		t.Errorf("RS256 without -key-file exited with %d, want 2", code)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// decode shows claims it cannot verify, expired or not
+	code, stdout, stderr := jwtctl("decode", tokentest.ExpiredToken("alice", tokentest.WithRoles("admin")))
+	if code != 0 {
+		t.Fatalf("decode exited with %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "UNVERIFIED") {
+		t.Errorf("decode output is not labeled unverified:\n%s", stdout)
+	}
+	for _, want := range []string{`"sub": "alice"`, `"admin"`} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("decode output lacks %q:\n%s", want, stdout)
+		}
+	}
+	if code, _, _ := jwtctl("decode", "not-a-token"); code != 1 {
+		t.Errorf("decoding garbage exited with %d, want 1", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"frobnicate"}, {"sign"}, {"verify"}, {"decode", "a", "b"}} {
+		if code, _, _ := jwtctl(args...); code != 2 {
+			t.Errorf("jwtctl %v exited with %d, want 2", args, code)
+		}
+	}
+}
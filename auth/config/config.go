@@ -0,0 +1,135 @@
+// Package config defines command-line flags that fall back to environment
+// variables, so every setting resolves as flag > env > default.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// FlagSet wraps flag.FlagSet; its flags take their defaults from the
+// environment when the matching variable is set.
+type FlagSet struct {
+	*flag.FlagSet
+	getenv func(string) string
+	errs   []error
+}
+
+// NewFlagSet returns a FlagSet named name that reads the environment
+// through getenv (normally os.Getenv).
+func NewFlagSet(name string, getenv func(string) string) *FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return &FlagSet{FlagSet: fs, getenv: getenv}
+}
+
+func (f *FlagSet) String(p *string, name, env, def, usage string) {
+	if v := f.getenv(env); v != "" {
+		def = v
+	}
+	f.StringVar(p, name, def, usage+" (env "+env+")")
+}
+
+func (f *FlagSet) Duration(p *time.Duration, name, env string, def time.Duration, usage string) {
+	if v := f.getenv(env); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Errorf("invalid %s: %w", env, err))
+		} else {
+			def = d
+		}
+	}
+	f.DurationVar(p, name, def, usage+" (env "+env+")")
+}
+
+// Time reads an RFC 3339 time such as 2026-11-01T00:00:00Z.
+func (f *FlagSet) Time(p *time.Time, name, env string, def time.Time, usage string) {
+	if v := f.getenv(env); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Errorf("invalid %s: %w", env, err))
+		} else {
+			def = t
+		}
+	}
+	f.TextVar(p, name, def, usage+" (env "+env+")")
+}
+
+func (f *FlagSet) Int(p *int, name, env string, def int, usage string) {
+	if v := f.getenv(env); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Errorf("invalid %s: %w", env, err))
+		} else {
+			def = n
+		}
+	}
+	f.IntVar(p, name, def, usage+" (env "+env+")")
+}
+
+func (f *FlagSet) Int64(p *int64, name, env string, def int64, usage string) {
+	if v := f.getenv(env); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Errorf("invalid %s: %w", env, err))
+		} else {
+			def = n
+		}
+	}
+	f.Int64Var(p, name, def, usage+" (env "+env+")")
+}
+
+func (f *FlagSet) Bool(p *bool, name, env string, def bool, usage string) {
+	if v := f.getenv(env); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Errorf("invalid %s: %w", env, err))
+		} else {
+			def = b
+		}
+	}
+	f.BoolVar(p, name, def, usage+" (env "+env+")")
+}
+
+// Parse parses args, reporting bad environment values as well as bad flags.
+func (f *FlagSet) Parse(args []string) error {
+	if len(f.errs) > 0 {
+		return f.errs[0]
+	}
+	return f.FlagSet.Parse(args)
+}
+
+// Usage prints err followed by the flag defaults to w.
+func (f *FlagSet) Usage(w io.Writer, err error) {
+	fmt.Fprintf(w, "%s: %v\n\nUsage of %s:\n", f.Name(), err, f.Name())
+	f.SetOutput(w)
+	f.PrintDefaults()
+	f.SetOutput(io.Discard)
+}
+
+// SetLogFormat switches the standard logger to "text" (the log package
+// default) or "json" lines.
+func SetLogFormat(format string) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		// slog.SetDefault also routes the log package through the handler
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q (want text or json)", format)
+}
+
+// Redact hides a secret when printing the configuration.
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"auth/httpx"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// DecodeResponse describes a token without trusting it.
+type DecodeResponse struct {
+	Header         map[string]interface{} `json:"header"`
+	Claims         jwt.MapClaims          `json:"claims"`
+	SignatureValid bool                   `json:"signature_valid"`
+	// ValidationError says why the token would be refused, signature and
+	// registered claims included; empty for a token that would be accepted.
+	ValidationError string `json:"validation_error,omitempty"`
+}
+
+// DecodeHandler serves POST {"token": "..."} with the token's header and
+// claims, decoded whether or not the signature checks out against keys. It
+// is a development aid: mount it only in dev mode. Key material is never
+// part of the answer.
+func DecodeHandler(keys Verifier) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if !httpx.DecodeJSON(w, r, &req, 1<<20) {
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		token, _, err := jwt.NewParser().ParseUnverified(req.Token, claims)
+		if err != nil {
+			httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "token is not a JWT")
+			return
+		}
+
+		resp := DecodeResponse{Header: token.Header, Claims: claims}
+		_, err = jwt.NewParser(jwt.WithoutClaimsValidation(), jwt.WithValidMethods(keys.Algorithms())).Parse(req.Token, keyFunc(keys))
+		resp.SignatureValid = err == nil
+		if _, err := ParseToken(req.Token, keys); err != nil {
+			resp.ValidationError = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func decodeRequest(t *testing.T, keys Verifier, token string) (*httptest.ResponseRecorder, DecodeResponse) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"token": token})
+	req := httptest.NewRequest("POST", "/debug/decode", strings.NewReader(string(body)))
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	DecodeHandler(keys).ServeHTTP(rec, req)
+	var resp DecodeResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, resp
+}
+
+func TestDecodeHandler(t *testing.T) {
+	secret := "debug-test-secret"
+	keys := StaticKeyring([]byte(secret))
+	token := mustSign(t, keys, testClaims("alice"))
+
+	rec, resp := decodeRequest(t, keys, token)
+	if !resp.SignatureValid || resp.ValidationError != "" || resp.Claims["sub"] != "alice" || resp.Header["alg"] != "HS256" {
+		t.Errorf("a valid token: %s", rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), secret) {
+		t.Error("the answer holds the secret")
+	}
+
+	// decoded all the same when the signature does not check out
+	other := mustSign(t, StaticKeyring([]byte("another-secret")), testClaims("mallory"))
+	rec, resp = decodeRequest(t, keys, other)
+	if resp.SignatureValid || resp.ValidationError == "" || resp.Claims["sub"] != "mallory" {
+		t.Errorf("a token signed with another key: %s", rec.Body)
+	}
+
+	if rec, _ := decodeRequest(t, keys, "not.a.jwt"); rec.Code != http.StatusBadRequest {
+		t.Errorf("not a JWT: %d %s", rec.Code, rec.Body)
+	}
+}
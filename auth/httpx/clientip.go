@@ -0,0 +1,112 @@
+package httpx
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks whose X-Forwarded-For entries are
+// believed. Anyone else can put whatever they like in the header.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a comma-separated list of CIDRs or single IPs.
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHTTPS reports whether the request reached us over TLS: directly, or
+// through a trusted proxy that says so in X-Forwarded-Proto. Of several
+// values only the last, added by the nearest proxy, counts.
+func (p TrustedProxies) IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !p.trusts(peer) {
+		return false
+	}
+	values := r.Header.Values("X-Forwarded-Proto")
+	if len(values) == 0 {
+		return false
+	}
+	protos := strings.Split(values[len(values)-1], ",")
+	return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+}
+
+// FromLoopback reports whether the request came straight from this host:
+// the peer is a loopback address and no proxy added X-Forwarded-For, as
+// one on this host relaying outside traffic would.
+func FromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	return peer != nil && peer.IsLoopback() && len(r.Header.Values("X-Forwarded-For")) == 0
+}
+
+// ClientIP returns the address the request came from. X-Forwarded-For is
+// only followed while the hop that added an entry is a trusted proxy: the
+// entries are walked from the nearest hop back, and the first address that
+// is not a trusted proxy is the client.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !p.trusts(peer) {
+		return host
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage; keep the last address a trusted proxy vouched for
+		}
+		client = ip
+		if !p.trusts(ip) {
+			break
+		}
+	}
+	return client.String()
+}
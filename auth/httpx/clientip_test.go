@@ -0,0 +1,114 @@
+package httpx
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, remote string
+		xff          []string
+		want         string
+	}{
+		{"no proxy", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", "10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted single IP", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"spoofed entry before the client", "10.1.2.3:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"garbage", "10.1.2.3:1234", []string{"not-an-ip"}, "10.1.2.3"},
+		{"trusted peer without header", "10.1.2.3:1234", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies(" 10.0.0.0/8,,::1 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 || proxies[1].String() != "::1/128" {
+		t.Errorf("parsed %v", proxies)
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/99"); err == nil {
+		t.Error("a bad CIDR parsed")
+	}
+}
+
+func TestIsHTTPS(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, remote string
+		proto        []string
+		want         bool
+	}{
+		{"cleartext", "203.0.113.7:1234", nil, false},
+		{"forwarded by a trusted proxy", "10.1.2.3:1234", []string{"https"}, true},
+		{"forwarded in upper case", "10.1.2.3:1234", []string{"HTTPS"}, true},
+		{"forwarded by an untrusted peer", "203.0.113.7:1234", []string{"https"}, false},
+		{"trusted proxy over http", "10.1.2.3:1234", []string{"http"}, false},
+		{"trusted proxy without header", "10.1.2.3:1234", nil, false},
+		{"spoofed entry before the proxy's", "10.1.2.3:1234", []string{"https, http"}, false},
+		{"several headers", "10.1.2.3:1234", []string{"http", "https"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.proto {
+				r.Header.Add("X-Forwarded-Proto", v)
+			}
+			if got := proxies.IsHTTPS(r); got != tt.want {
+				t.Errorf("IsHTTPS = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a TLS connection needs no proxy
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	if !TrustedProxies(nil).IsHTTPS(r) {
+		t.Error("a TLS request is not HTTPS")
+	}
+}
+
+func TestFromLoopback(t *testing.T) {
+	for remote, want := range map[string]bool{
+		"127.0.0.1:1234":   true,
+		"[::1]:1234":       true,
+		"203.0.113.7:1234": false,
+		"garbage":          false,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		if got := FromLoopback(r); got != want {
+			t.Errorf("FromLoopback from %s = %v, want %v", remote, got, want)
+		}
+	}
+	// a proxy on this host relaying someone else
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if FromLoopback(r) {
+		t.Error("a relayed request counts as coming from this host")
+	}
+}
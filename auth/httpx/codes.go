@@ -0,0 +1,76 @@
+package httpx
+
+// Code is a machine-readable error code, the "code" of an error response.
+// Codes are part of the API: clients switch on them, so a code never
+// changes its spelling or meaning once released.
+type Code string
+
+// Request problems.
+const (
+	CodeInvalidRequest   Code = "invalid_request"
+	CodeRequestTooLarge  Code = "request_too_large"
+	CodeHTTPSRequired    Code = "https_required"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeRateLimited      Code = "rate_limited"
+	CodeTimeout          Code = "timeout"
+	CodeInternalError    Code = "internal_error"
+	CodeUnavailable      Code = "unavailable" // a dependency is down; try again
+)
+
+// Authentication: who is calling could not be established.
+const (
+	CodeInvalidCredentials       Code = "invalid_credentials"
+	CodeMissingToken             Code = "missing_token"
+	CodeTokenInvalid             Code = "token_invalid"
+	CodeInvalidClaims            Code = "invalid_claims"
+	CodeTokenExpired             Code = "token_expired"
+	CodeTokenNotYetValid         Code = "token_not_yet_valid"
+	CodeTokenUsedBeforeIssued    Code = "token_used_before_issued"
+	CodeTokenRevoked             Code = "token_revoked"
+	CodeTokenStale               Code = "token_stale"
+	CodeTokenLifetime            Code = "token_lifetime_exceeded"
+	CodeTokenTooOld              Code = "token_too_old"
+	CodeLegacyClaims             Code = "legacy_claims"
+	CodeSessionExpired           Code = "session_expired" // past MAX_SESSION_AGE; log in again
+	CodeWrongAudience            Code = "wrong_audience"
+	CodeInvalidAPIKey            Code = "invalid_api_key"
+	CodeInvalidServiceCredential Code = "invalid_service_credential"
+	CodeInvalidPassword          Code = "invalid_password"
+	CodeInvalidCode              Code = "invalid_code"
+)
+
+// Authorization: the caller is known but may not do this.
+const (
+	CodeForbidden         Code = "forbidden"
+	CodeInsufficientRole  Code = "insufficient_role"
+	CodeInsufficientScope Code = "insufficient_scope"
+	CodeSubjectBlocked    Code = "subject_blocked"
+	CodeNotAUser          Code = "not_a_user"
+	CodeWrongAction       Code = "wrong_action"
+)
+
+// The token endpoint's codes, as in RFC 6749 section 5.2.
+const (
+	CodeInvalidGrant         Code = "invalid_grant"
+	CodeInvalidClient        Code = "invalid_client"
+	CodeInvalidScope         Code = "invalid_scope"
+	CodeUnsupportedGrantType Code = "unsupported_grant_type"
+	CodeInvalidTarget        Code = "invalid_target" // unknown audience (RFC 8707)
+)
+
+// Resource states.
+const (
+	CodeUserNotFound        Code = "user_not_found"
+	CodeUnknownSubject      Code = "unknown_subject"
+	CodeSessionNotFound     Code = "session_not_found"
+	CodeAPIKeyNotFound      Code = "api_key_not_found"
+	CodeUsernameTaken       Code = "username_taken"
+	CodeEmailTaken          Code = "email_taken"
+	CodeWeakPassword        Code = "weak_password"
+	CodePasswordTooLong     Code = "password_too_long"
+	CodeMFAAlreadyEnabled   Code = "mfa_already_enabled"
+	CodeNoPendingEnrollment Code = "no_pending_enrollment"
+	CodeTooManySessions     Code = "too_many_sessions"
+	CodeRotationUnsupported Code = "rotation_unsupported"
+)
@@ -0,0 +1,61 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	type login struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	for _, tc := range []struct {
+		name    string
+		body    string
+		status  int
+		code    Code
+		message string
+	}{
+		{"valid", `{"username":"alice","password":"pw"}`, http.StatusOK, "", ""},
+		{"too large", `{"username":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body must not exceed 64 bytes"},
+		{"empty", ``, http.StatusBadRequest, CodeInvalidRequest, "request body is empty"},
+		{"unknown field", `{"username":"alice","role":"admin"}`, http.StatusBadRequest, CodeInvalidRequest, `unknown field "role"`},
+		{"trailing garbage", `{"username":"alice"} {}`, http.StatusBadRequest, CodeInvalidRequest, "request body must contain a single JSON value"},
+		{"truncated", `{"username":"al`, http.StatusBadRequest, CodeInvalidRequest, "request body is not valid JSON"},
+		{"syntax error", `{username}`, http.StatusBadRequest, CodeInvalidRequest, "request body is not valid JSON"},
+		{"wrong type", `{"username":42}`, http.StatusBadRequest, CodeInvalidRequest, `field "username" must be a string`},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		var v login
+		ok := DecodeJSON(rec, req, &v, 64)
+
+		if tc.status == http.StatusOK {
+			if !ok || v.Username != "alice" {
+				t.Errorf("%s: ok = %v, decoded %+v (%s)", tc.name, ok, v, rec.Body)
+			}
+			continue
+		}
+		if ok || rec.Code != tc.status {
+			t.Errorf("%s: ok = %v, status %d; want %d", tc.name, ok, rec.Code, tc.status)
+			continue
+		}
+		var body struct {
+			Error struct {
+				Code    Code   `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if body.Error.Code != tc.code || body.Error.Message != tc.message {
+			t.Errorf("%s: got %+v, want %s %q", tc.name, body.Error, tc.code, tc.message)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Package httpx holds HTTP helpers shared by the services.
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Deprecated marks responses of legacy unversioned routes with a
+// Deprecation header and a Link to the same path under successor
+// (e.g. "/api/v1").
+func Deprecated(successor string) func(http.Handler) http.Handler {
+	successor = strings.TrimSuffix(successor, "/")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+r.URL.Path+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package httpx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// WriteError writes the line "code: message" or, to clients whose Accept
+// header asks for JSON (see prefersText), a JSON error of the form
+//
+//	{"error": {"code": "...", "message": "...", "request_id": "..."}}
+//
+// code is one of the Code constants; message is for humans and may be
+// empty.
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code Code, message string) {
+	WriteErrorDetails(w, r, status, code, message, nil)
+}
+
+// WriteErrorDetails is WriteError with extra fields in the JSON error
+// object, such as the list of unmet password requirements. The text/plain
+// form carries only code and message.
+func WriteErrorDetails(w http.ResponseWriter, r *http.Request, status int, code Code, message string, details map[string]any) {
+	if oauthErrors(r) {
+		writeOAuthError(w, status, code, message)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if prefersText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		if message == "" {
+			fmt.Fprintln(w, code)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", code, message)
+		}
+		return
+	}
+
+	body := map[string]any{}
+	for k, v := range details {
+		body[k] = v
+	}
+	body["code"] = string(code)
+	body["request_id"] = RequestIDFrom(r.Context())
+	if message != "" {
+		body["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": body})
+}
+
+const oauthErrorsKey contextKey = "oauth-errors"
+
+// WithOAuthErrors marks r as an OAuth 2.0 token request: WriteError then
+// answers it with the flat JSON error of RFC 6749 section 5.2,
+//
+//	{"error": "...", "error_description": "..."}
+//
+// whatever the Accept header, which is what OAuth client libraries parse.
+func WithOAuthErrors(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), oauthErrorsKey, true))
+}
+
+func oauthErrors(r *http.Request) bool {
+	on, _ := r.Context().Value(oauthErrorsKey).(bool)
+	return on
+}
+
+// writeOAuthError reports wrong credentials as invalid_grant, with the 400
+// the RFC gives it; other codes are sent as they are.
+func writeOAuthError(w http.ResponseWriter, status int, code Code, message string) {
+	if code == CodeInvalidCredentials {
+		status, code = http.StatusBadRequest, CodeInvalidGrant
+	}
+	body := map[string]string{"error": string(code)}
+	if message != "" {
+		body["error_description"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// prefersText reports whether the error should be plain text: unless the
+// Accept header names application/json, ranked at least as high as
+// text/plain. Without an Accept header, or with just */*, it is.
+func prefersText(r *http.Request) bool {
+	var jsonQ, textQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/plain", "text/*", "*/*":
+			textQ = max(textQ, q)
+		}
+	}
+	return jsonQ == 0 || jsonQ < textQ
+}
@@ -0,0 +1,79 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefersText(t *testing.T) {
+	for accept, text := range map[string]bool{
+		"":                                       true,
+		"*/*":                                    true,
+		"text/plain":                             true,
+		"text/html":                              true,
+		"application/json":                       false,
+		"application/json; charset=utf-8":        false,
+		"text/plain, application/json":           false,
+		"text/plain, application/json;q=0.9":     true,
+		"application/json;q=0.5, */*;q=0.1":      false,
+		"application/json;q=bad":                 true,
+		"application/problem+json, text/plain":   true,
+		"text/html, application/json;q=0.9, */*": true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", accept)
+		if got := prefersText(req); got != text {
+			t.Errorf("Accept %q: prefersText = %v, want %v", accept, got, text)
+		}
+	}
+}
+
+func TestWriteErrorFormats(t *testing.T) {
+	write := func(accept string, details map[string]any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		WriteErrorDetails(rec, req, http.StatusUnauthorized, CodeInvalidCredentials, "wrong password", details)
+		return rec
+	}
+
+	rec := write("application/json", map[string]any{"hint": "try again"})
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("JSON error: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if body.Error["code"] != "invalid_credentials" || body.Error["message"] != "wrong password" || body.Error["hint"] != "try again" {
+		t.Errorf("JSON error = %v", body.Error)
+	}
+
+	for _, accept := range []string{"", "text/plain"} {
+		rec := write(accept, map[string]any{"hint": "try again"})
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("Accept %q: %d %s", accept, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if got := rec.Body.String(); got != "invalid_credentials: wrong password\n" {
+			t.Errorf("Accept %q: body %q", accept, got)
+		}
+		if rec.Header().Get("Vary") != "Accept" {
+			t.Errorf("Accept %q: Vary = %q", accept, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestOAuthErrorsIgnoreAccept(t *testing.T) {
+	req := WithOAuthErrors(httptest.NewRequest("POST", "/token", nil))
+	rec := httptest.NewRecorder()
+	WriteError(rec, req, http.StatusUnauthorized, CodeInvalidCredentials, "")
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "{\"error\":\"invalid_grant\"}\n" {
+		t.Errorf("OAuth error: %d %s", rec.Code, rec.Body)
+	}
+}
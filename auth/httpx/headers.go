@@ -0,0 +1,48 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SecurityHeaders sets headers every response should carry: nosniff, and
+// Strict-Transport-Security on TLS connections when hstsMaxAge is positive.
+func SecurityHeaders(hstsMaxAge time.Duration) func(http.Handler) http.Handler {
+	hsts := fmt.Sprintf("max-age=%d; includeSubDomains", int(hstsMaxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if r.TLS != nil && hstsMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireHTTPS refuses requests that did not arrive over TLS (see
+// TrustedProxies.IsHTTPS) with 400 https_required, before passwords or
+// tokens are handled. Requests cleartextOK accepts (nil accepts none) may
+// use plain HTTP.
+func RequireHTTPS(proxies TrustedProxies, cleartextOK func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !proxies.IsHTTPS(r) && (cleartextOK == nil || !cleartextOK(r)) {
+				WriteError(w, r, http.StatusBadRequest, CodeHTTPSRequired, "use https")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NoStore keeps responses out of browser and proxy caches; use it on every
+// response that carries a token (RFC 6749 section 5.1).
+func NoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
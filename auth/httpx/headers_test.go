@@ -0,0 +1,67 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRequireHTTPS(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal := func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/internal/") }
+	h := RequireHTTPS(proxies, internal)(okHandler)
+	serve := func(remote, path, proto string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, nil)
+		r.RemoteAddr = remote
+		if proto != "" {
+			r.Header.Set("X-Forwarded-Proto", proto)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := serve("10.1.2.3:1234", "/login", "https"); rec.Code != http.StatusOK {
+		t.Errorf("forwarded https: %d %s", rec.Code, rec.Body)
+	}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"cleartext":         serve("203.0.113.7:1234", "/login", ""),
+		"untrusted forward": serve("203.0.113.7:1234", "/login", "https"),
+		"proxied cleartext": serve("10.1.2.3:1234", "/login", "http"),
+	} {
+		if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Body.String(), "https_required") {
+			t.Errorf("%s: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve("203.0.113.7:1234", "/internal/x", ""); rec.Code != http.StatusOK {
+		t.Errorf("cleartext the callback accepts: %d %s", rec.Code, rec.Body)
+	}
+	h = RequireHTTPS(proxies, nil)(okHandler)
+	if rec := serve("203.0.113.7:1234", "/internal/x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("cleartext without a callback: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	h := SecurityHeaders(time.Hour)(okHandler)
+	for url, hsts := range map[string]string{
+		"https://example.com/": "max-age=3600; includeSubDomains",
+		"http://example.com/":  "",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if got := rec.Header().Get("Strict-Transport-Security"); got != hsts {
+			t.Errorf("%s: Strict-Transport-Security = %q, want %q", url, got, hsts)
+		}
+		if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s: X-Content-Type-Options = %q", url, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+)
+
+var methods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// NotFound answers with a 404.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, r, http.StatusNotFound, CodeNotFound, "no such path")
+}
+
+// MethodNotAllowed answers a request whose path exists under other methods
+// with a 405 and an Allow header, and hands anything else to notFound.
+// routes reports whether a route serves the request as it is, e.g. a
+// router's Match. Install it for unmatched requests as well as method
+// mismatches: a router may only see that nothing matched.
+func MethodNotAllowed(routes func(*http.Request) bool, notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, m := range methods {
+			probe := r.Clone(r.Context())
+			probe.Method = m
+			if routes(probe) {
+				allowed = append(allowed, m)
+			}
+		}
+		if len(allowed) == 0 {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		WriteError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed here")
+	})
+}
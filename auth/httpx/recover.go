@@ -0,0 +1,63 @@
+package httpx
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panicking handler into a 500 instead of a dropped
+// connection, logging the stack trace with the request it happened on.
+// http.ErrAbortHandler is re-panicked so net/http can abort as usual.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			stack := debug.Stack()
+			if hp, ok := err.(handlerPanic); ok {
+				err, stack = hp.value, hp.stack
+			}
+			Log(r.Context()).Printf("ERROR: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, stack)
+			if tw.wroteHeader {
+				return // too late for a clean response
+			}
+			WriteError(w, r, http.StatusInternalServerError, CodeInternalError, "")
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// trackingWriter remembers whether the response has been started.
+type trackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *trackingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack hands over the connection, after which nothing may be written.
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,92 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the standard logger to a buffer for the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func getJSON(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLog(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // a nil map write
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	srv := httptest.NewServer(Standard(mux))
+	defer srv.Close()
+
+	resp, body := getJSON(t, srv.URL+"/panic")
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+	if !strings.Contains(body, `"code":"internal_error"`) {
+		t.Errorf("body = %s", body)
+	}
+	id := resp.Header.Get(RequestIDHeader)
+	if line := logs.String(); !strings.Contains(line, "panic serving GET /panic") || !strings.Contains(line, "request_id="+id) || !strings.Contains(line, "goroutine") {
+		t.Errorf("the panic is not logged with its request and stack:\n%s", line)
+	}
+
+	// the server goes on serving
+	if resp, body := getJSON(t, srv.URL+"/ok"); resp.StatusCode != http.StatusOK || body != "ok" {
+		t.Errorf("after the panic: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestRecoverAfterHeaders(t *testing.T) {
+	captureLog(t)
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.Len() != 0 {
+		t.Errorf("got %d %q, want the response left as it was", rec.Code, rec.Body)
+	}
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+	h := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	t.Error("http.ErrAbortHandler was swallowed")
+}
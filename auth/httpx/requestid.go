@@ -0,0 +1,69 @@
+package httpx
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request-id"
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID makes sure every request carries an id: a sane X-Request-ID
+// from the client is kept, anything else is replaced by a fresh UUID. The
+// id is stored in the context and echoed in the response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// RequestIDFrom returns the id RequestID stored in ctx, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Log returns a logger whose lines are prefixed with the request id.
+func Log(ctx context.Context) *log.Logger {
+	id := RequestIDFrom(ctx)
+	if id == "" {
+		return log.Default()
+	}
+	return log.New(log.Writer(), "request_id="+id+" ", log.Flags()|log.Lmsgprefix)
+}
+
+// validRequestID accepts up to 128 characters of [A-Za-z0-9._-], which
+// covers UUIDs and most tracing ids while keeping logs clean.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
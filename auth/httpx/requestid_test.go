@@ -0,0 +1,61 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// failing answers every request with a logged error response.
+var failing = Standard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	Log(r.Context()).Println("ERROR: something failed")
+	WriteError(w, r, http.StatusBadRequest, CodeInvalidRequest, "")
+}))
+
+func serveWithID(id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.Header.Set("Accept", "application/json")
+	if id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	rec := httptest.NewRecorder()
+	failing.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestIDPassedThrough(t *testing.T) {
+	logs := captureLog(t)
+	rec := serveWithID("client-id.42")
+
+	if got := rec.Header().Get(RequestIDHeader); got != "client-id.42" {
+		t.Errorf("%s = %q, want the client's", RequestIDHeader, got)
+	}
+	if !strings.Contains(rec.Body.String(), `"request_id":"client-id.42"`) {
+		t.Errorf("the error body lacks the id: %s", rec.Body)
+	}
+	// the handler's line and the request line both carry it
+	if n := strings.Count(logs.String(), "request_id=client-id.42 "); n != 2 {
+		t.Errorf("the id is on %d log lines, want 2:\n%s", n, logs)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	for name, id := range map[string]string{
+		"absent":    "",
+		"too long":  strings.Repeat("a", 129),
+		"bad chars": "id with spaces\n",
+	} {
+		logs := captureLog(t)
+		got := serveWithID(id).Header().Get(RequestIDHeader)
+		if !uuidPattern.MatchString(got) {
+			t.Errorf("%s: %s = %q, want a UUID", name, RequestIDHeader, got)
+		}
+		if !strings.Contains(logs.String(), "request_id="+got+" ") {
+			t.Errorf("%s: the generated id is not logged:\n%s", name, logs)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package httpx
+
+import "net/http"
+
+// Standard wraps a service's whole router in the middleware every service
+// runs. It goes around the router rather than into mux's Use, which only
+// sees matched routes; Recover sits inside RequestID so the 500 it writes
+// and the stack it logs carry the request id, and around everything else,
+// auth middleware included.
+func Standard(h http.Handler) http.Handler {
+	return RequestID(LogRequests(Recover(h)))
+}
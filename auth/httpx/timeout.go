@@ -0,0 +1,135 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout gives every request a deadline of d. Store calls and outgoing
+// requests made with the request context give up once it passes, and the
+// client gets a 503 instead of waiting on a handler that has not
+// answered. The response is buffered until the handler returns, so this
+// does not suit streaming responses; WebSocket handshakes are passed through
+// without a deadline. A d of zero disables the deadline.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isWebSocketHandshake(r) {
+				next.ServeHTTP(w, r) // the connection outlives any deadline
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						if p != http.ErrAbortHandler {
+							p = handlerPanic{value: p, stack: debug.Stack()}
+						}
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p) // for Recover, which cannot see the handler's goroutine or its stack
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				for k, v := range tw.header {
+					w.Header()[k] = v
+				}
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+				w.WriteHeader(tw.status)
+				w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					Log(ctx).Printf("ERROR: %s %s did not finish within %s", r.Method, r.URL.Path, d)
+					WriteError(w, r, http.StatusServiceUnavailable, CodeTimeout, "the request took too long")
+				}
+			}
+		})
+	}
+}
+
+// handlerPanic carries a panic out of the goroutine Timeout runs the
+// handler in, with the stack it happened on for Recover to log.
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+// isWebSocketHandshake reports whether r asks to switch to the WebSocket
+// protocol: Connection lists upgrade and Upgrade lists websocket.
+func isWebSocketHandshake(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// timeoutWriter buffers a response until the handler is done; once the
+// deadline has passed further writes fail.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.status != 0 {
+		return
+	}
+	w.status = code
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
@@ -0,0 +1,84 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForDeadline blocks until the request context is done, as a store call
+// honouring it would.
+var waitForDeadline = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+	w.Write([]byte("too late"))
+})
+
+func TestTimeout(t *testing.T) {
+	captureLog(t)
+	h := Timeout(50 * time.Millisecond)(waitForDeadline)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	h.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("answered after %v", elapsed)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"code":"timeout"`) {
+		t.Errorf("got %d %s, want 503 timeout", rec.Code, rec.Body)
+	}
+}
+
+func TestTimeoutFastHandler(t *testing.T) {
+	h := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("the request context has no deadline")
+		}
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusCreated || rec.Body.String() != "done" || rec.Header().Get("X-Test") != "yes" {
+		t.Errorf("got %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	logs := captureLog(t)
+	h := Standard(Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("in the handler goroutine")
+	})))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	// the stack logged is the handler's, not that of the re-panic
+	if !strings.Contains(logs.String(), "TestTimeoutPanic") {
+		t.Errorf("the handler's stack is not logged:\n%s", logs)
+	}
+}
+
+func TestTimeoutWebSocket(t *testing.T) {
+	h := Timeout(time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("a WebSocket handshake got a deadline")
+		}
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSwitchingProtocols {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
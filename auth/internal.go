@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"auth/httpx"
+)
+
+// ServiceSecretHeader carries the secret services share to call each
+// other's internal routes.
+const ServiceSecretHeader = "X-Service-Secret"
+
+// RequireServiceSecret lets through only requests carrying secret in
+// ServiceSecretHeader, answering others with 401 invalid_service_credential.
+// An empty secret lets everything through, for dev mode.
+func RequireServiceSecret(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get(ServiceSecretHeader)
+			if secret != "" && subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+				httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidServiceCredential, "")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ServiceClient returns an HTTP client for another service's internal
+// routes: it sends secret (when set) with every request and gives up after
+// timeout.
+func ServiceClient(secret string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: serviceTransport{secret: secret, next: http.DefaultTransport},
+	}
+}
+
+type serviceTransport struct {
+	secret string
+	next   http.RoundTripper
+}
+
+func (t serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.secret == "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context()) // a RoundTripper must not change the request
+	req.Header.Set(ServiceSecretHeader, t.secret)
+	return t.next.RoundTrip(req)
+}
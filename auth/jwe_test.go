@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// testEncryption loads a key file holding key 32 times over.
+func testEncryption(t *testing.T, key byte) *TokenEncryption {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwe.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString([]byte(strings.Repeat(string(key), 32)))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := LoadTokenEncryption(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func TestLoadTokenEncryptionRejectsBadKeys(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"short":   hex.EncodeToString(make([]byte, 16)),
+		"not hex": strings.Repeat("zz", 32),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTokenEncryption(path); err == nil {
+			t.Errorf("%s key loaded", name)
+		}
+	}
+	if _, err := LoadTokenEncryption(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing key file loaded")
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	keys := StaticKeyring([]byte("jwe-secret"))
+	enc := testEncryption(t, 'k')
+	claims := testClaims("alice")
+	claims.Roles = []string{"admin"}
+	signed := mustSign(t, keys, claims)
+
+	token, err := enc.Encrypt(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(token) || IsEncrypted(signed) {
+		t.Fatalf("IsEncrypted is %v for the JWE and %v for the JWS", IsEncrypted(token), IsEncrypted(signed))
+	}
+	inner, err := enc.Unwrap(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseToken(inner, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "alice" || got.Roles[0] != "admin" {
+		t.Errorf("claims after the round trip: %+v", got)
+	}
+
+	// plain tokens pass through, so both kinds work side by side
+	if plain, err := enc.Unwrap(signed); err != nil || plain != signed {
+		t.Errorf("Unwrap of a plain token = %q, %v", plain, err)
+	}
+}
+
+func TestEncryptedPayloadUnreadable(t *testing.T) {
+	enc := testEncryption(t, 'k')
+	claims := testClaims("alice")
+	claims.Roles = []string{"admin"}
+	token, err := enc.Encrypt(mustSign(t, StaticKeyring([]byte("jwe-secret")), claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range strings.Split(token, ".") {
+		raw, _ := base64.RawURLEncoding.DecodeString(part)
+		if strings.Contains(string(raw), "alice") || strings.Contains(string(raw), "admin") {
+			t.Errorf("a part of the JWE reads %q", raw)
+		}
+	}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &Claims{}); err == nil {
+		t.Error("the JWE decodes as a JWT")
+	}
+	if _, err := testEncryption(t, 'x').Unwrap(token); !errors.Is(err, jwt.ErrTokenMalformed) {
+		t.Errorf("Unwrap with another key: %v, want ErrTokenMalformed", err)
+	}
+	var none *TokenEncryption
+	if _, err := none.Unwrap(token); !errors.Is(err, ErrEncryptedToken) {
+		t.Errorf("Unwrap without a key: %v, want ErrEncryptedToken", err)
+	}
+}
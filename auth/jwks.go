@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is a public key in JSON Web Key form (RFC 7517), RSA or Ed25519.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the live public keys. Secrets are never part of it, so an
+// HS256 keyring has an empty set.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, e := range k.entries() {
+		if !e.live(now) {
+			continue
+		}
+		jwk := JWK{Kid: e.kid, Alg: k.method.Alg(), Use: "sig"}
+		switch pub := e.key.Verification.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler serves the keyring's public keys.
+func JWKSHandler(k *Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "max-age=60")
+		json.NewEncoder(w).Encode(k.JWKS())
+	}
+}
+
+// LoadJWKSKeyring fetches the alg keys a verifier accepts from an issuer's
+// JWKS URL. A token naming an unknown kid triggers a fetch, so keys rotated
+// at the issuer are picked up right away; ReloadEvery also drops retired
+// ones. If only the first fetch fails, the empty keyring is returned along
+// with the error, and it keeps trying whenever a token comes in.
+func LoadJWKSKeyring(alg, url string) (*Keyring, error) {
+	if alg == jwt.SigningMethodHS256.Alg() {
+		return nil, errors.New("HS256 secrets are not published in a JWKS")
+	}
+	if _, err := keyProvider(alg); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	k := &Keyring{
+		method: jwt.GetSigningMethod(alg),
+		parse:  func(raw []byte) ([]keyEntry, error) { return parseJWKS(alg, raw) },
+		load: func() ([]byte, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+		remote: true,
+	}
+	return k, k.Reload()
+}
+
+func parseJWKS(alg string, raw []byte) ([]keyEntry, error) {
+	var set JWKSet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	var keys []keyEntry
+	for _, jwk := range set.Keys {
+		if jwk.Alg != alg {
+			continue
+		}
+		pub, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, keyEntry{kid: jwk.Kid, key: Key{Verification: pub}})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no %s keys", alg)
+	}
+	return keys, nil
+}
+
+func (j JWK) publicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || j.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("not an Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
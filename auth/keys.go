@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Keyring holds the key material of one signing algorithm, shared by the
+// user and server services. Keys are read from a file (or a JWKS URL) and
+// can be re-read or rotated at runtime; a replaced key keeps verifying
+// tokens until it retires.
+type Keyring struct {
+	method   jwt.SigningMethod
+	generate func() ([]byte, error)
+	parse    func([]byte) ([]keyEntry, error)
+	load     func() ([]byte, error)
+	path     string
+	grace    time.Duration
+	remote   bool // the source lists every key; nothing is kept across loads
+
+	mu       sync.Mutex // serializes Reload and Rotate
+	state    atomic.Pointer[keyState]
+	lastMiss atomic.Int64 // unix nanos of the last reload for an unknown kid
+}
+
+// keyEntry is one key of a keyring under its kid.
+type keyEntry struct {
+	kid       string
+	key       Key
+	createdAt time.Time
+	retiresAt time.Time // zero while current, or for keys without an end
+}
+
+func (e keyEntry) live(now time.Time) bool {
+	return e.retiresAt.IsZero() || now.Before(e.retiresAt)
+}
+
+// keyState is swapped as a whole so readers always see a consistent set.
+// keys[0] is the current key.
+type keyState struct {
+	raw  []byte
+	keys []keyEntry
+}
+
+// KeyInfo describes a key of a keyring without its material.
+type KeyInfo struct {
+	Kid       string     `json:"kid"`
+	Alg       string     `json:"alg"`
+	Current   bool       `json:"current"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiresAt *time.Time `json:"retires_at,omitempty"`
+}
+
+// LoadSigningKeyring reads the secret or private key an issuer signs with.
+func LoadSigningKeyring(alg, path string) (*Keyring, error) {
+	p, err := keyProvider(alg)
+	if err != nil {
+		return nil, err
+	}
+	k := fileKeyring(p, p.ParseSigning, path, 0)
+	k.generate = p.Generate
+	return k, k.Reload()
+}
+
+// LoadVerificationKeyring reads the secret or public key tokens are checked
+// against.
+func LoadVerificationKeyring(alg, path string, grace time.Duration) (*Keyring, error) {
+	p, err := keyProvider(alg)
+	if err != nil {
+		return nil, err
+	}
+	k := fileKeyring(p, p.ParseVerification, path, grace)
+	return k, k.Reload()
+}
+
+func fileKeyring(p KeyProvider, parse func([]byte) (Key, error), path string, grace time.Duration) *Keyring {
+	return &Keyring{
+		method: p.Method,
+		parse: func(raw []byte) ([]keyEntry, error) {
+			key, err := parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			return []keyEntry{{kid: keyID(key), key: key}}, nil
+		},
+		load:  func() ([]byte, error) { return os.ReadFile(path) },
+		path:  path,
+		grace: grace,
+	}
+}
+
+// DefaultSecret is the tutorial's HS256 secret, which the services and
+// jwtctl fall back to when no secret or key is configured. Never deploy
+// with it.
+const DefaultSecret = "supersecretkey"
+
+// StaticKeyring returns an HS256 keyring for a fixed secret that cannot be
+// reloaded.
+func StaticKeyring(secret []byte) *Keyring {
+	k := &Keyring{method: jwt.SigningMethodHS256}
+	key := Key{Signing: secret, Verification: secret}
+	k.state.Store(&keyState{keys: []keyEntry{{kid: keyID(key), key: key, createdAt: time.Now()}}})
+	return k
+}
+
+// keyID names a key after a hash of its verification half, so services
+// loading the same key agree on its kid.
+func keyID(key Key) string {
+	var b []byte
+	switch v := key.Verification.(type) {
+	case []byte:
+		b = v
+	default:
+		der, err := x509.MarshalPKIXPublicKey(v)
+		if err != nil {
+			return ""
+		}
+		b = der
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Reload re-reads the key source. For a key file the key read becomes the
+// current one and the one it replaces retires after the grace window.
+func (k *Keyring) Reload() error {
+	if k.load == nil {
+		return errors.New("no key file configured")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	raw, err := k.load()
+	if err != nil {
+		return err
+	}
+	old := k.state.Load()
+	if old != nil && bytes.Equal(old.raw, raw) {
+		return nil
+	}
+	keys, err := k.parse(raw)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range keys {
+		keys[i].createdAt = now
+	}
+	if !k.remote {
+		keys = k.retire(keys[0], old, now.Add(k.grace))
+	}
+	k.state.Store(&keyState{raw: raw, keys: keys})
+	return nil
+}
+
+// Rotate generates a new key and signs with it from now on. The key it
+// replaces keeps verifying until retire has passed. A keyring loaded from a
+// file gets the new key written back, so reloads and restarts keep it.
+func (k *Keyring) Rotate(retire time.Duration) (KeyInfo, error) {
+	if k.generate == nil {
+		return KeyInfo{}, errors.New("keyring cannot generate keys")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	raw, err := k.generate()
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	keys, err := k.parse(raw)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if k.path != "" {
+		if err := writeFileAtomic(k.path, raw); err != nil {
+			return KeyInfo{}, err
+		}
+	}
+	now := time.Now()
+	keys[0].createdAt = now
+	k.state.Store(&keyState{raw: raw, keys: k.retire(keys[0], k.state.Load(), now.Add(retire))})
+	return k.info(keys[0], true), nil
+}
+
+// retire returns current followed by the still live keys of old, those
+// without a retirement time yet retiring at until.
+func (k *Keyring) retire(current keyEntry, old *keyState, until time.Time) []keyEntry {
+	keys := []keyEntry{current}
+	if old == nil {
+		return keys
+	}
+	now := time.Now()
+	for _, e := range old.keys {
+		if e.kid == current.kid || !e.live(now) {
+			continue
+		}
+		if e.retiresAt.IsZero() {
+			e.retiresAt = until
+		}
+		if e.live(now) {
+			keys = append(keys, e)
+		}
+	}
+	return keys
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Method returns the algorithm the keyring's keys belong to.
+func (k *Keyring) Method() jwt.SigningMethod {
+	return k.method
+}
+
+// entries returns the keys, none before the first successful load.
+func (k *Keyring) entries() []keyEntry {
+	if s := k.state.Load(); s != nil {
+		return s.keys
+	}
+	return nil
+}
+
+// Sign signs claims with the current key, naming it in the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	entries := k.entries()
+	if len(entries) == 0 || entries[0].key.Signing == nil {
+		return "", errors.New("keyring has no signing key")
+	}
+	current := entries[0]
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = current.kid
+	return token.SignedString(current.key.Signing)
+}
+
+// ErrNoKeys means a keyring has no usable key at all, e.g. because its JWKS
+// could not be fetched yet: tokens cannot be checked, whether good or bad.
+var ErrNoKeys = errors.New("no usable verification key")
+
+// Ready reports whether the keyring has a key to verify with. A remote
+// keyring without one tries to fetch them first, so it recovers even
+// while no traffic comes in.
+func (k *Keyring) Ready() bool {
+	if !k.hasKeys() && k.remote {
+		k.reloadOnMiss("")
+	}
+	return k.hasKeys()
+}
+
+func (k *Keyring) hasKeys() bool {
+	now := time.Now()
+	for _, e := range k.entries() {
+		if e.live(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerificationKeys returns every key that has not retired yet.
+func (k *Keyring) VerificationKeys() jwt.VerificationKeySet {
+	set := jwt.VerificationKeySet{}
+	now := time.Now()
+	for _, e := range k.entries() {
+		if e.live(now) {
+			set.Keys = append(set.Keys, e.key.Verification)
+		}
+	}
+	return set
+}
+
+// minMissReload is how often unknown kids (or missing keys) may trigger a
+// remote reload, so made-up kids cannot hammer the issuer.
+const minMissReload = 10 * time.Second
+
+// verificationKeysFor returns the key named kid, or every live key for
+// tokens without one; an unknown kid gets an empty set. An unknown kid, or
+// having no keys at all, makes a remote keyring reload first, as the issuer
+// may just have rotated or become reachable.
+func (k *Keyring) verificationKeysFor(kid string) (jwt.VerificationKeySet, error) {
+	key, found := k.lookup(kid)
+	if !found && k.remote && (kid != "" || !k.hasKeys()) {
+		k.reloadOnMiss(kid)
+		key, found = k.lookup(kid)
+	}
+	switch {
+	case !k.hasKeys():
+		return jwt.VerificationKeySet{}, ErrNoKeys
+	case kid == "":
+		return k.VerificationKeys(), nil
+	case found:
+		return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{key}}, nil
+	}
+	return jwt.VerificationKeySet{}, nil
+}
+
+func (k *Keyring) reloadOnMiss(kid string) {
+	last := k.lastMiss.Load()
+	if time.Since(time.Unix(0, last)) > minMissReload &&
+		k.lastMiss.CompareAndSwap(last, time.Now().UnixNano()) {
+		if err := k.Reload(); err != nil && kid != "" {
+			log.Printf("ERROR: reloading keys for kid %q: %v", kid, err)
+		} else if err != nil {
+			log.Printf("ERROR: reloading keys: %v", err)
+		}
+	}
+}
+
+func (k *Keyring) lookup(kid string) (jwt.VerificationKey, bool) {
+	now := time.Now()
+	for _, e := range k.entries() {
+		if e.kid == kid && e.live(now) {
+			return e.key.Verification, true
+		}
+	}
+	return nil, false
+}
+
+// Keys describes the live keys, current first.
+func (k *Keyring) Keys() []KeyInfo {
+	var infos []KeyInfo
+	now := time.Now()
+	for i, e := range k.entries() {
+		if e.live(now) {
+			infos = append(infos, k.info(e, i == 0))
+		}
+	}
+	return infos
+}
+
+func (k *Keyring) info(e keyEntry, current bool) KeyInfo {
+	info := KeyInfo{Kid: e.kid, Alg: k.method.Alg(), Current: current, CreatedAt: e.createdAt}
+	if !e.retiresAt.IsZero() {
+		info.RetiresAt = &e.retiresAt
+	}
+	return info
+}
+
+// ReloadOn reloads the keyring every time the process receives one of sigs.
+func (k *Keyring) ReloadOn(sigs ...os.Signal) {
+	ReloadOn("keys from "+k.path, k.Reload, sigs...)
+}
+
+// ReloadEvery reloads the keyring in the background every interval.
+func (k *Keyring) ReloadEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := k.Reload(); err != nil {
+				log.Printf("ERROR: reloading keys: %v", err)
+			}
+		}
+	}()
+}
+
+// ReloadOn calls reload every time the process receives one of sigs,
+// logging the outcome for what.
+func ReloadOn(what string, reload func() error, sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	go func() {
+		for range ch {
+			if err := reload(); err != nil {
+				log.Printf("ERROR: reloading %s: %v", what, err)
+				continue
+			}
+			log.Println("reloaded", what)
+		}
+	}()
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func testClaims(sub string) Claims {
+	now := time.Now()
+	return Claims{
+		Version: ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sub + "-" + now.Format(time.RFC3339Nano),
+			Subject:   sub,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func mustSign(t testing.TB, k *Keyring, claims Claims) string {
+	t.Helper()
+	token, err := k.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("first-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signing, err := LoadSigningKeyring("HS256", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const grace = 300 * time.Millisecond
+	verifying, err := LoadVerificationKeyring("HS256", path, grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing.ReloadOn(syscall.SIGHUP)
+	verifying.ReloadOn(syscall.SIGHUP)
+
+	oldToken := mustSign(t, signing, testClaims("alice"))
+	oldKid := signing.Keys()[0].Kid
+
+	if err := os.WriteFile(path, []byte("second-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the reload", func() bool {
+		return signing.Keys()[0].Kid != oldKid && verifying.Keys()[0].Kid != oldKid
+	})
+
+	newToken := mustSign(t, signing, testClaims("bob"))
+	if _, err := ParseToken(newToken, StaticKeyring([]byte("first-secret"))); err == nil {
+		t.Error("a token signed after the reload verifies with the old secret")
+	}
+	if _, err := ParseToken(newToken, StaticKeyring([]byte("second-secret"))); err != nil {
+		t.Errorf("a token signed after the reload does not verify with the new secret: %v", err)
+	}
+	if _, err := ParseToken(newToken, verifying); err != nil {
+		t.Errorf("new token: %v", err)
+	}
+	if _, err := ParseToken(oldToken, verifying); err != nil {
+		t.Errorf("old token within the grace window: %v", err)
+	}
+
+	time.Sleep(grace)
+	if _, err := ParseToken(oldToken, verifying); err == nil {
+		t.Error("old token still verifies after the grace window")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	signing, _, _ := rsaKeyrings(t)
+	oldToken := mustSign(t, signing, testClaims("alice"))
+	oldKid := signing.Keys()[0].Kid
+
+	// logins go on while the key changes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			token := mustSign(t, signing, testClaims("bob"))
+			if _, err := ParseToken(token, signing); err != nil {
+				t.Errorf("a token signed during the rotation: %v", err)
+				return
+			}
+		}
+	}()
+	const retire = 300 * time.Millisecond
+	info, err := signing.Rotate(retire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if info.Kid == oldKid || !info.Current {
+		t.Fatalf("rotated to %+v", info)
+	}
+
+	newToken := mustSign(t, signing, testClaims("alice"))
+	if kid := signing.Keys()[0].Kid; kid != info.Kid {
+		t.Errorf("signing with %s, want the new key %s", kid, info.Kid)
+	}
+	// verifiers fetching the JWKS see both keys
+	srv := httptest.NewServer(JWKSHandler(signing))
+	defer srv.Close()
+	remote, err := LoadJWKSKeyring("RS256", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		for _, keys := range []*Keyring{signing, remote} {
+			if _, err := ParseToken(token, keys); err != nil {
+				t.Errorf("%s token: %v", name, err)
+			}
+		}
+	}
+
+	time.Sleep(retire)
+	if _, err := ParseToken(oldToken, signing); err == nil {
+		t.Error("a token of the retired key still verifies")
+	}
+	if _, err := ParseToken(newToken, signing); err != nil {
+		t.Errorf("new token after the retirement: %v", err)
+	}
+	if keys := signing.Keys(); len(keys) != 1 {
+		t.Errorf("the retired key is still listed: %+v", keys)
+	}
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitStore counts attempts per key (a client IP, a username, ...) in
+// fixed windows and reports whether one more is allowed.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string) bool
+}
+
+// MemoryRateLimitStore is a RateLimitStore for a single instance.
+type MemoryRateLimitStore struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewMemoryRateLimitStore allows limit attempts per key every window.
+func NewMemoryRateLimitStore(limit int, window time.Duration) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{limit: limit, window: window, windows: make(map[string]rateWindow)}
+}
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, w := range s.windows {
+		if now.Sub(w.start) >= s.window {
+			delete(s.windows, k)
+		}
+	}
+	w, ok := s.windows[key]
+	if !ok {
+		w = rateWindow{start: now}
+	}
+	w.count++
+	s.windows[key] = w
+	return w.count <= s.limit
+}
+
+// RedisRateLimitStore shares the counters between instances through Redis.
+// Each counter expires with its window. When Redis cannot be reached the
+// attempt is allowed: the limiter only slows down guessing, it is not what
+// keeps a bad password out.
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	limit  int
+	window time.Duration
+}
+
+func NewRedisRateLimitStore(client redis.UniversalClient, limit int, window time.Duration) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client, limit: limit, window: window}
+}
+
+func (s *RedisRateLimitStore) Allow(ctx context.Context, key string) bool {
+	key = "ratelimit:" + key
+	// one MULTI, so a counter never exists without its expiry; NX keeps the
+	// window from sliding with every attempt (Redis 7+)
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, s.window)
+		return nil
+	})
+	n := incr.Val()
+	if err != nil {
+		log.Println("ERROR: rate limit store: ", err)
+		return true
+	}
+	return n <= int64(s.limit)
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedisRateLimit(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	limiter := NewRedisRateLimitStore(client, 2, time.Minute)
+
+	for i, want := range []bool{true, true, false} {
+		if got := limiter.Allow(ctx, "203.0.113.7"); got != want {
+			t.Errorf("attempt %d: allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if !limiter.Allow(ctx, "203.0.113.8") {
+		t.Error("another key shares the counter")
+	}
+	if ttl := mr.TTL("ratelimit:203.0.113.7"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want the window", ttl)
+	}
+
+	mr.FastForward(time.Minute)
+	if !limiter.Allow(ctx, "203.0.113.7") {
+		t.Error("still limited in the next window")
+	}
+
+	// Redis being down must not lock everybody out
+	mr.Close()
+	if !limiter.Allow(ctx, "203.0.113.7") {
+		t.Error("denied while Redis is unreachable")
+	}
+}
+
+func TestMemoryRateLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewMemoryRateLimitStore(1, 50*time.Millisecond)
+	if !limiter.Allow(ctx, "k") || limiter.Allow(ctx, "k") {
+		t.Fatal("the limit of 1 is not kept")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !limiter.Allow(ctx, "k") {
+		t.Error("still limited in the next window")
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RevocationStore holds the ids (jti) of tokens revoked before they expired.
+// Entries only need to be kept until the token's own expiry. Consume
+// revokes jti unless it already was, in one atomic step, and reports
+// whether this call did it; single-use tokens are spent with it.
+type RevocationStore interface {
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	Consume(ctx context.Context, jti string, exp time.Time) (firstUse bool, err error)
+}
+
+// MemoryRevocationStore is a RevocationStore for a single instance.
+type MemoryRevocationStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+func (b *MemoryRevocationStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// expired tokens are rejected anyway, drop them while we're here
+	now := time.Now()
+	for id, e := range b.revoked {
+		if now.After(e) {
+			delete(b.revoked, id)
+		}
+	}
+	b.revoked[jti] = exp
+	return nil
+}
+
+func (b *MemoryRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	exp, ok := b.revoked[jti]
+	return ok && time.Now().Before(exp), nil
+}
+
+func (b *MemoryRevocationStore) Consume(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.revoked[jti]; ok && time.Now().Before(e) {
+		return false, nil
+	}
+	b.revoked[jti] = exp
+	return true, nil
+}
+
+// RedisRevocationStore shares revocations between instances through Redis.
+// Each entry expires together with its token.
+type RedisRevocationStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisRevocationStore(client redis.UniversalClient) *RedisRevocationStore {
+	return &RedisRevocationStore{client: client}
+}
+
+func (b *RedisRevocationStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil
+	}
+	return b.client.Set(ctx, "revoked:"+jti, 1, ttl).Err()
+}
+
+func (b *RedisRevocationStore) Consume(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return false, nil // expired tokens are refused anyway
+	}
+	return b.client.SetNX(ctx, "revoked:"+jti, 1, ttl).Result()
+}
+
+func (b *RedisRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	err := b.client.Get(ctx, "revoked:"+jti).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestRevocationStores(t *testing.T) {
+	_, client := newTestRedis(t)
+	for name, store := range map[string]RevocationStore{
+		"memory": NewMemoryRevocationStore(),
+		"redis":  NewRedisRevocationStore(client),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := store.Revoke(ctx, "revoked", time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if revoked, err := store.IsRevoked(ctx, "revoked"); err != nil || !revoked {
+				t.Errorf("revoked jti: revoked = %v, err = %v", revoked, err)
+			}
+			if revoked, err := store.IsRevoked(ctx, "other"); err != nil || revoked {
+				t.Errorf("other jti: revoked = %v, err = %v", revoked, err)
+			}
+
+			if first, err := store.Consume(ctx, "once", time.Now().Add(time.Hour)); err != nil || !first {
+				t.Errorf("first use: %v, %v", first, err)
+			}
+			if first, err := store.Consume(ctx, "once", time.Now().Add(time.Hour)); err != nil || first {
+				t.Errorf("second use: %v, %v", first, err)
+			}
+			if first, err := store.Consume(ctx, "revoked", time.Now().Add(time.Hour)); err != nil || first {
+				t.Errorf("using a revoked jti: %v, %v", first, err)
+			}
+		})
+	}
+}
+
+func TestRedisRevocationExpiry(t *testing.T) {
+	ctx := context.Background()
+	mr, client := newTestRedis(t)
+	store := NewRedisRevocationStore(client)
+
+	if err := store.Revoke(ctx, "j1", time.Now().Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("revoked:j1"); ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("TTL = %v, want the token's remaining 10m", ttl)
+	}
+
+	mr.FastForward(10 * time.Minute)
+	if revoked, err := store.IsRevoked(ctx, "j1"); err != nil || revoked {
+		t.Errorf("after the token expired: revoked = %v, err = %v", revoked, err)
+	}
+
+	// a token that has expired already needs no entry
+	if err := store.Revoke(ctx, "j2", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("revoked:j2") {
+		t.Error("an expired token was stored")
+	}
+}
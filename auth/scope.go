@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"auth/httpx"
+)
+
+// RequireScope only lets through requests whose token grants every one of
+// scopes. Tokens without a scope claim have no scopes. It must be mounted
+// after the JWT middleware that puts the claims in the context.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	// RFC 6750 section 3: tell the client which scopes it would need
+	challenge := `Bearer error="insufficient_scope", scope="` + strings.Join(scopes, " ") + `"`
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			for _, s := range scopes {
+				if !ok || !claims.HasScope(s) {
+					w.Header().Set("WWW-Authenticate", challenge)
+					httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeInsufficientScope, "the token lacks a required scope")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range []struct {
+		name     string
+		required []string
+		scope    string // of the token; "-" for a request without claims
+		allowed  bool
+	}{
+		{"granted", []string{"greet:read"}, "profile greet:read", true},
+		{"missing", []string{"greet:read"}, "profile", false},
+		{"no scope claim", []string{"greet:read"}, "", false},
+		{"no claims", []string{"greet:read"}, "-", false},
+		{"all of several", []string{"greet:read", "profile"}, "profile greet:read", true},
+		{"one of several", []string{"greet:read", "profile"}, "greet:read", false},
+		{"prefix only", []string{"greet:read"}, "greet:readwrite", false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/json")
+		if tc.scope != "-" {
+			req = req.WithContext(WithClaims(req.Context(), &Claims{Scope: tc.scope}))
+		}
+		rec := httptest.NewRecorder()
+		RequireScope(tc.required...)(ok).ServeHTTP(rec, req)
+
+		if tc.allowed {
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: %d %s", tc.name, rec.Code, rec.Body)
+			}
+			continue
+		}
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", tc.name, rec.Code)
+		}
+		want := `Bearer error="insufficient_scope", scope="` + strings.Join(tc.required, " ") + `"`
+		if got := rec.Header().Get("WWW-Authenticate"); got != want {
+			t.Errorf("%s: WWW-Authenticate = %q, want %q", tc.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"auth/httpx"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the claims carried by our access tokens.
+type Claims struct {
+	Name  string   `json:"name,omitempty"` // the user's display name
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"` // space-delimited, as in OAuth 2.0
+	// Client marks tokens issued to a service through client_credentials,
+	// whose subject is a client id rather than a user.
+	Client bool `json:"client,omitempty"`
+	// Purpose marks special-purpose tokens (e.g. "mfa" for the step between
+	// password and second factor). They are never access tokens.
+	Purpose string `json:"purpose,omitempty"`
+	// Action is the one thing an action token (purpose "action") may be
+	// used for, e.g. "password_reset".
+	Action string `json:"action,omitempty"`
+	// TokenVersion is the user's token version at issuance. Changing the
+	// password bumps it, and tokens carrying an older one are stale.
+	TokenVersion int `json:"token_version,omitempty"`
+	// Version is the claims format the token was issued in, ClaimsVersion
+	// for new tokens. Tokens from before the claim existed have 0.
+	Version int `json:"ver,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ClaimsVersion is the claims format issued now. When the meaning of a
+// claim changes it goes up, and for a migration window tokens of the
+// previous version keep validating (see CheckClaimsVersion); older ones
+// never do.
+const ClaimsVersion = 1
+
+// HasRole reports whether the token grants role.
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token's scope claim includes scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrInvalidClaims is returned for a correctly signed token whose claims are
+// missing or of the wrong type, e.g. without sub or with exp as a string.
+var ErrInvalidClaims = errors.New("token claims are invalid")
+
+// Validate checks the claims every token needs, after the registered claims
+// have been validated: sub names who the token is for and jti is what it is
+// revoked by.
+func (c *Claims) Validate() error {
+	switch {
+	case c.Subject == "":
+		return fmt.Errorf("%w: sub is missing", ErrInvalidClaims)
+	case c.ID == "":
+		return fmt.Errorf("%w: jti is missing", ErrInvalidClaims)
+	case c.TokenVersion < 0:
+		return fmt.Errorf("%w: token_version is negative", ErrInvalidClaims)
+	case c.Version < ClaimsVersion-1 || c.Version > ClaimsVersion:
+		return fmt.Errorf("%w: unsupported claims version %d", ErrInvalidClaims, c.Version)
+	}
+	return nil
+}
+
+type contextKey string
+
+const ClaimsContextKey contextKey = "claims"
+
+// WithClaims returns a copy of ctx carrying claims.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, ClaimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims put in ctx by the auth middleware.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(ClaimsContextKey).(*Claims)
+	return claims, ok
+}
+
+var (
+	ErrMissingHeader = errors.New("missing Authorization header")
+	ErrInvalidHeader = errors.New("invalid Authorization header")
+)
+
+// CookieName is the cookie the user service delivers the token in when it
+// runs in cookie-only mode.
+const CookieName = "access_token"
+
+// BearerToken extracts the token from the request's Authorization header,
+// or from the access token cookie when there is no header.
+func BearerToken(r *http.Request) (string, error) {
+	values := r.Header.Values("Authorization")
+	if len(values) == 0 {
+		if c, err := r.Cookie(CookieName); err == nil && c.Value != "" {
+			return c.Value, nil
+		}
+		return "", ErrMissingHeader
+	}
+	if len(values) > 1 {
+		return "", fmt.Errorf("%w: more than one header", ErrInvalidHeader)
+	}
+	return parseBearer(values[0])
+}
+
+// parseBearer parses "Bearer <token>". The scheme is matched
+// case-insensitively (RFC 7235) and any run of spaces or tabs around and
+// between the parts is tolerated.
+func parseBearer(h string) (string, error) {
+	fields := strings.Fields(h)
+	switch {
+	case len(fields) == 0:
+		return "", fmt.Errorf("%w: empty value", ErrInvalidHeader)
+	case !strings.EqualFold(fields[0], "Bearer"):
+		if len(fields) == 1 {
+			return "", fmt.Errorf("%w: missing scheme", ErrInvalidHeader)
+		}
+		return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidHeader, fields[0])
+	case len(fields) == 1:
+		return "", fmt.Errorf("%w: missing token", ErrInvalidHeader)
+	case len(fields) > 2:
+		return "", fmt.Errorf("%w: more than one token", ErrInvalidHeader)
+	}
+	return fields[1], nil
+}
+
+// ErrTokenPurpose is returned for a token made for something else, such as
+// an mfa token presented as an access token.
+var ErrTokenPurpose = errors.New("token has the wrong purpose")
+
+// ParseToken verifies the signature and registered claims of the access
+// token tokenStr and returns its claims. opts tune validation, e.g.
+// jwt.WithLeeway. A token issued further in the future than the leeway is
+// refused, as only skewed clocks or forgers produce one, and so is a token
+// without exp: it would never expire.
+func ParseToken(tokenStr string, keys Verifier, opts ...jwt.ParserOption) (*Claims, error) {
+	return ParsePurposeToken(tokenStr, keys, "", opts...)
+}
+
+// ParsePurposeToken is ParseToken for tokens carrying purpose.
+func ParsePurposeToken(tokenStr string, keys Verifier, purpose string, opts ...jwt.ParserOption) (*Claims, error) {
+	claims := &Claims{}
+	opts = append([]jwt.ParserOption{jwt.WithValidMethods(keys.Algorithms()), jwt.WithIssuedAt(), jwt.WithExpirationRequired()}, opts...)
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc(keys), opts...)
+	if errors.Is(err, jwt.ErrTokenMalformed) {
+		return nil, claimTypeError(tokenStr, keys, err, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.Purpose != purpose {
+		return nil, ErrTokenPurpose
+	}
+	return claims, nil
+}
+
+// claimTypeError tells a token whose claims did not decode into Claims from
+// a malformed one. The claims are decoded before the signature is checked, so
+// the token is parsed again into a map: if the signature holds, the claims
+// are of the wrong type, and only then is that worth saying.
+func claimTypeError(tokenStr string, keys Verifier, err error, opts []jwt.ParserOption) error {
+	_, mapErr := jwt.Parse(tokenStr, keyFunc(keys), opts...)
+	if mapErr == nil || errors.Is(mapErr, jwt.ErrTokenInvalidClaims) {
+		return fmt.Errorf("%w: %v", ErrInvalidClaims, err)
+	}
+	return mapErr
+}
+
+func keyFunc(keys Verifier) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		// only accept the algorithms we have keys for, never "none" or a
+		// public key passed off as an HMAC secret
+		kid, _ := t.Header["kid"].(string)
+		set, err := keys.VerificationKeysFor(t.Method.Alg(), kid)
+		if errors.Is(err, ErrNoKeys) {
+			return nil, err
+		}
+		if err != nil || len(set.Keys) == 0 {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+		return set, nil
+	}
+}
+
+// TokenError maps an error from BearerToken or ParseToken to the code and
+// message of the 401 it is answered with.
+func TokenError(err error) (httpx.Code, string) {
+	switch {
+	case errors.Is(err, ErrMissingHeader):
+		return httpx.CodeMissingToken, "a bearer token is required"
+	case errors.Is(err, ErrInvalidHeader):
+		return httpx.CodeInvalidRequest, err.Error()
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return httpx.CodeTokenExpired, "the token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return httpx.CodeTokenNotYetValid, "the token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return httpx.CodeTokenUsedBeforeIssued, "the token was issued in the future"
+	case errors.Is(err, ErrInvalidClaims), errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return httpx.CodeInvalidClaims, "the token's claims are missing or malformed"
+	case errors.Is(err, ErrTokenLifetime):
+		return httpx.CodeTokenLifetime, "the token lives longer than allowed"
+	case errors.Is(err, ErrTokenTooOld):
+		return httpx.CodeTokenTooOld, "the token was issued too long ago"
+	case errors.Is(err, ErrLegacyClaims):
+		return httpx.CodeLegacyClaims, "the token is in a retired format; log in again"
+	case errors.Is(err, ErrTokenAudience):
+		return httpx.CodeWrongAudience, "the token is not meant for this service"
+	}
+	return httpx.CodeTokenInvalid, "invalid token"
+}
+
+var ErrTokenAudience = errors.New("token is not meant for this service")
+
+// CheckAudience accepts tokens whose aud claim names audience, alone as a
+// string or as one member of a list. With an empty audience only tokens
+// meant for no service in particular are accepted, so a token minted for
+// one service never works at another.
+func CheckAudience(claims *Claims, audience string) error {
+	if audience == "" && len(claims.Audience) == 0 {
+		return nil
+	}
+	if audience != "" && slices.Contains(claims.Audience, audience) {
+		return nil
+	}
+	return ErrTokenAudience
+}
+
+var ErrTokenLifetime = errors.New("token lifetime exceeds the allowed maximum")
+
+// CheckLifetime rejects tokens whose exp - iat is longer than limit. A token
+// missing either claim has no bounded lifetime and is rejected too.
+func CheckLifetime(claims *Claims, limit time.Duration) error {
+	if claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return ErrTokenLifetime
+	}
+	if claims.ExpiresAt.Sub(claims.IssuedAt.Time) > limit {
+		return ErrTokenLifetime
+	}
+	return nil
+}
+
+var ErrTokenTooOld = errors.New("token is older than the allowed maximum")
+
+// CheckAge rejects tokens issued more than limit (plus leeway, for clock
+// skew) ago; CheckLifetime is the one for exp - iat. A token without iat
+// has no known age and is rejected too.
+func CheckAge(claims *Claims, limit, leeway time.Duration) error {
+	if claims.IssuedAt == nil {
+		return ErrTokenTooOld
+	}
+	if time.Since(claims.IssuedAt.Time) > limit+leeway {
+		return ErrTokenTooOld
+	}
+	return nil
+}
+
+var ErrLegacyClaims = errors.New("token is in the previous claims format")
+
+// CheckClaimsVersion rejects tokens in the previous claims format unless
+// the migration window, which closes at legacyUntil (zero: no window), is
+// still open. Previous-format claims it accepts are upgraded in place, so
+// handlers only ever see the current format.
+func CheckClaimsVersion(claims *Claims, legacyUntil time.Time) error {
+	if claims.Version >= ClaimsVersion {
+		return nil
+	}
+	if !time.Now().Before(legacyUntil) {
+		return ErrLegacyClaims
+	}
+	UpgradeClaims(claims)
+	return nil
+}
+
+// UpgradeClaims maps claims of the previous format onto the current one.
+// Version 0 lacks only ver itself: every other claim meant then what it
+// means now, and a missing token_version was 0 already. When ClaimsVersion
+// goes up, this is where the claims that changed meaning get converted.
+func UpgradeClaims(c *Claims) {
+	if c.Version == 0 {
+		c.Version = 1
+	}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth/httpx"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestBearerToken(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		token  string // "" when the header is rejected
+	}{
+		{"Bearer x", "x"},
+		{"bearer x", "x"},
+		{"BEARER x", "x"},
+		{"  Bearer   x  ", "x"},
+		{"Bearer\tx", "x"},
+		{"Basic x", ""},
+		{"", ""},
+		{"   ", ""},
+		{"Bearer", ""},
+		{"Bearer ", ""},
+		{"x", ""},
+		{"Bearer x y", ""},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", tc.header)
+		token, err := BearerToken(req)
+		if tc.token != "" {
+			if err != nil || token != tc.token {
+				t.Errorf("%q: got %q, %v; want %q", tc.header, token, err, tc.token)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("%q: got %q, %v; want ErrInvalidHeader", tc.header, token, err)
+		}
+	}
+}
+
+func TestBearerTokenMissing(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := BearerToken(req); !errors.Is(err, ErrMissingHeader) {
+		t.Errorf("no header: err = %v, want ErrMissingHeader", err)
+	}
+
+	req.Header.Add("Authorization", "Bearer x")
+	req.Header.Add("Authorization", "Bearer y")
+	if _, err := BearerToken(req); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("two headers: err = %v, want ErrInvalidHeader", err)
+	}
+}
+
+func TestCheckAudience(t *testing.T) {
+	withAud := func(aud ...string) *Claims {
+		c := testClaims("alice")
+		c.Audience = aud
+		return &c
+	}
+	for _, tc := range []struct {
+		claims   *Claims
+		audience string
+		ok       bool
+	}{
+		{withAud("a"), "a", true},
+		{withAud("b", "a"), "a", true},
+		{withAud("b"), "a", false},
+		{withAud(), "a", false},
+		{withAud(), "", true},
+		{withAud("a"), "", false},
+	} {
+		err := CheckAudience(tc.claims, tc.audience)
+		if (err == nil) != tc.ok {
+			t.Errorf("aud %v at %q: %v", tc.claims.Audience, tc.audience, err)
+		}
+		if err != nil && !errors.Is(err, ErrTokenAudience) {
+			t.Errorf("aud %v at %q: %v, want ErrTokenAudience", tc.claims.Audience, tc.audience, err)
+		}
+	}
+}
+
+func TestCheckAge(t *testing.T) {
+	issued := func(ago time.Duration) *Claims {
+		c := testClaims("alice")
+		c.IssuedAt = jwt.NewNumericDate(time.Now().Add(-ago))
+		return &c
+	}
+	if err := CheckAge(issued(time.Minute), time.Hour, 0); err != nil {
+		t.Errorf("fresh token: %v", err)
+	}
+	if err := CheckAge(issued(2*time.Hour), time.Hour, 0); !errors.Is(err, ErrTokenTooOld) {
+		t.Errorf("old token: %v, want ErrTokenTooOld", err)
+	}
+	if err := CheckAge(issued(time.Hour+10*time.Second), time.Hour, 30*time.Second); err != nil {
+		t.Errorf("within the leeway: %v", err)
+	}
+	noIat := testClaims("alice")
+	noIat.IssuedAt = nil
+	if err := CheckAge(&noIat, time.Hour, 0); !errors.Is(err, ErrTokenTooOld) {
+		t.Errorf("no iat: %v, want ErrTokenTooOld", err)
+	}
+}
+
+func TestInvalidClaims(t *testing.T) {
+	keys := StaticKeyring([]byte("claims-secret"))
+	valid := func() jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{"sub": "alice", "jti": "1", "ver": ClaimsVersion, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	}
+	sign := func(claims jwt.MapClaims, secret string) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if _, err := ParseToken(sign(valid(), "claims-secret"), keys); err != nil {
+		t.Fatalf("a valid token: %v", err)
+	}
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"missing sub":            func(c jwt.MapClaims) { delete(c, "sub") },
+		"empty sub":              func(c jwt.MapClaims) { c["sub"] = "" },
+		"missing jti":            func(c jwt.MapClaims) { delete(c, "jti") },
+		"exp as a string":        func(c jwt.MapClaims) { c["exp"] = "tomorrow" },
+		"roles as a number":      func(c jwt.MapClaims) { c["roles"] = 7 },
+		"negative token_version": func(c jwt.MapClaims) { c["token_version"] = -1 },
+		"unknown claims version": func(c jwt.MapClaims) { c["ver"] = ClaimsVersion + 1 },
+	} {
+		claims := valid()
+		change(claims)
+		_, err := ParseToken(sign(claims, "claims-secret"), keys)
+		if !errors.Is(err, ErrInvalidClaims) {
+			t.Errorf("%s: %v, want ErrInvalidClaims", name, err)
+		}
+		if code, _ := TokenError(err); code != "invalid_claims" {
+			t.Errorf("%s: code %s, want invalid_claims", name, code)
+		}
+
+		// with a bad signature, nothing is said about the claims
+		_, err = ParseToken(sign(claims, "other-secret"), keys)
+		if errors.Is(err, ErrInvalidClaims) {
+			t.Errorf("%s with a bad signature: %v", name, err)
+		}
+	}
+}
+
+func TestFutureIssuedAt(t *testing.T) {
+	keys := StaticKeyring([]byte("iat-secret"))
+	issued := func(in time.Duration) string {
+		c := testClaims("alice")
+		c.IssuedAt = jwt.NewNumericDate(time.Now().Add(in))
+		return mustSign(t, keys, c)
+	}
+	_, err := ParseToken(issued(time.Hour), keys, jwt.WithLeeway(30*time.Second))
+	if !errors.Is(err, jwt.ErrTokenUsedBeforeIssued) {
+		t.Fatalf("issued in an hour: %v, want ErrTokenUsedBeforeIssued", err)
+	}
+	if code, _ := TokenError(err); code != httpx.CodeTokenUsedBeforeIssued {
+		t.Errorf("code = %q, want %q", code, httpx.CodeTokenUsedBeforeIssued)
+	}
+	if _, err := ParseToken(issued(10*time.Second), keys, jwt.WithLeeway(30*time.Second)); err != nil {
+		t.Errorf("issued within the leeway: %v", err)
+	}
+	if _, err := ParseToken(issued(10*time.Second), keys); !errors.Is(err, jwt.ErrTokenUsedBeforeIssued) {
+		t.Errorf("issued in 10s without leeway: %v, want ErrTokenUsedBeforeIssued", err)
+	}
+}
+
+func TestCheckClaimsVersion(t *testing.T) {
+	legacy := func() *Claims {
+		c := testClaims("alice")
+		c.Version = 0
+		return &c
+	}
+	current := testClaims("alice")
+	for name, until := range map[string]time.Time{
+		"open window":   time.Now().Add(time.Hour),
+		"closed window": time.Now().Add(-time.Hour),
+		"no window":     {},
+	} {
+		if err := CheckClaimsVersion(&current, until); err != nil {
+			t.Errorf("%s: current format: %v", name, err)
+		}
+	}
+
+	c := legacy()
+	if err := CheckClaimsVersion(c, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("previous format during the window: %v", err)
+	}
+	if c.Version != ClaimsVersion {
+		t.Errorf("accepted claims are version %d, want %d", c.Version, ClaimsVersion)
+	}
+
+	for name, until := range map[string]time.Time{"closed window": time.Now().Add(-time.Hour), "no window": {}} {
+		c := legacy()
+		err := CheckClaimsVersion(c, until)
+		if !errors.Is(err, ErrLegacyClaims) {
+			t.Errorf("%s: previous format: %v, want ErrLegacyClaims", name, err)
+		}
+		if code, _ := TokenError(err); code != httpx.CodeLegacyClaims {
+			t.Errorf("%s: code = %q, want %q", name, code, httpx.CodeLegacyClaims)
+		}
+		if c.Version != 0 {
+			t.Errorf("%s: refused claims were upgraded", name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package tokentest mints tokens for tests, signed the way the services
+// sign theirs. Verify them with Keys:
+//
+//	Keys = auth.Keyrings{tokentest.Keys()}
+//	req.Header.Set("Authorization", "Bearer "+tokentest.ValidToken("alice", tokentest.WithScope("greet:read")))
+//
+// The functions panic when a token cannot be signed, as httptest.NewRequest
+// does for bad requests.
+package tokentest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"auth"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Secret is the HS256 secret the tokens are signed with.
+var Secret = []byte("tokentest-secret")
+
+var keys = auth.StaticKeyring(Secret)
+
+// Keys returns the keyring that verifies the tokens.
+func Keys() *auth.Keyring {
+	return keys
+}
+
+// An Option changes the claims of a token before it is signed.
+type Option func(*auth.Claims)
+
+// WithRoles sets the roles claim.
+func WithRoles(roles ...string) Option {
+	return func(c *auth.Claims) { c.Roles = roles }
+}
+
+// WithScope sets the space-delimited scope claim.
+func WithScope(scope string) Option {
+	return func(c *auth.Claims) { c.Scope = scope }
+}
+
+// WithAudience sets the aud claim.
+func WithAudience(audience ...string) Option {
+	return func(c *auth.Claims) { c.Audience = audience }
+}
+
+// WithClaimsVersion sets the ver claim; 0 leaves it out, as tokens in the
+// previous claims format did.
+func WithClaimsVersion(v int) Option {
+	return func(c *auth.Claims) { c.Version = v }
+}
+
+// WithTTL makes the token expire ttl after now; negative for one that has
+// expired already.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *auth.Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl)) }
+}
+
+// WithNotBefore sets the nbf claim, before which the token is not usable.
+func WithNotBefore(nbf time.Time) Option {
+	return func(c *auth.Claims) { c.NotBefore = jwt.NewNumericDate(nbf) }
+}
+
+// WithIssuedAt sets the iat claim; the zero time leaves it out.
+func WithIssuedAt(iat time.Time) Option {
+	return func(c *auth.Claims) {
+		c.IssuedAt = nil
+		if !iat.IsZero() {
+			c.IssuedAt = jwt.NewNumericDate(iat)
+		}
+	}
+}
+
+// WithTokenVersion sets the user's token version the token was issued at.
+func WithTokenVersion(v int) Option {
+	return func(c *auth.Claims) { c.TokenVersion = v }
+}
+
+// Claims returns the claims of a token for sub valid for an hour, changed by
+// opts.
+func Claims(sub string, opts ...Option) auth.Claims {
+	now := time.Now()
+	id := make([]byte, 16)
+	rand.Read(id)
+	c := auth.Claims{
+		Version: auth.ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Subject:   sub,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// ValidToken returns a token for sub that Keys accepts.
+func ValidToken(sub string, opts ...Option) string {
+	return sign(keys, Claims(sub, opts...))
+}
+
+// ExpiredToken returns a correctly signed token for sub that expired a
+// minute ago.
+func ExpiredToken(sub string, opts ...Option) string {
+	return ValidToken(sub, append(opts, WithTTL(-time.Minute))...)
+}
+
+// WrongKeyToken returns a token for sub signed with a key other than Keys.
+func WrongKeyToken(sub string, opts ...Option) string {
+	return sign(auth.StaticKeyring([]byte("not-"+string(Secret))), Claims(sub, opts...))
+}
+
+// NoneAlgToken returns an unsigned token for sub, with alg "none", as an
+// attacker would send it.
+func NoneAlgToken(sub string, opts ...Option) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, Claims(sub, opts...))
+	s, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		panic("tokentest: " + err.Error())
+	}
+	return s
+}
+
+func sign(k *auth.Keyring, claims auth.Claims) string {
+	s, err := k.Sign(claims)
+	if err != nil {
+		panic("tokentest: " + err.Error())
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package tokentest
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidToken(t *testing.T) {
+	token := ValidToken("alice")
+	claims, err := auth.ParseToken(token, Keys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || claims.Version != auth.ClaimsVersion || claims.ID == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("valid for %v, want an hour", ttl)
+	}
+	if since := time.Since(claims.IssuedAt.Time); since < 0 || since > 2*time.Second {
+		t.Errorf("issued %v ago, want now", since)
+	}
+	if other, _ := auth.ParseToken(ValidToken("alice"), Keys()); other.ID == claims.ID {
+		t.Error("two tokens share a jti")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	nbf := time.Now().Add(-time.Minute).Truncate(time.Second)
+	c := Claims("alice",
+		WithRoles("user", "admin"),
+		WithScope("profile greet:read"),
+		WithClaimsVersion(0),
+		WithTokenVersion(3),
+		WithNotBefore(nbf),
+		WithIssuedAt(time.Time{}),
+		WithTTL(time.Minute),
+	)
+	if !slices.Equal(c.Roles, []string{"user", "admin"}) || c.Scope != "profile greet:read" {
+		t.Errorf("roles %v, scope %q", c.Roles, c.Scope)
+	}
+	if c.Version != 0 || c.TokenVersion != 3 {
+		t.Errorf("version %d, token version %d", c.Version, c.TokenVersion)
+	}
+	if c.IssuedAt != nil || !c.NotBefore.Time.Equal(nbf) {
+		t.Errorf("iat %v, nbf %v", c.IssuedAt, c.NotBefore)
+	}
+	if ttl := time.Until(c.ExpiresAt.Time); ttl > time.Minute || ttl < 59*time.Second {
+		t.Errorf("valid for %v, want a minute", ttl)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	token := ExpiredToken("alice", WithScope("greet:read"))
+	if _, err := auth.ParseToken(token, Keys()); !errors.Is(err, jwt.ErrTokenExpired) {
+		t.Errorf("got %v, want %v", err, jwt.ErrTokenExpired)
+	}
+	// it is expired, not badly signed
+	claims, err := auth.ParseToken(token, Keys(), jwt.WithLeeway(2*time.Minute))
+	if err != nil || claims.Scope != "greet:read" {
+		t.Errorf("with a leeway past the expiry: %+v, %v", claims, err)
+	}
+}
+
+func TestWrongKeyToken(t *testing.T) {
+	token := WrongKeyToken("alice")
+	if _, err := auth.ParseToken(token, Keys()); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("got %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+	}
+}
+
+func TestNoneAlgToken(t *testing.T) {
+	token := NoneAlgToken("alice")
+	if !strings.HasSuffix(token, ".") {
+		t.Errorf("token %q carries a signature", token)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg := parsed.Header["alg"]; alg != "none" {
+		t.Errorf("alg = %v, want none", alg)
+	}
+	if _, err := auth.ParseToken(token, Keys()); err == nil {
+		t.Error("an unsigned token verifies")
+	}
+}
+
+func TestWithAudience(t *testing.T) {
+	token := ValidToken("alice", WithAudience("server", "reports"))
+
+	if _, err := auth.ParseToken(token, Keys(), jwt.WithAudience("reports")); err != nil {
+		t.Errorf("token for its audience: %v", err)
+	}
+	if _, err := auth.ParseToken(token, Keys(), jwt.WithAudience("billing")); !errors.Is(err, jwt.ErrTokenInvalidAudience) {
+		t.Errorf("token for another audience: got %v, want %v", err, jwt.ErrTokenInvalidAudience)
+	}
+}
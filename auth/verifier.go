@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"os"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// ErrAlgorithmNotAccepted is returned for keys of an algorithm a verifier
+// does not accept.
+var ErrAlgorithmNotAccepted = errors.New("signing algorithm not accepted")
+
+// Verifier supplies the keys tokens are verified against. Only tokens whose
+// alg is in Algorithms are accepted at all, whatever their signature.
+type Verifier interface {
+	Algorithms() []string
+	// VerificationKeysFor returns the keys for alg, failing with
+	// ErrAlgorithmNotAccepted if alg is not accepted and ErrNoKeys if there
+	// are none at all. A non-empty kid narrows them to the key of that id.
+	VerificationKeysFor(alg, kid string) (jwt.VerificationKeySet, error)
+	// Ready reports whether every accepted algorithm has a usable key.
+	Ready() bool
+}
+
+func (k *Keyring) Algorithms() []string {
+	return []string{k.method.Alg()}
+}
+
+func (k *Keyring) VerificationKeysFor(alg, kid string) (jwt.VerificationKeySet, error) {
+	if alg != k.method.Alg() {
+		return jwt.VerificationKeySet{}, ErrAlgorithmNotAccepted
+	}
+	return k.verificationKeysFor(kid)
+}
+
+// Keyrings accepts tokens of several algorithms, one keyring each, e.g.
+// both HS256 and RS256 while migrating from one to the other.
+type Keyrings []*Keyring
+
+func (ks Keyrings) Algorithms() []string {
+	algs := make([]string, len(ks))
+	for i, k := range ks {
+		algs[i] = k.method.Alg()
+	}
+	return algs
+}
+
+func (ks Keyrings) VerificationKeysFor(alg, kid string) (jwt.VerificationKeySet, error) {
+	for _, k := range ks {
+		if k.method.Alg() == alg {
+			return k.VerificationKeysFor(alg, kid)
+		}
+	}
+	return jwt.VerificationKeySet{}, ErrAlgorithmNotAccepted
+}
+
+func (ks Keyrings) Ready() bool {
+	for _, k := range ks {
+		if !k.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// ReloadOn reloads the keyrings backed by a file every time the process
+// receives one of sigs.
+func (ks Keyrings) ReloadOn(sigs ...os.Signal) {
+	for _, k := range ks {
+		if k.path != "" {
+			k.ReloadOn(sigs...)
+		}
+	}
+}
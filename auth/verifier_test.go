@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// rsaKeyrings returns an RS256 signing keyring and the keyring verifying
+// its tokens, and the public key PEM.
+func rsaKeyrings(t testing.TB) (signing, verifying *Keyring, pubPEM []byte) {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, _ := x509.MarshalPKCS8PrivateKey(priv)
+	pubDER, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	pubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+
+	dir := t.TempDir()
+	keyPath, pubPath := filepath.Join(dir, "jwt.key"), filepath.Join(dir, "jwt.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubPath, pubPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if signing, err = LoadSigningKeyring("RS256", keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if verifying, err = LoadVerificationKeyring("RS256", pubPath, 0); err != nil {
+		t.Fatal(err)
+	}
+	return signing, verifying, pubPEM
+}
+
+func signWith(t *testing.T, method jwt.SigningMethod, key any, claims Claims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAcceptedAlgorithms(t *testing.T) {
+	secret := []byte("verifier-test-secret")
+	hs := StaticKeyring(secret)
+	rsSigning, rs, pubPEM := rsaKeyrings(t)
+	hsToken := mustSign(t, hs, testClaims("alice"))
+	rsToken := mustSign(t, rsSigning, testClaims("alice"))
+
+	// during a migration both are accepted
+	both := Keyrings{hs, rs}
+	for name, token := range map[string]string{"HS256": hsToken, "RS256": rsToken} {
+		if _, err := ParseToken(token, both); err != nil {
+			t.Errorf("%s while accepting both: %v", name, err)
+		}
+	}
+
+	// once HS256 is dropped its tokens are refused, though the secret still
+	// verifies them
+	if _, err := ParseToken(hsToken, Keyrings{rs}); err == nil {
+		t.Error("an HS256 token was accepted with only RS256 allowed")
+	}
+	if _, err := ParseToken(rsToken, Keyrings{rs}); err != nil {
+		t.Errorf("RS256 only: %v", err)
+	}
+
+	for name, token := range map[string]string{
+		"HS512 with the HS256 secret":   signWith(t, jwt.SigningMethodHS512, secret, testClaims("mallory")),
+		"HS256 with the RSA public key": signWith(t, jwt.SigningMethodHS256, pubPEM, testClaims("mallory")),
+		"alg none":                      signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, testClaims("mallory")),
+	} {
+		if _, err := ParseToken(token, both); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
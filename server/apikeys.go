@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"auth"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownAPIKey   = errors.New("unknown or revoked api key")
+	ErrAPIKeyThrottled = errors.New("too many api key lookups")
+)
+
+// APIKeyResolver turns an X-API-Key into the claims it authorizes, so
+// handlers see the same auth.Claims whichever scheme the caller used.
+type APIKeyResolver interface {
+	Resolve(ctx context.Context, key string) (*auth.Claims, error)
+}
+
+// userServiceAPIKeys asks the user service, which mints and stores the keys.
+type userServiceAPIKeys struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewUserServiceAPIKeys(baseURL string, client *http.Client) APIKeyResolver {
+	return &userServiceAPIKeys{baseURL: baseURL, client: client}
+}
+
+func (c *userServiceAPIKeys) Resolve(ctx context.Context, key string) (*auth.Claims, error) {
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/internal/apikeys/resolve", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrUnknownAPIKey
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, ErrAPIKeyThrottled
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service returned %s", resp.Status)
+	}
+
+	var k struct {
+		ID      string `json:"id"`
+		Subject string `json:"subject"`
+		Scope   string `json:"scope"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&k); err != nil {
+		return nil, err
+	}
+	return &auth.Claims{
+		Scope: k.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:      "apikey:" + k.ID,
+			Subject: k.Subject,
+		},
+	}, nil
+}
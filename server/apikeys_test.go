@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth/tokentest"
+)
+
+// fakeAPIKeys serves the user service's key resolution for keys, by key.
+func fakeAPIKeys(t *testing.T, keys map[string]map[string]string) {
+	t.Helper()
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Key string `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		k, ok := keys[req.Key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(k)
+	}))
+	t.Cleanup(users.Close)
+	APIKeys = NewUserServiceAPIKeys(users.URL, users.Client())
+}
+
+func withAPIKey(h http.Handler, key, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/v1/hello", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-Key", key)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	h := newTestServer(t)
+	fakeAPIKeys(t, map[string]map[string]string{
+		"greeter-key": {"id": "k1", "subject": "cron", "scope": "greet:read"},
+		"profile-key": {"id": "k2", "subject": "cron", "scope": "profile"},
+	})
+
+	expectOK(t, withAPIKey(h, "greeter-key", ""))
+	// unknown and revoked keys look the same to the server
+	expectError(t, withAPIKey(h, "revoked-key", ""), http.StatusUnauthorized, "invalid_api_key")
+	// scopes are enforced as for tokens
+	expectError(t, withAPIKey(h, "profile-key", ""), http.StatusForbidden, "insufficient_scope")
+	expectError(t, get(h, "/api/v1/hello", tokentest.ValidToken("cron", tokentest.WithScope("profile"))), http.StatusForbidden, "insufficient_scope")
+}
+
+func TestAPIKeyJWTPrecedence(t *testing.T) {
+	h := newTestServer(t)
+	fakeAPIKeys(t, map[string]map[string]string{
+		"greeter-key": {"id": "k1", "subject": "cron", "scope": "greet:read"},
+	})
+
+	expectOK(t, withAPIKey(h, "unknown-key", tokentest.ValidToken("alice", greeter)))
+	expectError(t, withAPIKey(h, "greeter-key", tokentest.ExpiredToken("alice", greeter)), http.StatusUnauthorized, "token_expired")
+}
+
+func TestAPIKeyResolverDown(t *testing.T) {
+	h := newTestServer(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer down.Close()
+	APIKeys = NewUserServiceAPIKeys(down.URL, down.Client())
+
+	rec := withAPIKey(h, "greeter-key", "")
+	expectError(t, rec, http.StatusTooManyRequests, "rate_limited")
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After on a throttled lookup")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"auth/tokentest"
+)
+
+// untested are codes the service writes only when something it depends on
+// fails in a way the tests do not stage.
+var untested = map[string]bool{}
+
+// emitted collects the error codes answered during the test run, and the
+// requests that asked for JSON but got text/plain.
+var emitted = struct {
+	sync.Mutex
+	codes map[string]bool
+	plain []string
+}{codes: map[string]bool{}}
+
+// recordCodes notes the error code of every response h writes to an
+// httptest.ResponseRecorder.
+func recordCodes(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		rec, ok := w.(*httptest.ResponseRecorder)
+		if !ok || rec.Code < 400 {
+			return
+		}
+		emitted.Lock()
+		defer emitted.Unlock()
+		ct, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+		if ct == "text/plain" && strings.Contains(r.Header.Get("Accept"), "application/json") {
+			emitted.plain = append(emitted.plain, r.Method+" "+r.URL.Path)
+		}
+		var body struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if json.Unmarshal(rec.Body.Bytes(), &body) != nil {
+			return
+		}
+		var nested struct {
+			Code string `json:"code"`
+		}
+		var flat string // the token endpoint's RFC 6749 errors
+		if json.Unmarshal(body.Error, &nested) == nil {
+			emitted.codes[nested.Code] = true
+		} else if json.Unmarshal(body.Error, &flat) == nil {
+			emitted.codes[flat] = true
+		}
+	})
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	status := m.Run()
+	// only a full run exercises every path
+	if status == 0 && flag.Lookup("test.run").Value.String() == "" {
+		status = checkCodes()
+	}
+	os.Exit(status)
+}
+
+// checkCodes reports the codes this service writes that no test got
+// back, and any text/plain error sent to a client asking for JSON.
+func checkCodes() int {
+	registry, err := codeRegistry(filepath.Join("..", "auth", "httpx", "codes.go"))
+	if err == nil {
+		var used map[string]bool
+		if used, err = codesUsed(registry); err == nil {
+			var missing []string
+			for code := range used {
+				if !emitted.codes[code] && !untested[code] {
+					missing = append(missing, code)
+				}
+			}
+			sort.Strings(missing)
+			if len(missing) > 0 {
+				err = fmt.Errorf("no test gets back the error codes %s", strings.Join(missing, ", "))
+			}
+		}
+	}
+	if err == nil && len(emitted.plain) > 0 {
+		err = fmt.Errorf("text/plain errors to requests asking for JSON: %s", strings.Join(emitted.plain, ", "))
+	}
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return 1
+	}
+	return 0
+}
+
+// codeRegistry reads the Code constants declared in path, by name.
+func codeRegistry(path string) (map[string]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	codes := map[string]string{}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			v := spec.(*ast.ValueSpec)
+			if len(v.Values) != 1 {
+				continue
+			}
+			lit, ok := v.Values[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				continue
+			}
+			value, _ := strconv.Unquote(lit.Value)
+			codes[v.Names[0].Name] = value
+		}
+	}
+	return codes, nil
+}
+
+// codesUsed returns the codes the service's own source refers to.
+func codesUsed(registry map[string]string) (map[string]bool, error) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, pkg := range pkgs {
+		ast.Inspect(pkg, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == "httpx" {
+				if code, ok := registry[sel.Sel.Name]; ok {
+					used[code] = true
+				}
+			}
+			return true
+		})
+	}
+	return used, nil
+}
+
+func TestCodeRegistry(t *testing.T) {
+	registry, err := codeRegistry(filepath.Join("..", "auth", "httpx", "codes.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]string{}
+	for name, code := range registry {
+		if other, ok := seen[code]; ok {
+			t.Errorf("%s and %s are both %q", name, other, code)
+		}
+		seen[code] = name
+		if code == "" || strings.Trim(code, "abcdefghijklmnopqrstuvwxyz_") != "" {
+			t.Errorf("%s = %q is not snake_case", name, code)
+		}
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	h := newTestServer(t)
+	token := tokentest.ValidToken("alice", greeter)
+	expectError(t, get(h, "/api/v1/ws", token), http.StatusBadRequest, "invalid_request")
+
+	req := httptest.NewRequest("GET", "/api/v1/ws", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://evil.example")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	expectError(t, rec, http.StatusForbidden, "forbidden")
+}
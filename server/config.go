@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"auth"
+	"auth/config"
+)
+
+// Config is the server's configuration. Each setting comes from a flag,
+// else its environment variable, else the default.
+type Config struct {
+	Addr                 string
+	LogFormat            string
+	JWTAlg               string
+	JWTAcceptedAlgs      string
+	JWTSecret            string
+	JWTSecretFile        string
+	JWTKeyFile           string
+	JWTKeyGrace          time.Duration
+	JWKSURL              string
+	JWKSRefresh          time.Duration
+	JWEKeyFile           string
+	Audience             string
+	Leeway               time.Duration
+	MaxTokenLifetime     time.Duration
+	MaxTokenAge          time.Duration
+	LegacyClaimsUntil    time.Time
+	SubjectDenylist      string
+	RequestTimeout       time.Duration
+	HSTSMaxAge           time.Duration
+	Dev                  bool
+	LegacyRoutes         bool
+	RedisAddr            string
+	RevocationFailOpen   bool
+	TokenVersionCacheTTL time.Duration
+	VerifyCacheTTL       time.Duration
+	UserServiceURL       string
+	InternalSecret       string
+}
+
+func parseConfig(args []string, getenv func(string) string) (Config, *config.FlagSet, error) {
+	var c Config
+	fs := config.NewFlagSet("server", getenv)
+
+	defAddr := ":8081"
+	if port := getenv("PORT"); port != "" {
+		defAddr = ":" + port
+	}
+	fs.String(&c.Addr, "addr", "ADDR", defAddr, "listen address, defaults to :$PORT when PORT is set")
+	fs.String(&c.LogFormat, "log-format", "LOG_FORMAT", "text", "log format: text or json")
+	fs.String(&c.JWTAlg, "jwt-alg", "JWT_ALG", "HS256", "signing algorithm: "+strings.Join(auth.Algorithms(), ", "))
+	fs.String(&c.JWTAcceptedAlgs, "jwt-accepted-algs", "JWT_ACCEPTED_ALGS", "", "comma-separated algorithms accepted, e.g. HS256,RS256 during a migration (defaults to -jwt-alg)")
+	fs.String(&c.JWTSecret, "jwt-secret", "JWT_SECRET", "", "HS256 secret (defaults to the tutorial secret)")
+	fs.String(&c.JWTSecretFile, "jwt-secret-file", "JWT_SECRET_FILE", "", "file holding the HS256 secret, reloaded on SIGHUP")
+	fs.String(&c.JWTKeyFile, "jwt-key-file", "JWT_KEY_FILE", "", "public key file for RS256/EdDSA, reloaded on SIGHUP")
+	fs.Duration(&c.JWTKeyGrace, "jwt-key-grace", "JWT_KEY_GRACE", 10*time.Minute, "how long the previous key still verifies after a reload")
+	fs.String(&c.JWKSURL, "jwks-url", "JWKS_URL", "", "issuer JWKS to take RS256/EdDSA keys from instead of a key file")
+	fs.Duration(&c.JWKSRefresh, "jwks-refresh", "JWKS_REFRESH", 5*time.Minute, "how often the JWKS is fetched again")
+	fs.String(&c.JWEKeyFile, "jwe-key-file", "JWE_KEY_FILE", "", "key to decrypt encrypted (JWE) tokens with; plain ones are accepted too")
+	fs.String(&c.Audience, "jwt-audience", "JWT_AUDIENCE", "", "the audience (aud) tokens must be issued for; empty accepts only tokens without one")
+	fs.Duration(&c.Leeway, "jwt-leeway", "JWT_LEEWAY", 0, "clock skew tolerated on exp, nbf and iat")
+	fs.Duration(&c.MaxTokenLifetime, "max-token-lifetime", "MAX_TOKEN_LIFETIME", 0, "reject tokens issued for longer than this (0 = no cap)")
+	fs.Duration(&c.MaxTokenAge, "max-token-age", "MAX_TOKEN_AGE", 0, "reject tokens issued longer ago than this (0 = no cap)")
+	fs.Time(&c.LegacyClaimsUntil, "legacy-claims-until", "LEGACY_CLAIMS_UNTIL", time.Time{}, "accept tokens in the previous claims format (logged) until this RFC 3339 time; unset, they are refused")
+	fs.String(&c.SubjectDenylist, "subject-denylist-file", "SUBJECT_DENYLIST_FILE", "", "file listing subjects whose tokens are refused, reloaded on SIGHUP")
+	fs.Duration(&c.RequestTimeout, "request-timeout", "REQUEST_TIMEOUT", 5*time.Second, "deadline for handling a request, after which it gets 503 (0 disables)")
+	fs.Duration(&c.HSTSMaxAge, "hsts-max-age", "HSTS_MAX_AGE", 365*24*time.Hour, "Strict-Transport-Security max-age sent over TLS (0 disables)")
+	fs.Bool(&c.Dev, "dev", "DEV", false, "development mode: serve debug endpoints such as /debug/decode and call other hosts over plain HTTP")
+	fs.Bool(&c.LegacyRoutes, "legacy-routes", "LEGACY_ROUTES", true, "also serve the unversioned routes")
+	fs.String(&c.RedisAddr, "redis-addr", "REDIS_ADDR", "", "Redis server holding revoked token ids")
+	fs.Bool(&c.RevocationFailOpen, "revocation-fail-open", "REVOCATION_FAIL_OPEN", false, "accept tokens when revocations cannot be checked instead of answering 503")
+	fs.Duration(&c.VerifyCacheTTL, "verify-cache-ttl", "VERIFY_CACHE_TTL", 0, "how long verified tokens are remembered to skip signature checks (0 disables the cache)")
+	fs.Duration(&c.TokenVersionCacheTTL, "token-version-cache-ttl", "TOKEN_VERSION_CACHE_TTL", 30*time.Second, "how long users' token versions are cached (0 looks them up on every request)")
+	fs.String(&c.UserServiceURL, "user-service-url", "USER_SERVICE_URL", "http://localhost:8080", "user service asked about revocations when Redis is not used")
+	fs.String(&c.InternalSecret, "internal-secret", "INTERNAL_SECRET", "", "the user service's INTERNAL_SECRET, sent with every call to it")
+
+	if err := fs.Parse(args); err != nil {
+		return c, fs, err
+	}
+	return c, fs, c.validate()
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Addr == "":
+		return errors.New("empty listen address")
+	case c.RequestTimeout < 0:
+		return errors.New("request timeout must not be negative")
+	case c.TokenVersionCacheTTL < 0:
+		return errors.New("token version cache TTL must not be negative")
+	case c.VerifyCacheTTL < 0:
+		return errors.New("verification cache TTL must not be negative")
+	case c.MaxTokenAge < 0:
+		return errors.New("max token age must not be negative")
+	case c.LogFormat != "text" && c.LogFormat != "json":
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	case c.JWTSecret != "" && c.JWTSecretFile != "":
+		return errors.New("set either a JWT secret or a secret file, not both")
+	case c.RedisAddr == "" && c.UserServiceURL == "":
+		return errors.New("need either a Redis address or the user service URL to check revocations")
+	case !c.Dev && cleartextRemote(c.UserServiceURL):
+		return errors.New("the user service URL must use https outside dev mode, unless it is on this host")
+	case !c.Dev && cleartextRemote(c.JWKSURL):
+		return errors.New("the JWKS URL must use https outside dev mode, unless it is on this host")
+	}
+
+	// HS256 takes the secret, any other algorithm the one key file or JWKS
+	var asymmetric []string
+	for _, alg := range c.acceptedAlgs() {
+		if alg != "HS256" {
+			asymmetric = append(asymmetric, alg)
+		}
+	}
+	switch {
+	case len(asymmetric) > 1:
+		return fmt.Errorf("at most one of %s can be accepted, there is a single key file", strings.Join(asymmetric, ", "))
+	case len(asymmetric) == 1 && c.JWTKeyFile == "" && c.JWKSURL == "":
+		return fmt.Errorf("%s needs a key file or a JWKS URL", asymmetric[0])
+	case c.JWKSURL != "" && c.JWTKeyFile != "":
+		return errors.New("set either a key file or a JWKS URL, not both")
+	case c.JWKSURL != "" && c.JWKSRefresh <= 0:
+		return errors.New("JWKS refresh interval must be positive")
+	}
+	return nil
+}
+
+// cleartextRemote reports whether rawURL is plain HTTP to another host. The
+// user service only answers those from this host (or over TLS), and the
+// service secret would cross the network in the clear.
+func cleartextRemote(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback()
+}
+
+// acceptedAlgs lists the algorithms tokens may be signed with.
+func (c Config) acceptedAlgs() []string {
+	if c.JWTAcceptedAlgs == "" {
+		return []string{c.JWTAlg}
+	}
+	var algs []string
+	for _, alg := range strings.Split(c.JWTAcceptedAlgs, ",") {
+		if alg = strings.TrimSpace(alg); alg != "" {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// String prints the configuration with the secret redacted.
+func (c Config) String() string {
+	c.JWTSecret = config.Redact(c.JWTSecret)
+	c.InternalSecret = config.Redact(c.InternalSecret)
+	type plain Config // drop the String method to avoid recursion
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+func loadConfig() Config {
+	c, fs, err := parseConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fs.Usage(os.Stdout, err)
+		os.Exit(0)
+	}
+	if err != nil {
+		fs.Usage(os.Stderr, err)
+		os.Exit(2)
+	}
+	return c
+}
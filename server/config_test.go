@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		addr string
+	}{
+		{"default", nil, nil, ":8081"},
+		{"PORT", nil, map[string]string{"PORT": "9001"}, ":9001"},
+		{"env", nil, map[string]string{"ADDR": ":9000", "PORT": "9001"}, ":9000"},
+		{"flag", []string{"-addr", ":9002"}, map[string]string{"ADDR": ":9000"}, ":9002"},
+	} {
+		cfg, _, err := parseConfig(append([]string{"-dev"}, tc.args...), func(k string) string { return tc.env[k] })
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if cfg.Addr != tc.addr {
+			t.Errorf("%s: addr %q, want %q", tc.name, cfg.Addr, tc.addr)
+		}
+	}
+
+	if _, _, err := parseConfig([]string{"-dev", "-addr", ""}, func(string) string { return "" }); err == nil {
+		t.Error("an empty addr was accepted")
+	}
+}
+
+func TestAcceptedAlgs(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "HS256"},
+		{[]string{"-jwt-alg", "RS256"}, "RS256"},
+		{[]string{"-jwt-accepted-algs", "HS256, RS256"}, "HS256,RS256"},
+	} {
+		args := append([]string{"-dev", "-jwks-url", "http://localhost:8080/.well-known/jwks.json"}, tc.args...)
+		cfg, _, err := parseConfig(args, func(string) string { return "" })
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if got := strings.Join(cfg.acceptedAlgs(), ","); got != tc.want {
+			t.Errorf("%v: accepted %s, want %s", tc.args, got, tc.want)
+		}
+	}
+}
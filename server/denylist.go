@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// SubjectDenylist is an emergency kill switch: tokens of the listed
+// subjects are refused even though they are otherwise valid. The list is
+// read from a file with one subject per line ('#' starts a comment).
+type SubjectDenylist struct {
+	path     string
+	subjects atomic.Pointer[map[string]bool]
+}
+
+func LoadSubjectDenylist(path string) (*SubjectDenylist, error) {
+	d := &SubjectDenylist{path: path}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reload re-reads the file, replacing the whole list at once.
+func (d *SubjectDenylist) Reload() error {
+	b, err := os.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+
+	subjects := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if sub := strings.TrimSpace(line); sub != "" {
+			subjects[sub] = true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	d.subjects.Store(&subjects)
+	return nil
+}
+
+func (d *SubjectDenylist) Denied(subject string) bool {
+	return (*d.subjects.Load())[subject]
+}
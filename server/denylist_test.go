@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"auth/tokentest"
+)
+
+func writeDenylist(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubjectDenylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist")
+	writeDenylist(t, path, "# compromised accounts\nmallory\n")
+
+	h := newTestServer(t)
+	var err error
+	if Denylist, err = LoadSubjectDenylist(path); err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, get(h, "/api/v1/hello", tokentest.ValidToken("mallory", greeter)), http.StatusForbidden, "subject_blocked")
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+
+	// a reload blocks tokens already issued
+	alice := tokentest.ValidToken("alice", greeter)
+	writeDenylist(t, path, "mallory\nalice # lost her laptop\n")
+	if err := Denylist.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, get(h, "/api/v1/hello", alice), http.StatusForbidden, "subject_blocked")
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("bob", greeter)))
+}
+
+func TestSubjectDenylistReloadFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist")
+	writeDenylist(t, path, "mallory\n")
+	d, err := LoadSubjectDenylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if err := d.Reload(); err == nil {
+		t.Fatal("reloading a missing file succeeded")
+	}
+	if !d.Denied("mallory") {
+		t.Error("a failed reload dropped the list")
+	}
+}
@@ -5,6 +5,17 @@ import (
 	"net/http"
 )
 
+// HandleReady is the readiness probe: ready once every accepted algorithm
+// has a key to verify tokens with.
+func HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !Keys.Ready() {
+		keysUnavailable(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
 func HandleGreet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("Hello my friend. Looks like u r authorized.")
 }
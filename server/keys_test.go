@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth"
+	"auth/tokentest"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// unavailableKeys returns an RS256 keyring whose JWKS cannot be fetched.
+func unavailableKeys(t *testing.T) *auth.Keyring {
+	t.Helper()
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(jwks.Close)
+	keys, err := auth.LoadJWKSKeyring("RS256", jwks.URL)
+	if err == nil {
+		t.Fatal("loading keys from a JWKS that is down worked")
+	}
+	return keys
+}
+
+func rs256Token(t *testing.T) string {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokentest.Claims("alice", greeter))
+	token.Header["kid"] = "unknown"
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestKeysUnavailable(t *testing.T) {
+	h := newTestServer(t)
+	Keys = auth.Keyrings{unavailableKeys(t)}
+
+	rec := get(h, "/api/v1/hello", rs256Token(t))
+	expectError(t, rec, http.StatusServiceUnavailable, "unavailable")
+	if ra := rec.Header().Get("Retry-After"); ra == "" {
+		t.Error("no Retry-After on a 503")
+	}
+	rec = get(h, "/readyz", "")
+	expectError(t, rec, http.StatusServiceUnavailable, "unavailable")
+	if ra := rec.Header().Get("Retry-After"); ra == "" {
+		t.Error("no Retry-After on an unready probe")
+	}
+
+	// a token that is bad on its face is still the client's problem
+	expectError(t, get(h, "/api/v1/hello", "not-a-token"), http.StatusUnauthorized, "token_invalid")
+}
+
+func TestKeysPartlyUnavailable(t *testing.T) {
+	h := newTestServer(t)
+	Keys = auth.Keyrings{tokentest.Keys(), unavailableKeys(t)}
+
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+	expectError(t, get(h, "/api/v1/hello", rs256Token(t)), http.StatusServiceUnavailable, "unavailable")
+	expectError(t, get(h, "/readyz", ""), http.StatusServiceUnavailable, "unavailable")
+}
+
+func TestReady(t *testing.T) {
+	expectOK(t, get(newTestServer(t), "/readyz", ""))
+}
@@ -3,20 +3,161 @@ package main
 import (
 	"log"
 	"net/http"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
+	"auth"
+	"auth/config"
+
+	"github.com/redis/go-redis/v9"
 )
 
-var JwtKey []byte
+var (
+	Keys        auth.Keyrings
+	Revocations RevocationChecker
+	// APIKeys resolves X-API-Key headers; nil when there is no user service.
+	APIKeys APIKeyResolver
+	// TokenVersions spots tokens from before a password change; nil when
+	// there is no user service.
+	TokenVersions TokenVersionChecker
+
+	// MaxTokenLifetime caps exp - iat of accepted tokens; zero means no cap.
+	MaxTokenLifetime time.Duration
+	// MaxTokenAge caps the time since iat of accepted tokens; zero means no
+	// cap.
+	MaxTokenAge time.Duration
+	// LegacyClaimsUntil ends the migration window after auth.ClaimsVersion
+	// went up, during which tokens in the previous claims format are
+	// accepted; zero means there is none.
+	LegacyClaimsUntil time.Time
+	// Leeway tolerates clock skew when checking exp, nbf and iat.
+	Leeway time.Duration
+	// Audience is the aud tokens must carry to be accepted here; empty
+	// accepts only tokens without one.
+	Audience string
+	// Encryption decrypts encrypted tokens; nil when no key is configured.
+	Encryption *auth.TokenEncryption
+	// Verified caches verified tokens; nil when caching is off.
+	Verified *auth.TokenCache
+	// RevocationFailOpen accepts tokens whose revocation status cannot be
+	// checked instead of refusing them.
+	RevocationFailOpen bool
+	// Denylist blocks subjects outright; nil when not configured.
+	Denylist *SubjectDenylist
+)
 
 func main() {
-	JwtKey = []byte("supersecretkey")
+	cfg := loadConfig()
+	if err := config.SetLogFormat(cfg.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("effective configuration: %v", cfg)
+
+	Keys = loadKeys(cfg)
+	Keys.ReloadOn(syscall.SIGHUP)
+	if cfg.JWEKeyFile != "" {
+		var err error
+		if Encryption, err = auth.LoadTokenEncryption(cfg.JWEKeyFile); err != nil {
+			log.Fatalf("loading token encryption key: %v", err)
+		}
+	}
+
+	Revocations = openRevocations(cfg)
+	if cfg.UserServiceURL != "" {
+		APIKeys = NewUserServiceAPIKeys(cfg.UserServiceURL, userServiceClient(cfg))
+		TokenVersions = NewUserServiceTokenVersions(cfg.UserServiceURL, userServiceClient(cfg))
+		if cfg.TokenVersionCacheTTL > 0 {
+			TokenVersions = NewCachedTokenVersions(TokenVersions, cfg.TokenVersionCacheTTL)
+		}
+	}
+	if cfg.SubjectDenylist != "" {
+		var err error
+		if Denylist, err = LoadSubjectDenylist(cfg.SubjectDenylist); err != nil {
+			log.Fatalf("loading subject denylist: %v", err)
+		}
+		auth.ReloadOn("subject denylist", Denylist.Reload, syscall.SIGHUP)
+	}
+	applyConfig(cfg)
 
-	r := mux.NewRouter()
-	r.Use(jwtAuthMiddleware)
-	r.HandleFunc("/hello", HandleGreet).Methods("GET")
+	r := newRouter(cfg)
+
+	log.Printf("auth service listening on: %s", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, r))
+}
+
+// applyConfig sets the globals that come straight from the configuration,
+// without opening anything.
+func applyConfig(cfg Config) {
+	RevocationFailOpen = cfg.RevocationFailOpen
+	MaxTokenLifetime = cfg.MaxTokenLifetime
+	MaxTokenAge = cfg.MaxTokenAge
+	LegacyClaimsUntil = cfg.LegacyClaimsUntil
+	Leeway = cfg.Leeway
+	Audience = cfg.Audience
+	Verified = nil
+	if cfg.VerifyCacheTTL > 0 {
+		Verified = auth.NewTokenCache(cfg.VerifyCacheTTL, maxCachedTokens)
+	}
+}
+
+// maxCachedTokens bounds the verification cache.
+const maxCachedTokens = 10000
+
+// loadKeys reads a verification key per accepted algorithm: the HS256
+// secret (given directly or in a file, else the tutorial's hardcoded one) or
+// the RS256/EdDSA public key, from a file or the issuer's JWKS. After a file
+// reload the old key is still accepted for the grace period so tokens signed
+// just before stay valid.
+func loadKeys(cfg Config) auth.Keyrings {
+	var keys auth.Keyrings
+	for _, alg := range cfg.acceptedAlgs() {
+		keys = append(keys, loadKeyring(cfg, alg))
+	}
+	return keys
+}
+
+func loadKeyring(cfg Config, alg string) *auth.Keyring {
+	path := cfg.JWTKeyFile
+	if alg == "HS256" {
+		if cfg.JWTSecretFile == "" {
+			secret := cfg.JWTSecret
+			if secret == "" {
+				secret = auth.DefaultSecret
+			}
+			return auth.StaticKeyring([]byte(secret))
+		}
+		path = cfg.JWTSecretFile
+	}
+	if cfg.JWKSURL != "" {
+		keys, err := auth.LoadJWKSKeyring(alg, cfg.JWKSURL)
+		if keys == nil {
+			log.Fatalf("loading %s keys: %v", alg, err)
+		}
+		if err != nil {
+			// the issuer may just be starting too; answer 503 until it is up
+			log.Printf("ERROR: loading %s keys, will retry: %v", alg, err)
+		}
+		keys.ReloadEvery(cfg.JWKSRefresh)
+		return keys
+	}
+
+	keys, err := auth.LoadVerificationKeyring(alg, path, cfg.JWTKeyGrace)
+	if err != nil {
+		log.Fatalf("loading %s keys: %v", alg, err)
+	}
+	return keys
+}
+
+// openRevocations reads revoked token ids straight from Redis when configured,
+// otherwise it asks the user service.
+func openRevocations(cfg Config) RevocationChecker {
+	if cfg.RedisAddr != "" {
+		return auth.NewRedisRevocationStore(redis.NewClient(&redis.Options{Addr: cfg.RedisAddr}))
+	}
+	return NewUserServiceRevocations(cfg.UserServiceURL, userServiceClient(cfg))
+}
 
-	port := "8081"
-	log.Printf("auth service listening on: %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+// userServiceClient calls the user service's internal routes.
+func userServiceClient(cfg Config) *http.Client {
+	return auth.ServiceClient(cfg.InternalSecret, 2*time.Second)
 }
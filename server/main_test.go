@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth"
+	"auth/tokentest"
+)
+
+// checkerFunc adapts a function to RevocationChecker.
+type checkerFunc func(jti string) (bool, error)
+
+func (f checkerFunc) IsRevoked(_ context.Context, jti string) (bool, error) {
+	return f(jti)
+}
+
+// newTestServer resets the service's globals to accept tokentest tokens,
+// none of them revoked, and returns its router, configured in dev mode plus
+// args, with the error codes it answers recorded (see recordCodes).
+func newTestServer(t *testing.T, args ...string) http.Handler {
+	t.Helper()
+	cfg, _, err := parseConfig(append([]string{"-dev"}, args...), func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	Keys = auth.Keyrings{tokentest.Keys()}
+	Revocations = checkerFunc(func(string) (bool, error) { return false, nil })
+	APIKeys = nil
+	TokenVersions = nil
+	Encryption = nil
+	Denylist = nil
+	applyConfig(cfg)
+	return recordCodes(newRouter(cfg))
+}
+
+// get requests path asking for JSON, with token as bearer token unless it
+// is empty.
+func get(h http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// expectError fails the test unless rec is a JSON error with status and
+// code.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, status, rec.Body)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if body.Error.Code != code {
+		t.Errorf("error code = %q, want %q", body.Error.Code, code)
+	}
+}
+
+// expectOK fails the test unless rec is a 200.
+func expectOK(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rec.Code, rec.Body)
+	}
+}
+
+// greeter is the scope /hello requires.
+var greeter = tokentest.WithScope("greet:read")
@@ -1,51 +1,189 @@
 package main
 
 import (
-	"context"
-	"log"
+	"errors"
 	"net/http"
-	"strings"
+
+	"auth"
+	"auth/httpx"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-type contextKey string
-
-const ClaimsContextKey contextKey = "claims"
-
+// jwtAuthMiddleware authenticates the request with its bearer token or,
+// when it has none, its X-API-Key.
 func jwtAuthMiddleware(next http.Handler) http.Handler {
-	secret := []byte("supersecretkey")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h := r.Header.Get("Authorization")
-		if h == "" {
-			log.Println("ERROR: missing Authorization header")
-			http.Error(w, "missing Authorization header", http.StatusUnauthorized)
+		tokenStr, err := auth.BearerToken(r)
+		if key := r.Header.Get("X-API-Key"); errors.Is(err, auth.ErrMissingHeader) && key != "" && APIKeys != nil {
+			apiKeyAuth(next, w, r, key)
+			return
+		}
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			unauthorized(w, r, err)
 			return
 		}
 
-		parts := strings.SplitN(h, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			log.Println("ERROR: invalid Authorization header")
-			http.Error(w, "invalid Authorization header", http.StatusUnauthorized)
+		claims, err := parseToken(tokenStr)
+		if errors.Is(err, auth.ErrNoKeys) {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			keysUnavailable(w, r)
+			return
+		}
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: invalid token: ", err)
+			unauthorized(w, r, err)
+			return
+		}
+		if err := auth.CheckAudience(claims, Audience); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			unauthorized(w, r, err)
 			return
 		}
 
-		tokenStr := parts[1]
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			// ensure HMAC signing method HS256
-			if t.Method.Alg()[:2] != "HS" {
-				return nil, jwt.ErrTokenSignatureInvalid
+		if denied(w, r, claims) {
+			return
+		}
+
+		if MaxTokenLifetime > 0 {
+			if err := auth.CheckLifetime(claims, MaxTokenLifetime); err != nil {
+				httpx.Log(r.Context()).Println("ERROR: ", err)
+				unauthorized(w, r, err)
+				return
 			}
-			return secret, nil
-		})
-		if err != nil || !token.Valid {
-			log.Println("ERROR: invalid token")
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+		}
+		if legacyClaims(w, r, claims) {
+			return
+		}
+		if MaxTokenAge > 0 {
+			if err := auth.CheckAge(claims, MaxTokenAge, Leeway); err != nil {
+				httpx.Log(r.Context()).Println("ERROR: ", err)
+				unauthorized(w, r, err)
+				return
+			}
+		}
+
+		revoked, err := Revocations.IsRevoked(r.Context(), claims.ID)
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: checking revocation: ", err)
+			if !RevocationFailOpen {
+				httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "revocations cannot be checked")
+				return
+			}
+		}
+		if revoked {
+			httpx.Log(r.Context()).Println("ERROR: revoked token")
+			httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenRevoked, "the token has been revoked")
+			return
+		}
+		if stale(w, r, claims) {
 			return
 		}
 
 		// put claims into context for handlers to use
-		ctx := context.WithValue(r.Context(), ClaimsContextKey, token.Claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	})
 }
+
+// legacyClaims refuses tokens in the previous claims format once the
+// migration window is over, and logs them while it lasts, to tell when
+// none are left.
+func legacyClaims(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	version := claims.Version
+	if err := auth.CheckClaimsVersion(claims, LegacyClaimsUntil); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		unauthorized(w, r, err)
+		return true
+	}
+	if version < auth.ClaimsVersion {
+		httpx.Log(r.Context()).Printf("legacy claims: version %d token for %s", version, claims.Subject)
+	}
+	return false
+}
+
+// unauthorized answers 401 for a missing or unacceptable token.
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	code, msg := auth.TokenError(err)
+	httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+}
+
+// keysUnavailable answers 503 while there is no key to check tokens with,
+// so clients do not take it for a bad token.
+func keysUnavailable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "10") // keys are fetched again at most that often
+	httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "no verification key is available")
+}
+
+// parseToken decrypts the token if it is encrypted, then verifies it,
+// through the verification cache when it is on.
+func parseToken(tokenStr string) (*auth.Claims, error) {
+	tokenStr, err := Encryption.Unwrap(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if Verified != nil {
+		return Verified.ParseToken(tokenStr, Keys, jwt.WithLeeway(Leeway))
+	}
+	return auth.ParseToken(tokenStr, Keys, jwt.WithLeeway(Leeway))
+}
+
+// apiKeyAuth authenticates the request with an API key. Revoked keys are
+// refused by the user service, so there is no jti to check.
+func apiKeyAuth(next http.Handler, w http.ResponseWriter, r *http.Request, key string) {
+	claims, err := APIKeys.Resolve(r.Context(), key)
+	if errors.Is(err, ErrUnknownAPIKey) {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidAPIKey, "")
+		return
+	}
+	if errors.Is(err, ErrAPIKeyThrottled) {
+		w.Header().Set("Retry-After", "60")
+		httpx.WriteError(w, r, http.StatusTooManyRequests, httpx.CodeRateLimited, "too many api key lookups, try again later")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: resolving api key: ", err)
+		httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "api keys cannot be checked")
+		return
+	}
+	if denied(w, r, claims) {
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+}
+
+// stale refuses user tokens issued before the user's last password change,
+// or for users that no longer exist. Lookup failures are treated like
+// failed revocation checks.
+func stale(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if TokenVersions == nil || claims.Client {
+		return false
+	}
+	version, err := TokenVersions.TokenVersion(r.Context(), claims.Subject)
+	if err == nil && version == claims.TokenVersion {
+		return false
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: checking token version: ", err)
+		if RevocationFailOpen {
+			return false
+		}
+		httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "token versions cannot be checked")
+		return true
+	}
+	httpx.Log(r.Context()).Println("ERROR: stale token for", claims.Subject)
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenStale, "the token was issued before a password change")
+	return true
+}
+
+// denied refuses subjects on the denylist.
+func denied(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if Denylist == nil || !Denylist.Denied(claims.Subject) {
+		return false
+	}
+	httpx.Log(r.Context()).Println("ERROR: denylisted subject", claims.Subject)
+	httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeSubjectBlocked, "")
+	return true
+}
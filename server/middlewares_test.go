@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"auth"
+	"auth/tokentest"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	h := newTestServer(t)
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+	for name, tc := range map[string]struct {
+		token, code string
+	}{
+		"no token":  {"", "missing_token"},
+		"garbage":   {"not-a-jwt", "token_invalid"},
+		"expired":   {tokentest.ExpiredToken("alice", greeter), "token_expired"},
+		"wrong key": {tokentest.WrongKeyToken("alice", greeter), "token_invalid"},
+		"alg none":  {tokentest.NoneAlgToken("alice", greeter), "token_invalid"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expectError(t, get(h, "/api/v1/hello", tc.token), http.StatusUnauthorized, tc.code)
+		})
+	}
+}
+
+func TestMaxTokenLifetime(t *testing.T) {
+	h := newTestServer(t, "-max-token-lifetime", "1h")
+
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithTTL(time.Hour))))
+	rec := get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithTTL(365*24*time.Hour)))
+	expectError(t, rec, http.StatusUnauthorized, "token_lifetime_exceeded")
+}
+
+func TestNotBefore(t *testing.T) {
+	h := newTestServer(t, "-jwt-leeway", "30s")
+
+	rec := get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithNotBefore(time.Now().Add(time.Minute))))
+	expectError(t, rec, http.StatusUnauthorized, "token_not_yet_valid")
+	// the leeway tolerates a clock that runs behind the issuer's
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithNotBefore(time.Now().Add(30*time.Second)))))
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+}
+
+func TestPanicInAuthMiddleware(t *testing.T) {
+	h := newTestServer(t)
+	Revocations = checkerFunc(func(jti string) (bool, error) {
+		var revoked map[string]bool
+		revoked[jti] = true // a nil map write
+		return true, nil
+	})
+	token := tokentest.ValidToken("alice", greeter)
+
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusInternalServerError, "internal_error")
+
+	// the service is still up
+	Revocations = checkerFunc(func(string) (bool, error) { return false, nil })
+	expectOK(t, get(h, "/api/v1/hello", token))
+}
+
+func TestHelloRequiresScope(t *testing.T) {
+	h := newTestServer(t)
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", tokentest.WithScope("profile greet:read"))))
+	rec := get(h, "/api/v1/hello", tokentest.ValidToken("alice", tokentest.WithScope("profile")))
+	expectError(t, rec, http.StatusForbidden, "insufficient_scope")
+	if got := rec.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="greet:read"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+	// tokens from before scopes have none
+	expectError(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice")), http.StatusForbidden, "insufficient_scope")
+}
+
+func TestAudience(t *testing.T) {
+	forA := tokentest.ValidToken("alice", greeter, tokentest.WithAudience("service-a"))
+	forB := tokentest.ValidToken("alice", greeter, tokentest.WithAudience("service-b"))
+	forNone := tokentest.ValidToken("alice", greeter)
+
+	a := newTestServer(t, "-jwt-audience", "service-a")
+	expectOK(t, get(a, "/api/v1/hello", forA))
+	expectError(t, get(a, "/api/v1/hello", forB), http.StatusUnauthorized, "wrong_audience")
+	expectError(t, get(a, "/api/v1/hello", forNone), http.StatusUnauthorized, "wrong_audience")
+
+	b := newTestServer(t, "-jwt-audience", "service-b")
+	expectOK(t, get(b, "/api/v1/hello", forB))
+	expectError(t, get(b, "/api/v1/hello", forA), http.StatusUnauthorized, "wrong_audience")
+
+	// a service without an audience takes no token minted for another
+	none := newTestServer(t)
+	expectOK(t, get(none, "/api/v1/hello", forNone))
+	expectError(t, get(none, "/api/v1/hello", forA), http.StatusUnauthorized, "wrong_audience")
+}
+
+func TestMultipleAudiences(t *testing.T) {
+	both := tokentest.ValidToken("alice", greeter, tokentest.WithAudience("service-a", "service-b"))
+	for _, service := range []string{"service-a", "service-b"} {
+		expectOK(t, get(newTestServer(t, "-jwt-audience", service), "/api/v1/hello", both))
+	}
+	expectError(t, get(newTestServer(t, "-jwt-audience", "service-c"), "/api/v1/hello", both), http.StatusUnauthorized, "wrong_audience")
+
+	// aud as a plain string, as older issuers send it
+	claims := jwt.MapClaims{"sub": "alice", "jti": "1", "ver": 1, "scope": "greet:read", "aud": "service-a",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()}
+	single, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tokentest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOK(t, get(newTestServer(t, "-jwt-audience", "service-a"), "/api/v1/hello", single))
+	expectError(t, get(newTestServer(t, "-jwt-audience", "service-c"), "/api/v1/hello", single), http.StatusUnauthorized, "wrong_audience")
+}
+
+func TestLegacyClaims(t *testing.T) {
+	current := tokentest.ValidToken("alice", greeter)
+	legacy := tokentest.ValidToken("alice", greeter, tokentest.WithClaimsVersion(0))
+
+	h := newTestServer(t, "-legacy-claims-until", time.Now().Add(time.Hour).Format(time.RFC3339))
+	logs := captureLog(t)
+	expectOK(t, get(h, "/api/v1/hello", current))
+	if strings.Contains(logs.String(), "legacy claims") {
+		t.Errorf("a current token was logged as legacy: %s", logs)
+	}
+	expectOK(t, get(h, "/api/v1/hello", legacy))
+	if !strings.Contains(logs.String(), "legacy claims: version 0 token for alice") {
+		t.Errorf("the legacy token was not logged: %s", logs)
+	}
+
+	for name, args := range map[string][]string{
+		"closed window": {"-legacy-claims-until", time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		"no window":     nil,
+	} {
+		h := newTestServer(t, args...)
+		expectOK(t, get(h, "/api/v1/hello", current))
+		if rec := get(h, "/api/v1/hello", legacy); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"legacy_claims"`) {
+			t.Errorf("%s: legacy token: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestMaxTokenAge(t *testing.T) {
+	issued := func(ago time.Duration) string {
+		return tokentest.ValidToken("alice", greeter, tokentest.WithIssuedAt(time.Now().Add(-ago)))
+	}
+	h := newTestServer(t, "-max-token-age", "1h", "-jwt-leeway", "30s")
+
+	expectOK(t, get(h, "/api/v1/hello", issued(time.Minute)))
+	expectError(t, get(h, "/api/v1/hello", issued(24*time.Hour)), http.StatusUnauthorized, "token_too_old")
+	expectError(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithIssuedAt(time.Time{}))), http.StatusUnauthorized, "token_too_old")
+	// the leeway stretches the cap as it does exp
+	expectOK(t, get(h, "/api/v1/hello", issued(time.Hour+10*time.Second)))
+	expectError(t, get(h, "/api/v1/hello", issued(time.Hour+time.Minute)), http.StatusUnauthorized, "token_too_old")
+	// and an expired token is reported as expired, whatever its age
+	expectError(t, get(h, "/api/v1/hello", tokentest.ExpiredToken("alice", greeter, tokentest.WithIssuedAt(time.Now().Add(-24*time.Hour)))), http.StatusUnauthorized, "token_expired")
+
+	h = newTestServer(t)
+	expectOK(t, get(h, "/api/v1/hello", issued(24*time.Hour)))
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithIssuedAt(time.Time{}))))
+}
+
+func TestFutureIssuedAt(t *testing.T) {
+	issued := func(in time.Duration) string {
+		return tokentest.ValidToken("alice", greeter, tokentest.WithIssuedAt(time.Now().Add(in)))
+	}
+	h := newTestServer(t, "-jwt-leeway", "30s")
+	expectError(t, get(h, "/api/v1/hello", issued(24*time.Hour)), http.StatusUnauthorized, "token_used_before_issued")
+	expectOK(t, get(h, "/api/v1/hello", issued(10*time.Second)))
+}
+
+func TestInvalidClaims(t *testing.T) {
+	h := newTestServer(t)
+
+	noSub := tokentest.Claims("alice", greeter)
+	noSub.Subject = ""
+	token, err := tokentest.Keys().Sign(noSub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusUnauthorized, "invalid_claims")
+
+	stringExp := jwt.MapClaims{"sub": "alice", "jti": "1", "ver": 1, "scope": "greet:read", "iat": time.Now().Unix(), "exp": "tomorrow"}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, stringExp).SignedString(tokentest.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusUnauthorized, "invalid_claims")
+}
+
+func TestEncryptedTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwe.key")
+	if err := os.WriteFile(path, []byte(strings.Repeat("ab", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := auth.LoadTokenEncryption(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := tokentest.ValidToken("alice", greeter)
+	encrypted, err := enc.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newTestServer(t)
+	Encryption = enc
+	expectOK(t, get(h, "/api/v1/hello", encrypted))
+	expectOK(t, get(h, "/api/v1/hello", plain))
+
+	Encryption = nil
+	expectOK(t, get(h, "/api/v1/hello", plain))
+	expectError(t, get(h, "/api/v1/hello", encrypted), http.StatusUnauthorized, "token_invalid")
+
+	if err := os.WriteFile(path, []byte(strings.Repeat("cd", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if Encryption, err = auth.LoadTokenEncryption(path); err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, get(h, "/api/v1/hello", encrypted), http.StatusUnauthorized, "token_invalid")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// RevocationChecker reports whether a token was revoked before it expired.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// userServiceRevocations asks the user service, which owns the sessions.
+type userServiceRevocations struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewUserServiceRevocations asks the user service at baseURL through
+// client, which must authenticate to its internal routes (auth.ServiceClient).
+func NewUserServiceRevocations(baseURL string, client *http.Client) RevocationChecker {
+	return &userServiceRevocations{baseURL: baseURL, client: client}
+}
+
+func (c *userServiceRevocations) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	// tokens without a jti predate session tracking and cannot be revoked
+	if jti == "" {
+		return false, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/internal/revoked/"+url.PathEscape(jti), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("user service returned %s", resp.Status)
+	}
+
+	var body struct {
+		Revoked bool `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	return body.Revoked, nil
+}
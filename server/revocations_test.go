@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/tokentest"
+)
+
+func TestRevokedTokenRefused(t *testing.T) {
+	revoked := tokentest.Claims("alice", greeter)
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jti := strings.TrimPrefix(r.URL.Path, "/api/v1/internal/revoked/")
+		json.NewEncoder(w).Encode(map[string]bool{"revoked": jti == revoked.ID})
+	}))
+	defer users.Close()
+
+	h := newTestServer(t)
+	Revocations = NewUserServiceRevocations(users.URL, users.Client())
+
+	token, err := tokentest.Keys().Sign(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusUnauthorized, "token_revoked")
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+}
+
+func TestRevocationsUnavailable(t *testing.T) {
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer users.Close()
+	token := tokentest.ValidToken("alice", greeter)
+
+	h := newTestServer(t)
+	Revocations = NewUserServiceRevocations(users.URL, users.Client())
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusServiceUnavailable, "unavailable")
+
+	h = newTestServer(t, "-revocation-fail-open")
+	Revocations = NewUserServiceRevocations(users.URL, users.Client())
+	expectOK(t, get(h, "/api/v1/hello", token))
+}
+
+func TestSlowRevocationStore(t *testing.T) {
+	h := newTestServer(t, "-request-timeout", "50ms")
+	// a store far slower than the deadline
+	Revocations = checkerFunc(func(string) (bool, error) {
+		time.Sleep(time.Second)
+		return false, nil
+	})
+
+	start := time.Now()
+	rec := get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter))
+	expectError(t, rec, http.StatusServiceUnavailable, "timeout")
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("answered after %v, not at the deadline", elapsed)
+	}
+}
+
+func TestVerifyCacheStillChecksRevocations(t *testing.T) {
+	h := newTestServer(t, "-verify-cache-ttl", "1m")
+	if Verified == nil {
+		t.Fatal("-verify-cache-ttl did not turn the cache on")
+	}
+	claims := tokentest.Claims("alice", greeter)
+	token, err := tokentest.Keys().Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOK(t, get(h, "/api/v1/hello", token))
+
+	// revoked after it was cached
+	Revocations = checkerFunc(func(jti string) (bool, error) { return jti == claims.ID, nil })
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusUnauthorized, "token_revoked")
+}
+
+func TestVerifyCacheExpiredToken(t *testing.T) {
+	h := newTestServer(t, "-verify-cache-ttl", "1m")
+	claims := tokentest.Claims("alice", greeter, tokentest.WithTTL(time.Second))
+	token, err := tokentest.Keys().Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOK(t, get(h, "/api/v1/hello", token))
+	time.Sleep(time.Until(claims.ExpiresAt.Time) + 10*time.Millisecond)
+	expectError(t, get(h, "/api/v1/hello", token), http.StatusUnauthorized, "token_expired")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
+)
+
+// newRouter mounts the API under /api/v1. With legacy routes on, the same
+// routes are also served at their old unversioned paths, flagged as
+// deprecated. Debug endpoints only exist in dev mode. A known path requested
+// with the wrong method gets 405.
+func newRouter(cfg Config) http.Handler {
+	r := mux.NewRouter()
+	// mux reports some method mismatches as not found, so both get checked
+	r.NotFoundHandler = httpx.MethodNotAllowed(func(req *http.Request) bool {
+		var m mux.RouteMatch
+		return r.Match(req, &m) && m.MatchErr == nil
+	}, http.HandlerFunc(httpx.NotFound))
+	r.MethodNotAllowedHandler = r.NotFoundHandler
+	r.HandleFunc("/readyz", HandleReady).Methods("GET")
+	registerV1(r.PathPrefix("/api/v1").Subrouter())
+
+	if cfg.Dev {
+		r.Handle("/debug/decode", auth.DecodeHandler(Keys)).Methods("POST")
+	}
+
+	if cfg.LegacyRoutes {
+		old := r.NewRoute().Subrouter()
+		old.Use(httpx.Deprecated("/api/v1"))
+		registerV1(old)
+	}
+	return httpx.Standard(httpx.SecurityHeaders(cfg.HSTSMaxAge)(httpx.Timeout(cfg.RequestTimeout)(r)))
+}
+
+func registerV1(r *mux.Router) {
+	r.Handle("/ws", wsAuthMiddleware(http.HandlerFunc(HandleWebSocket))).Methods("GET")
+
+	authed := r.NewRoute().Subrouter()
+	authed.Use(jwtAuthMiddleware)
+	authed.Handle("/hello", auth.RequireScope("greet:read")(http.HandlerFunc(HandleGreet))).Methods("GET")
+}
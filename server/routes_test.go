@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auth/httpx"
+	"auth/tokentest"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	token := tokentest.ValidToken("alice", greeter)
+
+	h := newTestServer(t)
+	rec := get(h, "/api/v1/hello", token)
+	expectOK(t, rec)
+	if d := rec.Header().Get("Deprecation"); d != "" {
+		t.Errorf("the versioned route is deprecated: %q", d)
+	}
+
+	rec = get(h, "/hello", token)
+	expectOK(t, rec)
+	if d := rec.Header().Get("Deprecation"); d != "true" {
+		t.Errorf("Deprecation = %q on the legacy route, want true", d)
+	}
+	if link := rec.Header().Get("Link"); link != `</api/v1/hello>; rel="successor-version"` {
+		t.Errorf("Link = %q", link)
+	}
+
+	h = newTestServer(t, "-legacy-routes=false")
+	expectOK(t, get(h, "/api/v1/hello", token))
+	expectError(t, get(h, "/hello", token), http.StatusNotFound, "not_found")
+}
+
+func TestDebugDecodeOnlyInDev(t *testing.T) {
+	post := func(h http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/debug/decode", strings.NewReader(`{"token":"`+tokentest.ValidToken("alice")+`"}`))
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(newTestServer(t)); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"signature_valid":true`) {
+		t.Errorf("in dev mode: %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, post(newTestServer(t, "-dev=false")), http.StatusNotFound, "not_found")
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h := newTestServer(t)
+	for _, path := range []string{"/api/v1/hello", "/hello"} {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer "+tokentest.ValidToken("alice", greeter))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		expectError(t, rec, http.StatusMethodNotAllowed, "method_not_allowed")
+		if allow := rec.Header().Get("Allow"); allow != "GET" {
+			t.Errorf("Allow = %q on %s, want GET", allow, path)
+		}
+	}
+	expectError(t, get(h, "/api/v1/nope", ""), http.StatusNotFound, "not_found")
+}
+
+// captureLog collects what the standard logger writes during the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestUnmatchedRequestsLogged(t *testing.T) {
+	h := newTestServer(t)
+	logs := captureLog(t)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		code, allow  string
+	}{
+		{"POST", "/api/v1/hello", http.StatusMethodNotAllowed, "method_not_allowed", "GET"},
+		{"GET", "/api/v1/nope", http.StatusNotFound, "not_found", ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		expectError(t, rec, tt.status, tt.code)
+		if allow := rec.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+		id := rec.Header().Get(httpx.RequestIDHeader)
+		if id == "" {
+			t.Errorf("%s %s: no request ID", tt.method, tt.path)
+		}
+		if want := fmt.Sprintf("request_id=%s %s %s %d", id, tt.method, tt.path, tt.status); !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs)
+		}
+	}
+}
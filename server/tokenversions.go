@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// TokenVersionChecker returns a user's current token version; tokens
+// carrying an older one were issued before a password change. Users that do
+// not exist have a negative version, which no token carries.
+type TokenVersionChecker interface {
+	TokenVersion(ctx context.Context, subject string) (int, error)
+}
+
+// userServiceTokenVersions asks the user service, which owns the users.
+type userServiceTokenVersions struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewUserServiceTokenVersions(baseURL string, client *http.Client) TokenVersionChecker {
+	return &userServiceTokenVersions{baseURL: baseURL, client: client}
+}
+
+func (c *userServiceTokenVersions) TokenVersion(ctx context.Context, subject string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/internal/users/"+url.PathEscape(subject)+"/token-version", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("user service returned %s", resp.Status)
+	}
+
+	var body struct {
+		TokenVersion int `json:"token_version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.TokenVersion, nil
+}
+
+// CachedTokenVersions remembers answers for ttl
+// so that not every request costs a lookup. A password change therefore
+// takes up to ttl to reach this service.
+type CachedTokenVersions struct {
+	next TokenVersionChecker
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedVersion
+}
+
+type cachedVersion struct {
+	version int
+	expires time.Time
+}
+
+func NewCachedTokenVersions(next TokenVersionChecker, ttl time.Duration) *CachedTokenVersions {
+	return &CachedTokenVersions{next: next, ttl: ttl, entries: make(map[string]cachedVersion)}
+}
+
+func (c *CachedTokenVersions) TokenVersion(ctx context.Context, subject string) (int, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[subject]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.version, nil
+	}
+
+	version, err := c.next.TokenVersion(ctx, subject)
+	if err != nil {
+		return 0, err // not cached: the next request tries again
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for s, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, s)
+		}
+	}
+	c.entries[subject] = cachedVersion{version: version, expires: now.Add(c.ttl)}
+	return version, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth/tokentest"
+)
+
+// versionsFunc adapts a function to TokenVersionChecker.
+type versionsFunc func(subject string) (int, error)
+
+func (f versionsFunc) TokenVersion(_ context.Context, subject string) (int, error) {
+	return f(subject)
+}
+
+func TestStaleTokenRefused(t *testing.T) {
+	h := newTestServer(t)
+	TokenVersions = versionsFunc(func(string) (int, error) { return 1, nil })
+
+	rec := get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter))
+	expectError(t, rec, http.StatusUnauthorized, "token_stale")
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("no WWW-Authenticate on a stale token")
+	}
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter, tokentest.WithTokenVersion(1))))
+}
+
+func TestTokenVersionsPreField(t *testing.T) {
+	// users from before token versions are at 0, as are tokens without the claim
+	h := newTestServer(t)
+	TokenVersions = versionsFunc(func(string) (int, error) { return 0, nil })
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+}
+
+func TestTokenVersionsUnavailable(t *testing.T) {
+	down := versionsFunc(func(string) (int, error) { return 0, errors.New("connection refused") })
+	h := newTestServer(t)
+	TokenVersions = down
+	expectError(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)), http.StatusServiceUnavailable, "unavailable")
+
+	h = newTestServer(t, "-revocation-fail-open")
+	TokenVersions = down
+	expectOK(t, get(h, "/api/v1/hello", tokentest.ValidToken("alice", greeter)))
+}
+
+func TestCachedTokenVersions(t *testing.T) {
+	ctx := context.Background()
+	version, lookups := 0, 0
+	var fail error
+	cache := NewCachedTokenVersions(versionsFunc(func(string) (int, error) {
+		lookups++
+		return version, fail
+	}), 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if v, err := cache.TokenVersion(ctx, "alice"); err != nil || v != 0 {
+			t.Fatalf("TokenVersion = %d, %v", v, err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups for three checks within the TTL, want 1", lookups)
+	}
+
+	// a password change shows once the entry expires
+	version = 1
+	if v, _ := cache.TokenVersion(ctx, "alice"); v != 0 {
+		t.Errorf("the cached version changed within the TTL: %d", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if v, _ := cache.TokenVersion(ctx, "alice"); v != 1 || lookups != 2 {
+		t.Errorf("after the TTL: version %d after %d lookups, want 1 after 2", v, lookups)
+	}
+
+	// failures are not remembered
+	time.Sleep(60 * time.Millisecond)
+	fail = errors.New("down")
+	if _, err := cache.TokenVersion(ctx, "alice"); err == nil {
+		t.Fatal("a failed lookup succeeded")
+	}
+	fail = nil
+	if _, err := cache.TokenVersion(ctx, "alice"); err != nil || lookups != 4 {
+		t.Errorf("after a failure: %v after %d lookups, want a fresh lookup", err, lookups)
+	}
+}
+
+func TestUserServiceTokenVersions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v1/internal/users/John%20Doe/token-version" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"token_version": 3}`)
+	}))
+	defer srv.Close()
+
+	versions := NewUserServiceTokenVersions(srv.URL, srv.Client())
+	if v, err := versions.TokenVersion(context.Background(), "John Doe"); err != nil || v != 3 {
+		t.Errorf("TokenVersion = %d, %v; want 3", v, err)
+	}
+	if _, err := versions.TokenVersion(context.Background(), "nobody"); err == nil {
+		t.Error("a 404 from the user service gave a version")
+	}
+}
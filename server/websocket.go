@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsProtocol is the subprotocol browsers offer to pass the token in: they
+// cannot set headers on the handshake, so they send
+// Sec-WebSocket-Protocol: access_token, <token>.
+const wsProtocol = "access_token"
+
+// upgrader only takes same-origin handshakes, as a cookie may be what
+// authenticates them.
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{wsProtocol},
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		code := httpx.CodeInvalidRequest
+		if status == http.StatusForbidden {
+			code = httpx.CodeForbidden
+		}
+		httpx.WriteError(w, r, status, code, reason.Error())
+	},
+}
+
+// wsAuthMiddleware runs jwtAuthMiddleware on WebSocket handshakes, also
+// taking the token from the subprotocol or an access_token query parameter.
+// A browser cannot read the status of a refused handshake, so a refused
+// one is upgraded anyway and closed with 4000 + the status (4401 for an
+// invalid token, 4403 for a forbidden one) and the error code as reason.
+func wsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := wsToken(r); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if websocket.IsWebSocketUpgrade(r) {
+			// the close reason is taken from the JSON error
+			r = r.Clone(r.Context())
+			r.Header.Set("Accept", "application/json")
+		}
+
+		rec := &rejection{header: make(http.Header)}
+		passed := false
+		jwtAuthMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			passed = true
+			next.ServeHTTP(w, r)
+		})).ServeHTTP(rec, r)
+		if !passed {
+			rec.send(w, r)
+		}
+	})
+}
+
+// wsToken returns the token offered in the subprotocol list, else the one
+// in the query.
+func wsToken(r *http.Request) string {
+	protocols := websocket.Subprotocols(r)
+	for i, p := range protocols {
+		if p == wsProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// rejection records the error response jwtAuthMiddleware refused a
+// handshake with.
+type rejection struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (rec *rejection) Header() http.Header { return rec.header }
+
+func (rec *rejection) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *rejection) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	rec.body = append(rec.body, b...)
+	return len(b), nil
+}
+
+// send delivers the rejection as a close frame, or as it is to clients that
+// did not ask for a WebSocket.
+func (rec *rejection) send(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body)
+		return
+	}
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rec.body, &body)
+	closeCode := websocket.CloseInternalServerErr
+	if rec.status >= 400 && rec.status < 500 {
+		closeCode = 4000 + rec.status
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err) // the upgrader has answered
+		return
+	}
+	defer conn.Close()
+	closeWith(conn, closeCode, body.Error.Code)
+}
+
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
+// HandleWebSocket echoes every message back prefixed with the caller's
+// name, until the caller hangs up or the token expires.
+func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err) // the upgrader has answered
+		return
+	}
+	defer conn.Close()
+
+	if claims.ExpiresAt != nil {
+		expiry := time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+			closeWith(conn, 4401, string(httpx.CodeTokenExpired))
+			conn.Close() // ends the read loop below
+		})
+		defer expiry.Stop()
+	}
+
+	for {
+		kind, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(kind, []byte(claims.Subject+": "+string(msg))); err != nil {
+			return
+		}
+	}
+}
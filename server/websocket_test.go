@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"auth/tokentest"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWS opens a WebSocket to the test server's /api/v1/ws with query and
+// header added to the handshake.
+func dialWS(t *testing.T, srv *httptest.Server, query url.Values, header http.Header, protocols ...string) *websocket.Conn {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/ws"
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	dialer := websocket.Dialer{Subprotocols: protocols, HandshakeTimeout: time.Second}
+	conn, resp, err := dialer.Dial(u, header)
+	if err != nil {
+		t.Fatalf("dialing: %v (%v)", err, resp)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expectClose fails the test unless the server closes conn with code and
+// reason.
+func expectClose(t *testing.T, conn *websocket.Conn, code int, reason string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("got %q, %v; want a close", msg, err)
+	}
+	if closeErr.Code != code || closeErr.Text != reason {
+		t.Errorf("closed with %d %q, want %d %q", closeErr.Code, closeErr.Text, code, reason)
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := httptest.NewServer(newTestServer(t))
+	defer srv.Close()
+	token := tokentest.ValidToken("alice")
+
+	for name, conn := range map[string]*websocket.Conn{
+		"header":      dialWS(t, srv, nil, http.Header{"Authorization": {"Bearer " + token}}),
+		"subprotocol": dialWS(t, srv, nil, nil, wsProtocol, token),
+		"query":       dialWS(t, srv, url.Values{"access_token": {token}}, nil),
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil || string(msg) != "alice: hi" {
+			t.Errorf("%s: got %q, %v; want alice: hi", name, msg, err)
+		}
+	}
+}
+
+func TestWebSocketSubprotocolChosen(t *testing.T) {
+	srv := httptest.NewServer(newTestServer(t))
+	defer srv.Close()
+	conn := dialWS(t, srv, nil, nil, wsProtocol, tokentest.ValidToken("alice"))
+	if p := conn.Subprotocol(); p != wsProtocol {
+		t.Errorf("subprotocol = %q, want %q", p, wsProtocol)
+	}
+}
+
+func TestWebSocketInvalidToken(t *testing.T) {
+	srv := httptest.NewServer(newTestServer(t))
+	defer srv.Close()
+
+	expectClose(t, dialWS(t, srv, nil, nil, wsProtocol, tokentest.WrongKeyToken("alice")), 4401, "token_invalid")
+	expectClose(t, dialWS(t, srv, url.Values{"access_token": {tokentest.ExpiredToken("alice")}}, nil), 4401, "token_expired")
+	expectClose(t, dialWS(t, srv, nil, nil), 4401, "missing_token")
+}
+
+func TestWebSocketClosedAtExpiry(t *testing.T) {
+	srv := httptest.NewServer(newTestServer(t))
+	defer srv.Close()
+	claims := tokentest.Claims("alice", tokentest.WithTTL(time.Second))
+	token, err := tokentest.Keys().Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialWS(t, srv, url.Values{"access_token": {token}}, nil)
+	expectClose(t, conn, 4401, "token_expired")
+	if early := time.Until(claims.ExpiresAt.Time); early > 0 {
+		t.Errorf("closed %v before the token expired", early)
+	}
+}
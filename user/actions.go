@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// ActionTokenTTL is how long action tokens stay valid, independently of
+// TokenTTL: they end up in mails and links.
+var ActionTokenTTL = 15 * time.Minute
+
+// ActionPasswordReset is the action of password reset links.
+const ActionPasswordReset = "password_reset"
+
+// actions are the actions tokens can be minted for.
+var actions = map[string]bool{ActionPasswordReset: true}
+
+// issueActionToken signs a single-use token letting its holder do action
+// for user. It carries the user's token version, so changing the password
+// also kills the links sent before.
+func issueActionToken(user User, action string) (string, error) {
+	now := time.Now()
+	return Keys.Sign(auth.Claims{
+		Purpose:      auth.ActionPurpose,
+		Action:       action,
+		TokenVersion: user.TokenVersion,
+		Version:      auth.ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ActionTokenTTL)),
+		},
+	})
+}
+
+// HandleMintActionToken lets admins issue an action token for a user, e.g.
+// to send a password reset link.
+func HandleMintActionToken(w http.ResponseWriter, r *http.Request) {
+	var req ActionTokenRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if !actions[req.Action] {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "unknown action")
+		return
+	}
+
+	user, err := Users.FindByUsername(r.Context(), mux.Vars(r)["username"])
+	if errors.Is(err, ErrUserNotFound) {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeUserNotFound, "")
+		return
+	}
+	var signed string
+	if err == nil {
+		signed, err = issueActionToken(user, req.Action)
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ActionTokenResponse{
+		Token:     signed,
+		Action:    req.Action,
+		ExpiresIn: int(ActionTokenTTL.Seconds()),
+	})
+}
+
+// HandleActionPasswordReset sets the password of the user a password_reset
+// token was issued for. It runs behind auth.RequireAction and spends the
+// token only once the new password is known to be acceptable, so a refused
+// attempt can be retried with the same link.
+func HandleActionPasswordReset(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	var req PasswordResetRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if req.NewPassword == "" {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "new_password is required")
+		return
+	}
+	if weakPassword(w, r, req.NewPassword) {
+		return
+	}
+
+	user, err := Users.FindByUsername(r.Context(), claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeUserNotFound, "")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenStale, "the password was changed since this token was issued")
+		return
+	}
+
+	if !auth.ConsumeAction(w, r) {
+		return
+	}
+	if setPassword(w, r, claims.Subject, req.NewPassword) {
+		audit(r, AuditEvent{Type: AuditPasswordSet, Subject: claims.Subject})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auth"
+)
+
+func mintActionToken(t *testing.T, h http.Handler, username, action string) ActionTokenResponse {
+	t.Helper()
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/users/"+username+"/action-tokens", ActionTokenRequest{Action: action}), admin))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("minting a %s token: %d %s", action, rec.Code, rec.Body)
+	}
+	var resp ActionTokenResponse
+	decode(t, rec, &resp)
+	return resp
+}
+
+func TestActionTokenLifetime(t *testing.T) {
+	h := newTestService(t)
+	resp := mintActionToken(t, h, "admin", ActionPasswordReset)
+	if resp.ExpiresIn != 15*60 {
+		t.Errorf("expires_in = %d, want 15 minutes", resp.ExpiresIn)
+	}
+	claims, err := auth.ParsePurposeToken(resp.Token, Keys, auth.ActionPurpose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); claims.Action != ActionPasswordReset || ttl != ActionTokenTTL {
+		t.Errorf("action %q valid for %v", claims.Action, ttl)
+	}
+	// the access token TTL does not apply
+	h = newTestService(t, "-token-ttl", "1h")
+	if resp := mintActionToken(t, h, "admin", ActionPasswordReset); resp.ExpiresIn != 15*60 {
+		t.Errorf("expires_in = %d with a 1h access token TTL", resp.ExpiresIn)
+	}
+}
+
+func TestActionTokenOnce(t *testing.T) {
+	h := newTestService(t)
+	token := mintActionToken(t, h, "admin", ActionPasswordReset).Token
+	reset := func() int {
+		return serve(h, newRequest("POST", "/api/v1/password/reset?token="+token, PasswordResetRequest{NewPassword: newPassword})).Code
+	}
+
+	if code := reset(); code != http.StatusNoContent {
+		t.Fatalf("first reset: %d", code)
+	}
+	logIn(t, h, "admin", newPassword)
+	rec := serve(h, newRequest("POST", "/api/v1/password/reset?token="+token, PasswordResetRequest{NewPassword: "another strong passphrase"}))
+	expectError(t, rec, http.StatusUnauthorized, "token_revoked")
+	logIn(t, h, "admin", newPassword)
+}
+
+func TestActionTokenSurvivesRefusedReset(t *testing.T) {
+	h := newTestService(t)
+	token := mintActionToken(t, h, "admin", ActionPasswordReset).Token
+	reset := func(body any) *httptest.ResponseRecorder {
+		return serve(h, newRequest("POST", "/api/v1/password/reset?token="+token, body))
+	}
+
+	expectError(t, reset(PasswordResetRequest{NewPassword: "short"}), http.StatusUnprocessableEntity, "weak_password")
+	expectError(t, reset(PasswordResetRequest{NewPassword: strings.Repeat("x", MaxPasswordBytes+1)}), http.StatusBadRequest, "password_too_long")
+	expectError(t, reset(PasswordResetRequest{}), http.StatusBadRequest, "invalid_request")
+	expectError(t, reset(`{"new_password": `), http.StatusBadRequest, "invalid_request")
+	logIn(t, h, "admin", "admin")
+
+	if rec := reset(PasswordResetRequest{NewPassword: newPassword}); rec.Code != http.StatusNoContent {
+		t.Fatalf("reset after the refused ones: %d %s", rec.Code, rec.Body)
+	}
+	logIn(t, h, "admin", newPassword)
+	expectError(t, reset(PasswordResetRequest{NewPassword: "another strong passphrase"}), http.StatusUnauthorized, "token_revoked")
+	logIn(t, h, "admin", newPassword)
+}
+
+func TestActionTokenElsewhere(t *testing.T) {
+	h := newTestService(t)
+	token := mintActionToken(t, h, "admin", ActionPasswordReset).Token
+
+	// not an access token
+	expectError(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)), http.StatusUnauthorized, "token_invalid")
+	// and access tokens are no action tokens
+	access := logIn(t, h, "admin", "admin").AccessToken
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/password/reset", PasswordResetRequest{NewPassword: newPassword}), access))
+	expectError(t, rec, http.StatusUnauthorized, "token_invalid")
+
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	rec = serve(h, withToken(newRequest("POST", "/api/v1/admin/users/admin/action-tokens", ActionTokenRequest{Action: "launch_missiles"}), admin))
+	expectError(t, rec, http.StatusBadRequest, "invalid_request")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is a static credential for machine clients, bound to a subject and
+// scopes. Only a hash of the key is kept; the key itself is shown once, when
+// minted.
+type APIKey struct {
+	ID        string    `json:"id"`     // public part, shown in listings
+	Prefix    string    `json:"prefix"` // first characters of the key, to recognise it
+	Hash      string    `json:"-"`      // hex SHA-256 of the whole key
+	Subject   string    `json:"subject"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// APIKeyStore keeps minted API keys.
+type APIKeyStore interface {
+	Create(ctx context.Context, k APIKey) error
+	FindByHash(ctx context.Context, hash string) (APIKey, error)
+	List(ctx context.Context) []APIKey
+	Revoke(ctx context.Context, id string) error
+}
+
+// newAPIKey returns a fresh key "ak_<id>_<secret>" with 32 random bytes of
+// secret, and its record.
+func newAPIKey(subject string, scopes []string) (string, APIKey, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	k := APIKey{
+		ID:        hex.EncodeToString(id),
+		Subject:   subject,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	key := "ak_" + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	k.Prefix = "ak_" + k.ID
+	k.Hash = hashAPIKey(key)
+	return key, k, nil
+}
+
+// hashAPIKey needs no salt or slow hash: the key is 256 random bits, not a
+// password.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryAPIKeyStore is an APIKeyStore kept in memory.
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey // by ID
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]APIKey)}
+}
+
+func (s *MemoryAPIKeyStore) Create(ctx context.Context, k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return APIKey{}, ErrAPIKeyNotFound
+}
+
+// List returns all keys, revoked ones included, oldest first.
+func (s *MemoryAPIKeyStore) List(ctx context.Context) []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+func (s *MemoryAPIKeyStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	k.Revoked = true
+	s.keys[id] = k
+	return nil
+}
+
+type APIKeyRequest struct {
+	Subject string   `json:"subject"`
+	Scopes  []string `json:"scopes"`
+}
+
+// HandleCreateAPIKey mints an API key. The response is the only time the
+// key is ever shown.
+func HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKeyRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if req.Subject == "" {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "subject is required")
+		return
+	}
+
+	key, k, err := newAPIKey(req.Subject, req.Scopes)
+	if err == nil {
+		err = APIKeys.Create(r.Context(), k)
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Key string `json:"key"`
+		APIKey
+	}{key, k})
+}
+
+func HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIKeys.List(r.Context()))
+}
+
+func HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := APIKeys.Revoke(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeAPIKeyNotFound, "")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleResolveAPIKey lets the server service turn an X-API-Key into the
+// subject and scopes to authorize it as. Unknown and revoked keys are 404;
+// callers over ResolveLimiter get 429.
+func HandleResolveAPIKey(w http.ResponseWriter, r *http.Request) {
+	if ResolveLimiter != nil && !ResolveLimiter.Allow(r.Context(), "resolve:"+clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		httpx.WriteError(w, r, http.StatusTooManyRequests, httpx.CodeRateLimited, "too many api key lookups, try again later")
+		return
+	}
+	var req struct {
+		Key string `json:"key"`
+	}
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+
+	k, err := APIKeys.FindByHash(r.Context(), hashAPIKey(req.Key))
+	if err == nil && k.Revoked {
+		err = ErrAPIKeyNotFound
+	}
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeAPIKeyNotFound, "")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":      k.ID,
+		"subject": k.Subject,
+		"scope":   strings.Join(k.Scopes, " "),
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mintAPIKey has the admin mint a key for subject and returns it with its
+// id.
+func mintAPIKey(t *testing.T, h http.Handler, admin, subject string, scopes ...string) (key, id string) {
+	t.Helper()
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/apikeys", APIKeyRequest{Subject: subject, Scopes: scopes}), admin))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("minting: %d %s", rec.Code, rec.Body)
+	}
+	var minted struct {
+		Key string `json:"key"`
+		ID  string `json:"id"`
+	}
+	decode(t, rec, &minted)
+	return minted.Key, minted.ID
+}
+
+func resolveAPIKey(h http.Handler, key string) *httptest.ResponseRecorder {
+	return serve(h, newRequest("POST", "/api/v1/internal/apikeys/resolve", map[string]string{"key": key}))
+}
+
+func TestAPIKeys(t *testing.T) {
+	h := newTestService(t)
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	key, id := mintAPIKey(t, h, admin, "cron", "greet:read")
+
+	rec := resolveAPIKey(h, key)
+	var resolved map[string]string
+	decode(t, rec, &resolved)
+	if rec.Code != http.StatusOK || resolved["subject"] != "cron" || resolved["scope"] != "greet:read" || resolved["id"] != id {
+		t.Errorf("resolving a valid key: %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, resolveAPIKey(h, "ak_000000000000_nope"), http.StatusNotFound, "api_key_not_found")
+
+	// listings show the prefix only
+	rec = serve(h, withToken(newRequest("GET", "/api/v1/admin/apikeys", nil), admin))
+	if strings.Contains(rec.Body.String(), key) || !strings.Contains(rec.Body.String(), `"prefix":"ak_`+id+`"`) {
+		t.Errorf("listing: %s", rec.Body)
+	}
+
+	rec = serve(h, withToken(newRequest("DELETE", "/api/v1/admin/apikeys/"+id, nil), admin))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoking: %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, resolveAPIKey(h, key), http.StatusNotFound, "api_key_not_found")
+	rec = serve(h, withToken(newRequest("DELETE", "/api/v1/admin/apikeys/nope", nil), admin))
+	expectError(t, rec, http.StatusNotFound, "api_key_not_found")
+}
+
+func TestAPIKeysAdminOnly(t *testing.T) {
+	h := newTestService(t)
+	user := logIn(t, h, "John Doe", "password").AccessToken
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/apikeys", APIKeyRequest{Subject: "cron"}), user))
+	expectError(t, rec, http.StatusForbidden, "insufficient_role")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"auth/httpx"
+)
+
+const (
+	AuditLoginSuccess = "login_success"
+	AuditLoginFailure = "login_failure"
+	AuditLogout       = "logout"
+	AuditTokenRevoked = "token_revoked"
+	AuditKeyRotated   = "key_rotated"
+	AuditPasswordSet  = "password_changed"
+)
+
+// AuditEvent records who did what, from where and when.
+type AuditEvent struct {
+	Type      string    `json:"type"`
+	RequestID string    `json:"request_id,omitempty"`
+	Subject   string    `json:"subject"`
+	Actor     string    `json:"actor,omitempty"` // who acted, when not the subject
+	JTI       string    `json:"jti,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Time      time.Time `json:"time"`
+}
+
+// AuditLogger stores authentication events for compliance.
+type AuditLogger interface {
+	Record(e AuditEvent) error
+}
+
+// MemoryAuditLogger keeps events in memory.
+type MemoryAuditLogger struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *MemoryAuditLogger) Record(e AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	return nil
+}
+
+// Events returns a copy of everything recorded so far.
+func (l *MemoryAuditLogger) Events() []AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEvent(nil), l.events...)
+}
+
+// FileAuditLogger appends events to a file, one JSON object per line.
+type FileAuditLogger struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func NewFileAuditLogger(path string) (*FileAuditLogger, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditLogger{f: f}, nil
+}
+
+func (l *FileAuditLogger) Record(e AuditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+func (l *FileAuditLogger) Close() error {
+	return l.f.Close()
+}
+
+// audit fills in the request details of e and records it.
+func audit(r *http.Request, e AuditEvent) {
+	e.RequestID = httpx.RequestIDFrom(r.Context())
+	e.IP = clientIP(r)
+	e.UserAgent = r.UserAgent()
+	e.Time = time.Now().UTC()
+	if err := Audit.Record(e); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: writing audit event: ", err)
+	}
+}
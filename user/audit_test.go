@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// loginFrom attempts a login through a trusted proxy on behalf of ip.
+func loginFrom(h http.Handler, ip, password string) int {
+	req := newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: password})
+	req.Header.Set("X-Forwarded-For", ip)
+	req.Header.Set("User-Agent", "audit-test")
+	return serve(h, req).Code
+}
+
+func TestAuditLogin(t *testing.T) {
+	h := newTestService(t, "-trusted-proxies", "192.0.2.0/24") // httptest's RemoteAddr
+	audit := Audit.(*MemoryAuditLogger)
+
+	if code := loginFrom(h, "203.0.113.7", "password"); code != http.StatusOK {
+		t.Fatalf("login: %d", code)
+	}
+	if code := loginFrom(h, "203.0.113.8", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("failed login: %d", code)
+	}
+
+	events := audit.Events()
+	if len(events) != 2 {
+		t.Fatalf("got %d audit events, want 2: %+v", len(events), events)
+	}
+	for i, want := range []AuditEvent{
+		{Type: AuditLoginSuccess, Subject: "John Doe", IP: "203.0.113.7", UserAgent: "audit-test"},
+		{Type: AuditLoginFailure, Subject: "John Doe", IP: "203.0.113.8", UserAgent: "audit-test"},
+	} {
+		e := events[i]
+		if e.Type != want.Type || e.Subject != want.Subject || e.IP != want.IP || e.UserAgent != want.UserAgent || e.Time.IsZero() {
+			t.Errorf("event %d = %+v, want %+v", i, e, want)
+		}
+	}
+}
+
+func TestAuditIgnoresUntrustedForwardedFor(t *testing.T) {
+	h := newTestService(t)
+	loginFrom(h, "203.0.113.7", "password")
+	if e := Audit.(*MemoryAuditLogger).Events()[0]; e.IP != "192.0.2.1" {
+		t.Errorf("IP = %q, want the peer address", e.IP)
+	}
+}
+
+func TestAuditRequestID(t *testing.T) {
+	h := newTestService(t)
+	req := newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "wrong"})
+	req.Header.Set("X-Request-ID", "support-ticket-7")
+	serve(h, req)
+	if e := Audit.(*MemoryAuditLogger).Events()[0]; e.RequestID != "support-ticket-7" {
+		t.Errorf("request_id = %q, want the client's", e.RequestID)
+	}
+}
+
+func TestLoginRateLimitPerClient(t *testing.T) {
+	h := newTestService(t, "-trusted-proxies", "192.0.2.0/24")
+	LoginLimiter = openRateLimiter(nil, 2, time.Minute)
+	for i := 0; i < 2; i++ {
+		loginFrom(h, "198.51.100.1", "wrong")
+	}
+	if code := loginFrom(h, "198.51.100.1", "password"); code != http.StatusTooManyRequests {
+		t.Errorf("third login from the same client: %d, want 429", code)
+	}
+	if code := loginFrom(h, "198.51.100.2", "password"); code != http.StatusOK {
+		t.Errorf("login from another client behind the proxy: %d, want 200", code)
+	}
+
+	// without trusting the proxy, a made-up X-Forwarded-For gets nobody a fresh budget
+	h = newTestService(t)
+	LoginLimiter = openRateLimiter(nil, 2, time.Minute)
+	for i := 0; i < 2; i++ {
+		loginFrom(h, "198.51.100.1", "wrong")
+	}
+	if code := loginFrom(h, "198.51.100.3", "password"); code != http.StatusTooManyRequests {
+		t.Errorf("login with a spoofed X-Forwarded-For: %d, want 429", code)
+	}
+}
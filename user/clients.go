@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Client is a backend service that gets tokens with its own credentials
+// (the OAuth 2.0 client_credentials grant) instead of a user's.
+type Client struct {
+	ID         string   `json:"client_id"`
+	SecretHash string   `json:"secret_hash"` // bcrypt
+	Scopes     []string `json:"scopes"`
+}
+
+// ClientTokenTTL is how long client_credentials tokens stay valid. They are
+// short-lived since a client can always ask for a new one.
+var ClientTokenTTL = 15 * time.Minute
+
+var OurClient = Client{
+	ID:         "demo-service",
+	SecretHash: mustHashPassword("demo-secret"),
+	Scopes:     []string{"greet:read"},
+}
+
+// loadClients reads a JSON array of clients from path.
+func loadClients(path string) (map[string]Client, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Client
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	clients := make(map[string]Client, len(list))
+	for _, c := range list {
+		if c.ID == "" || c.SecretHash == "" {
+			return nil, fmt.Errorf("%s: client without client_id or secret_hash", path)
+		}
+		clients[c.ID] = c
+	}
+	return clients, nil
+}
+
+// grantScopes returns the requested space-delimited scopes, or all of the
+// client's scopes when none were requested. ok is false if the client may
+// not have one of them.
+func (c Client) grantScopes(requested string) (scope string, ok bool) {
+	if requested == "" {
+		return strings.Join(c.Scopes, " "), true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(c.Scopes, s) {
+			return "", false
+		}
+	}
+	return strings.Join(strings.Fields(requested), " "), true
+}
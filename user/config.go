@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"auth"
+	"auth/config"
+	"auth/httpx"
+)
+
+// Config is the user service's configuration. Each setting comes from a
+// flag, else its environment variable, else the default.
+type Config struct {
+	Addr               string
+	LogFormat          string
+	JWTAlg             string
+	JWTSecret          string
+	JWTSecretFile      string
+	JWTKeyFile         string
+	JWEKeyFile         string
+	KeyRetirement      time.Duration
+	TokenTTL           time.Duration
+	ClientsFile        string
+	Audiences          string
+	Audience           string
+	ClientTokenTTL     time.Duration
+	ActionTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	MaxSessionAge      time.Duration
+	MaxSessions        int
+	LegacyClaimsUntil  time.Time
+	SessionLimitPolicy string
+	PasswordMinLength  int
+	PasswordDigit      bool
+	PasswordUpper      bool
+	PasswordSymbol     bool
+	MaxBodySize        int64
+	CookieOnly         bool
+	RequestTimeout     time.Duration
+	TrustedProxies     string
+	InternalSecret     string
+	HSTSMaxAge         time.Duration
+	Dev                bool
+	LegacyRoutes       bool
+	EnforceScopes      bool
+	UserDB             string
+	RedisAddr          string
+	RevocationFailOpen bool
+	LoginRateLimit     int
+	ResolveRateLimit   int
+	AuditLogFile       string
+}
+
+func parseConfig(args []string, getenv func(string) string) (Config, *config.FlagSet, error) {
+	var c Config
+	fs := config.NewFlagSet("user", getenv)
+
+	defAddr := ":8080"
+	if port := getenv("PORT"); port != "" {
+		defAddr = ":" + port
+	}
+	fs.String(&c.Addr, "addr", "ADDR", defAddr, "listen address, defaults to :$PORT when PORT is set")
+	fs.String(&c.LogFormat, "log-format", "LOG_FORMAT", "text", "log format: text or json")
+	fs.String(&c.JWTAlg, "jwt-alg", "JWT_ALG", "HS256", "signing algorithm: "+strings.Join(auth.Algorithms(), ", "))
+	fs.String(&c.JWTSecret, "jwt-secret", "JWT_SECRET", "", "HS256 secret (defaults to the tutorial secret)")
+	fs.String(&c.JWTSecretFile, "jwt-secret-file", "JWT_SECRET_FILE", "", "file holding the HS256 secret, reloaded on SIGHUP")
+	fs.String(&c.JWTKeyFile, "jwt-key-file", "JWT_KEY_FILE", "", "private key file for RS256/EdDSA, reloaded on SIGHUP")
+	fs.Duration(&c.KeyRetirement, "key-retirement", "KEY_RETIREMENT", 24*time.Hour, "how long a rotated-out signing key still verifies (at least the token TTL)")
+	fs.String(&c.JWEKeyFile, "jwe-key-file", "JWE_KEY_FILE", "", "key to encrypt issued access tokens with (JWE); off when empty")
+	fs.Duration(&c.TokenTTL, "token-ttl", "TOKEN_TTL", 24*time.Hour, "lifetime of issued access tokens")
+	fs.String(&c.Audiences, "audiences", "AUDIENCES", "", "comma-separated audiences logins may ask tokens for")
+	fs.String(&c.Audience, "jwt-audience", "JWT_AUDIENCE", "", "this service's own audience: tokens with an aud not naming it are refused here")
+	fs.String(&c.ClientsFile, "clients-file", "CLIENTS_FILE", "", "JSON file of client_credentials clients (a demo client when empty)")
+	fs.Duration(&c.ClientTokenTTL, "client-token-ttl", "CLIENT_TOKEN_TTL", 15*time.Minute, "lifetime of client_credentials tokens")
+	fs.Duration(&c.ActionTokenTTL, "action-token-ttl", "ACTION_TOKEN_TTL", 15*time.Minute, "lifetime of single-use action tokens, e.g. password reset links")
+	fs.Duration(&c.RefreshTokenTTL, "refresh-token-ttl", "REFRESH_TOKEN_TTL", 7*24*time.Hour, "how long a refresh token may be exchanged, each refresh issuing a new one")
+	fs.Duration(&c.MaxSessionAge, "max-session-age", "MAX_SESSION_AGE", 0, "how long after the login refreshing stops working (0 = no cap)")
+	fs.Time(&c.LegacyClaimsUntil, "legacy-claims-until", "LEGACY_CLAIMS_UNTIL", time.Time{}, "accept tokens in the previous claims format (logged) until this RFC 3339 time; unset, they are refused")
+	fs.Int(&c.MaxSessions, "max-sessions", "MAX_SESSIONS", 0, "most logins a user may have active at once (0 = no cap)")
+	fs.String(&c.SessionLimitPolicy, "session-limit-policy", "SESSION_LIMIT_POLICY", SessionLimitEvict, "over MAX_SESSIONS: evict (end the oldest login) or reject (refuse with 429)")
+	fs.Int(&c.PasswordMinLength, "password-min-length", "PASSWORD_MIN_LENGTH", 8, "fewest characters a new password may have")
+	fs.Bool(&c.PasswordDigit, "password-require-digit", "PASSWORD_REQUIRE_DIGIT", false, "new passwords must contain a digit")
+	fs.Bool(&c.PasswordUpper, "password-require-upper", "PASSWORD_REQUIRE_UPPER", false, "new passwords must contain an upper-case letter")
+	fs.Bool(&c.PasswordSymbol, "password-require-symbol", "PASSWORD_REQUIRE_SYMBOL", false, "new passwords must contain a symbol or punctuation mark")
+	fs.Int64(&c.MaxBodySize, "max-body-size", "MAX_BODY_SIZE", 1<<20, "largest accepted request body in bytes")
+	fs.Bool(&c.CookieOnly, "cookie-only", "COOKIE_ONLY", false, "deliver tokens only in an HttpOnly cookie, never in the login body")
+	fs.String(&c.TrustedProxies, "trusted-proxies", "TRUSTED_PROXIES", "", "comma-separated CIDRs of proxies whose X-Forwarded-For is believed")
+	fs.String(&c.InternalSecret, "internal-secret", "INTERNAL_SECRET", "", "secret other services must send to call the /internal routes (required outside dev mode)")
+	fs.Duration(&c.RequestTimeout, "request-timeout", "REQUEST_TIMEOUT", 5*time.Second, "deadline for handling a request, after which it gets 503 (0 disables)")
+	fs.Duration(&c.HSTSMaxAge, "hsts-max-age", "HSTS_MAX_AGE", 365*24*time.Hour, "Strict-Transport-Security max-age sent over TLS (0 disables)")
+	fs.Bool(&c.Dev, "dev", "DEV", false, "development mode: accept plain HTTP and serve debug endpoints such as /debug/decode")
+	fs.Bool(&c.LegacyRoutes, "legacy-routes", "LEGACY_ROUTES", true, "also serve the unversioned routes")
+	fs.Bool(&c.EnforceScopes, "enforce-scopes", "ENFORCE_SCOPES", false, "check the scope claim on endpoints that need one")
+	fs.String(&c.UserDB, "user-db", "USER_DB", "", "SQLite database for users (in memory when empty)")
+	fs.String(&c.RedisAddr, "redis-addr", "REDIS_ADDR", "", "Redis server for revocations and rate limits (in memory when empty)")
+	fs.Bool(&c.RevocationFailOpen, "revocation-fail-open", "REVOCATION_FAIL_OPEN", false, "accept tokens when the revocation store is unreachable instead of answering 503")
+	fs.Int(&c.LoginRateLimit, "login-rate-limit", "LOGIN_RATE_LIMIT", 10, "login attempts allowed per client IP per minute (0 disables)")
+	fs.Int(&c.ResolveRateLimit, "resolve-rate-limit", "RESOLVE_RATE_LIMIT", 600, "API key lookups allowed per calling service IP per minute (0 disables)")
+	fs.String(&c.AuditLogFile, "audit-log-file", "AUDIT_LOG_FILE", "", "file audit events are appended to (in memory when empty)")
+
+	if err := fs.Parse(args); err != nil {
+		return c, fs, err
+	}
+	return c, fs, c.validate()
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Addr == "":
+		return errors.New("empty listen address")
+	case c.RequestTimeout < 0:
+		return errors.New("request timeout must not be negative")
+	case c.TokenTTL <= 0, c.ClientTokenTTL <= 0, c.ActionTokenTTL <= 0, c.RefreshTokenTTL <= 0:
+		return errors.New("token TTL must be positive")
+	case c.KeyRetirement < c.TokenTTL:
+		return errors.New("key retirement must be at least the token TTL, or rotated-out keys die before their tokens")
+	case c.MaxSessionAge < 0:
+		return errors.New("max session age must not be negative")
+	case c.MaxSessions < 0:
+		return errors.New("max sessions must not be negative")
+	case c.SessionLimitPolicy != SessionLimitEvict && c.SessionLimitPolicy != SessionLimitReject:
+		return fmt.Errorf("unknown session limit policy %q", c.SessionLimitPolicy)
+	case c.PasswordMinLength < 0:
+		return errors.New("password min length must not be negative")
+	case c.LoginRateLimit < 0:
+		return errors.New("login rate limit must not be negative")
+	case c.ResolveRateLimit < 0:
+		return errors.New("resolve rate limit must not be negative")
+	case c.MaxBodySize <= 0:
+		return errors.New("max body size must be positive")
+	case c.LogFormat != "text" && c.LogFormat != "json":
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	case c.JWTSecret != "" && c.JWTSecretFile != "":
+		return errors.New("set either a JWT secret or a secret file, not both")
+	case c.InternalSecret == "" && !c.Dev:
+		return errors.New("an internal secret is required outside dev mode")
+	case c.JWTAlg != "HS256" && c.JWTKeyFile == "":
+		return fmt.Errorf("%s needs a key file", c.JWTAlg)
+	}
+	_, err := httpx.ParseTrustedProxies(c.TrustedProxies)
+	return err
+}
+
+// audiences lists the audiences tokens may be issued for.
+func (c Config) audiences() []string {
+	var auds []string
+	for _, aud := range strings.Split(c.Audiences, ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			auds = append(auds, aud)
+		}
+	}
+	return auds
+}
+
+// String prints the configuration with the secret redacted.
+func (c Config) String() string {
+	c.JWTSecret = config.Redact(c.JWTSecret)
+	c.InternalSecret = config.Redact(c.InternalSecret)
+	type plain Config // drop the String method to avoid recursion
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+func loadConfig() Config {
+	c, fs, err := parseConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fs.Usage(os.Stdout, err)
+		os.Exit(0)
+	}
+	if err != nil {
+		fs.Usage(os.Stderr, err)
+		os.Exit(2)
+	}
+	return c
+}
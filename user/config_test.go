@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// env serves getenv lookups from vars.
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		addr string
+		ttl  time.Duration
+	}{
+		{"default", nil, nil, ":8080", 24 * time.Hour},
+		{"env", nil, map[string]string{"ADDR": ":9000", "TOKEN_TTL": "1h"}, ":9000", time.Hour},
+		{"PORT", nil, map[string]string{"PORT": "9001"}, ":9001", 24 * time.Hour},
+		{"ADDR over PORT", nil, map[string]string{"ADDR": ":9000", "PORT": "9001"}, ":9000", 24 * time.Hour},
+		{"flag", []string{"-addr", ":9002", "-token-ttl", "2h"}, map[string]string{"ADDR": ":9000", "TOKEN_TTL": "1h"}, ":9002", 2 * time.Hour},
+	} {
+		cfg, _, err := parseConfig(append([]string{"-dev"}, tc.args...), env(tc.env))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if cfg.Addr != tc.addr || cfg.TokenTTL != tc.ttl {
+			t.Errorf("%s: addr %q, TTL %v; want %q, %v", tc.name, cfg.Addr, cfg.TokenTTL, tc.addr, tc.ttl)
+		}
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"empty addr", []string{"-addr", ""}, nil},
+		{"bad TTL flag", []string{"-token-ttl", "soon"}, nil},
+		{"bad TTL env", nil, map[string]string{"TOKEN_TTL": "soon"}},
+		{"unknown flag", []string{"-nope"}, nil},
+	} {
+		if _, _, err := parseConfig(append([]string{"-dev"}, tc.args...), env(tc.env)); err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+}
+
+func TestConfigStringRedactsSecrets(t *testing.T) {
+	cfg, _, err := parseConfig([]string{"-dev", "-jwt-secret", "hunter2-hunter2"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cfg.String(); strings.Contains(s, "hunter2") {
+		t.Errorf("the secret is printed: %s", s)
+	}
+}
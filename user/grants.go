@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+
+	"auth/httpx"
+)
+
+// HandleToken is the OAuth 2.0 token endpoint. It takes a form-encoded body
+// and dispatches on grant_type. Errors come in the OAuth shape.
+func HandleToken(w http.ResponseWriter, r *http.Request) {
+	r = httpx.WithOAuthErrors(r)
+	if rateLimited(w, r) {
+		return
+	}
+	handleGrant(w, r, "client_credentials", "password")
+}
+
+// handleGrant parses a form-encoded token request and serves it if its
+// grant_type is one of grants. r must be marked with httpx.WithOAuthErrors.
+func handleGrant(w http.ResponseWriter, r *http.Request, grants ...string) {
+	if !httpx.ParseForm(w, r, MaxBodySize) {
+		return
+	}
+
+	grant := r.PostForm.Get("grant_type")
+	if grant == "" {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "missing grant_type")
+		return
+	}
+	if !slices.Contains(grants, grant) {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeUnsupportedGrantType, "")
+		return
+	}
+	switch grant {
+	case "client_credentials":
+		handleClientCredentials(w, r)
+	case "password":
+		login(w, r, LoginRequest{
+			Username: r.PostForm.Get("username"),
+			Password: r.PostForm.Get("password"),
+			Audience: r.PostForm["audience"],
+		})
+	}
+}
+
+// handleClientCredentials issues a token to a client authenticated with
+// HTTP Basic or the client_id and client_secret form fields. The token's
+// subject is the client id; no refresh token is issued.
+func handleClientCredentials(w http.ResponseWriter, r *http.Request) {
+	id, secret, basic := clientCredentials(r)
+	client, known := Clients[id]
+	if !known {
+		// burn the same time as a real check so unknown clients can't be told apart
+		CheckPassword(dummyHash, secret)
+	}
+	if id == "" || !known || !CheckPassword(client.SecretHash, secret) {
+		audit(r, AuditEvent{Type: AuditLoginFailure, Subject: id})
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidClient, "")
+		return
+	}
+
+	scope, ok := client.grantScopes(r.PostForm.Get("scope"))
+	if !ok {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidScope, "scope not allowed for this client")
+		return
+	}
+
+	opts := tokenOptions{TTL: ClientTokenTTL, Scope: scope, Client: true}
+	if writeToken(w, r, User{Username: client.ID}, opts) {
+		audit(r, AuditEvent{Type: AuditLoginSuccess, Subject: client.ID})
+	}
+}
+
+// clientCredentials reads the client's id and secret from the Authorization
+// header, whose values are form-encoded (RFC 6749 section 2.3.1), or from
+// the form body.
+func clientCredentials(r *http.Request) (id, secret string, basic bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		uid, err1 := url.QueryUnescape(id)
+		usecret, err2 := url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return "", "", true
+		}
+		return uid, usecret, true
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false
+}
+
+// rateLimited answers 429 once the client IP used up its login attempts.
+func rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	if LoginLimiter == nil || LoginLimiter.Allow(r.Context(), "login:"+clientIP(r)) {
+		return false
+	}
+	w.Header().Set("Retry-After", "60")
+	httpx.WriteError(w, r, http.StatusTooManyRequests, httpx.CodeRateLimited, "too many login attempts, try again later")
+	return true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postForm sends form to the token endpoint, with HTTP Basic credentials
+// when user is set.
+func postForm(h http.Handler, form url.Values, user, pass string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	return serve(h, req)
+}
+
+// expectOAuthError fails the test unless rec is an OAuth error with status
+// and code.
+func expectOAuthError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, status, rec.Body)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	decode(t, rec, &body)
+	if body.Error != code {
+		t.Errorf("error = %q, want %q", body.Error, code)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	h := newTestService(t)
+	grant := url.Values{"grant_type": {"client_credentials"}}
+	withSecret := url.Values{"grant_type": {"client_credentials"}, "client_id": {"demo-service"}, "client_secret": {"demo-secret"}}
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"basic": postForm(h, grant, "demo-service", "demo-secret"),
+		"form":  postForm(h, withSecret, "", ""),
+	} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", name, rec.Code, rec.Body)
+		}
+		var resp TokenResponse
+		decode(t, rec, &resp)
+		if resp.RefreshToken != "" {
+			t.Errorf("%s: a client token came with a refresh token", name)
+		}
+		claims := claimsOf(t, resp.AccessToken)
+		if claims.Subject != "demo-service" || !claims.Client || claims.Scope != "greet:read" {
+			t.Errorf("%s: claims %+v", name, claims)
+		}
+		if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != ClientTokenTTL || ttl != 15*time.Minute {
+			t.Errorf("%s: lifetime %v, want the 15m client TTL", name, ttl)
+		}
+	}
+}
+
+func TestClientCredentialsRejected(t *testing.T) {
+	h := newTestService(t)
+	grant := url.Values{"grant_type": {"client_credentials"}}
+
+	rec := postForm(h, grant, "demo-service", "wrong-secret")
+	expectOAuthError(t, rec, http.StatusUnauthorized, "invalid_client")
+	if got := rec.Header().Get("WWW-Authenticate"); got != `Basic realm="token"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+	expectOAuthError(t, postForm(h, grant, "no-such-service", "demo-secret"), http.StatusUnauthorized, "invalid_client")
+	expectOAuthError(t, postForm(h, grant, "", ""), http.StatusUnauthorized, "invalid_client")
+}
+
+func TestClientCredentialsScope(t *testing.T) {
+	h := newTestService(t)
+	rec := postForm(h, url.Values{"grant_type": {"client_credentials"}, "scope": {"greet:read"}}, "demo-service", "demo-secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("an allowed scope: %d %s", rec.Code, rec.Body)
+	}
+	rec = postForm(h, url.Values{"grant_type": {"client_credentials"}, "scope": {"greet:read profile"}}, "demo-service", "demo-secret")
+	expectOAuthError(t, rec, http.StatusBadRequest, "invalid_scope")
+}
+
+// postLoginForm posts form to the login endpoint, form-encoded.
+func postLoginForm(h http.Handler, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(h, req)
+}
+
+func TestPasswordGrant(t *testing.T) {
+	h := newTestService(t)
+	grant := url.Values{"grant_type": {"password"}, "username": {"John Doe"}, "password": {"password"}}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"login": postLoginForm(h, grant),
+		"token": postForm(h, grant, "", ""),
+	} {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", name, rec.Code, rec.Body)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control = %q, want no-store", name, cc)
+		}
+		var resp TokenResponse
+		decode(t, rec, &resp)
+		if resp.TokenType != "Bearer" || resp.ExpiresIn <= 0 || resp.RefreshToken == "" {
+			t.Errorf("%s: token response %+v", name, resp)
+		}
+		if sub := claimsOf(t, resp.AccessToken).Subject; sub != "John Doe" {
+			t.Errorf("%s: subject %q", name, sub)
+		}
+	}
+
+	wrong := url.Values{"grant_type": {"password"}, "username": {"John Doe"}, "password": {"wrong"}}
+	expectOAuthError(t, postLoginForm(h, wrong), http.StatusBadRequest, "invalid_grant")
+}
+
+func TestPasswordGrantRateLimited(t *testing.T) {
+	wrong := url.Values{"grant_type": {"password"}, "username": {"John Doe"}, "password": {"wrong"}}
+	for path, post := range map[string]func(http.Handler) *httptest.ResponseRecorder{
+		"/api/v1/login": func(h http.Handler) *httptest.ResponseRecorder { return postLoginForm(h, wrong) },
+		"/api/v1/token": func(h http.Handler) *httptest.ResponseRecorder { return postForm(h, wrong, "", "") },
+	} {
+		t.Run(path, func(t *testing.T) {
+			h := newTestService(t)
+			LoginLimiter = openRateLimiter(nil, 1, time.Minute)
+			post(h)
+			// the limit is reported in the OAuth shape, like every other grant error
+			expectOAuthError(t, post(h), http.StatusTooManyRequests, "rate_limited")
+		})
+	}
+}
+
+func TestJSONLogin(t *testing.T) {
+	h := newTestService(t)
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	if resp.TokenType != "Bearer" || resp.ExpiresIn <= 0 || resp.AccessToken == "" {
+		t.Errorf("token response %+v", resp)
+	}
+	// JSON logins keep the API's error shape
+	rec = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "wrong"}))
+	expectError(t, rec, http.StatusUnauthorized, "invalid_credentials")
+}
+
+func TestUnsupportedGrantType(t *testing.T) {
+	h := newTestService(t)
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"login": postLoginForm(h, url.Values{"grant_type": {"client_credentials"}}),
+		"token": postForm(h, url.Values{"grant_type": {"authorization_code"}}, "", ""),
+	} {
+		expectOAuthError(t, rec, http.StatusBadRequest, "unsupported_grant_type")
+		if body := strings.TrimSpace(rec.Body.String()); body != `{"error":"unsupported_grant_type"}` {
+			t.Errorf("%s: body %s", name, body)
+		}
+	}
+	expectOAuthError(t, postLoginForm(h, url.Values{"username": {"John Doe"}}), http.StatusBadRequest, "invalid_request")
+}
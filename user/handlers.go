@@ -2,41 +2,388 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
 	"net/http"
-	"time"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
 )
 
+// HandleLogin takes the credentials as JSON or, like the token endpoint,
+// as an OAuth 2.0 password grant form.
 func HandleLogin(w http.ResponseWriter, r *http.Request) {
-	var req User
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Println("ERROR: ", err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+	form := httpx.FormEncoded(r)
+	if form {
+		r = httpx.WithOAuthErrors(r)
+	}
+	if rateLimited(w, r) {
+		return
+	}
+	if form {
+		handleGrant(w, r, "password")
+		return
+	}
+
+	var req LoginRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	login(w, r, req)
+}
+
+// login checks the credentials and answers with a token, or with the mfa
+// challenge for accounts with a second factor.
+func login(w http.ResponseWriter, r *http.Request, req LoginRequest) {
+	identifier := req.Login
+	if identifier == "" {
+		identifier = req.Identifier
+	}
+	if identifier == "" {
+		identifier = req.Username
+	}
+
+	// tell a malformed request apart from wrong credentials
+	var missing []string
+	if identifier == "" {
+		missing = append(missing, "username")
+	}
+	if req.Password == "" {
+		missing = append(missing, "password")
+	}
+	if len(missing) > 0 {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "missing required fields: "+strings.Join(missing, ", "))
+		return
+	}
+	for _, aud := range req.Audience {
+		if !slices.Contains(Audiences, aud) {
+			httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidTarget, "unknown audience "+strconv.Quote(aud))
+			return
+		}
+	}
+
+	user, err := Users.FindByIdentifier(r.Context(), identifier)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	if err != nil {
+		// burn the same time as a real check so unknown users can't be told apart
+		CheckPassword(dummyHash, req.Password)
+	}
+	if err != nil || !CheckPassword(user.PasswordHash, req.Password) {
+		audit(r, AuditEvent{Type: AuditLoginFailure, Subject: identifier})
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidCredentials, "")
+		return
+	}
+	if user.TOTPSecret != "" {
+		writeMFAChallenge(w, r, user, req.Audience)
+		return
+	}
+
+	opts := tokenOptions{
+		NotBefore:  unixTime(req.NotBefore),
+		CookieOnly: CookieOnly || r.URL.Query().Get("cookie_only") == "true",
+		Scope:      strings.Join(user.Scopes, " "),
+		Audience:   req.Audience,
+		Profile:    includesProfile(r),
+	}
+	release, limited := sessionLimited(w, r, user)
+	if limited {
 		return
 	}
+	defer release()
+	if !issueWithRefresh(w, r, &opts, user) {
+		return
+	}
+	if writeToken(w, r, user, opts) {
+		audit(r, AuditEvent{Type: AuditLoginSuccess, Subject: user.Username})
+	}
+}
+
+// issueWithRefresh adds a refresh token to a login's token options. Cookie
+// logins get none, since it would have to travel in the body.
+func issueWithRefresh(w http.ResponseWriter, r *http.Request, opts *tokenOptions, user User) bool {
+	if opts.CookieOnly {
+		return true
+	}
+	if err := withRefreshToken(r.Context(), opts, user); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return false
+	}
+	return true
+}
+
+// HandleRegister creates a user account. Usernames may not contain '@', so
+// a login identifier can never be both a username and an email.
+func HandleRegister(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+
+	switch {
+	case req.Username == "" || req.Password == "":
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "username and password are required")
+		return
+	case strings.Contains(req.Username, "@") || strings.TrimSpace(req.Username) != req.Username:
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "username must not contain '@' or surrounding spaces")
+		return
+	case req.Email != "" && !validEmail(req.Email):
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "email is not a valid address")
+		return
+	}
+	if weakPassword(w, r, req.Password) {
+		return
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	// stamped here, as the store stamps its own copy, so the answer has them
+	user := stamped(User{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Email:        req.Email,
+		Roles:        []string{"user"},
+		Scopes:       []string{"profile", "greet:read"},
+	})
+	switch err := Users.Create(r.Context(), user); {
+	case errors.Is(err, ErrUserExists):
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeUsernameTaken, "")
+		return
+	case errors.Is(err, ErrEmailTaken):
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeEmailTaken, "")
+		return
+	case err != nil:
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// validEmail accepts a bare address like jane@example.com, without a
+// display name and with a dotted domain.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".")
+}
 
-	if req.Password != OurUser.Password || req.Username != OurUser.Username {
-		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+// HandleMintToken lets admins issue tokens for other users, e.g. for batch
+// jobs that must not start before not_before.
+func HandleMintToken(w http.ResponseWriter, r *http.Request) {
+	var req MintRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
 		return
 	}
 
-	claims := jwt.MapClaims{
-		"sub": req.Username,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+	user, err := Users.FindByUsername(r.Context(), req.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		httpx.WriteError(w, r, http.StatusUnprocessableEntity, httpx.CodeUnknownSubject, "")
+		return
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signed, err := token.SignedString(JwtKey)
 	if err != nil {
-		log.Println("ERROR: ", err)
-		http.Error(w, "server error", http.StatusInternalServerError)
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"access_token": signed,
-		"token_type":   "bearer",
+	writeToken(w, r, user, tokenOptions{
+		NotBefore: unixTime(req.NotBefore),
+		Scope:     strings.Join(user.Scopes, " "),
 	})
 }
+
+// writeToken issues a token for user and writes the token response. It
+// reports whether a token was issued.
+func writeToken(w http.ResponseWriter, r *http.Request, user User, opts tokenOptions) bool {
+	signed, err := issueToken(r, user, opts)
+	if errors.Is(err, ErrNotBeforeAfterExpiry) {
+		httpx.WriteError(w, r, http.StatusUnprocessableEntity, httpx.CodeInvalidRequest, err.Error())
+		return false
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return false
+	}
+
+	var profile *LoginUser
+	if opts.Profile {
+		profile = &LoginUser{
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Email:       user.Email,
+			Roles:       user.Roles,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if opts.CookieOnly {
+		// keep the token out of the body so it can't end up in logs or caches
+		http.SetCookie(w, &http.Cookie{
+			Name:     auth.CookieName,
+			Value:    signed,
+			Path:     "/",
+			MaxAge:   int(opts.ttl().Seconds()),
+			HttpOnly: true,
+			Secure:   TrustedProxies.IsHTTPS(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+		body := map[string]any{"expires_in": int(opts.ttl().Seconds())}
+		if profile != nil {
+			body["user"] = profile
+		}
+		json.NewEncoder(w).Encode(body)
+		return true
+	}
+
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  signed,
+		TokenType:    "Bearer", // RFC 6750 casing; the scheme itself is case-insensitive
+		ExpiresIn:    int(opts.ttl().Seconds()),
+		RefreshToken: opts.RefreshToken,
+		Scope:        opts.Scope,
+		User:         profile,
+	})
+	return true
+}
+
+// includesProfile reports whether the login asked for ?include=profile;
+// include takes a comma-separated list.
+func includesProfile(r *http.Request) bool {
+	return slices.Contains(strings.Split(r.URL.Query().Get("include"), ","), "profile")
+}
+
+// HandleLogout revokes the token the request was made with, and its refresh
+// tokens.
+func HandleLogout(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	// the refresh tokens issued with this login go too
+	if sess, ok := Sessions.Get(claims.ID); ok && sess.Family != "" {
+		if err := Refresh.RevokeFamily(r.Context(), sess.Family); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+			return
+		}
+	}
+	if err := Sessions.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	audit(r, AuditEvent{Type: AuditLogout, Subject: claims.Subject, JTI: claims.ID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if notAUser(w, r, claims) {
+		return
+	}
+	json.NewEncoder(w).Encode(Sessions.Active(claims.Subject))
+}
+
+func HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if notAUser(w, r, claims) {
+		return
+	}
+	sess, ok := Sessions.Get(mux.Vars(r)["jti"])
+	if !ok {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeSessionNotFound, "")
+		return
+	}
+
+	// only admins may end somebody else's session
+	if sess.Username != claims.Subject && !claims.HasRole("admin") {
+		httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeForbidden, "only admins may end other users' sessions")
+		return
+	}
+
+	if err := Sessions.Revoke(r.Context(), sess.JTI, sess.ExpiresAt); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	e := AuditEvent{Type: AuditTokenRevoked, Subject: sess.Username, JTI: sess.JTI}
+	if claims.Subject != sess.Username {
+		e.Actor = claims.Subject
+	}
+	audit(r, e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleUserInfo answers with the OpenID Connect standard claims of the
+// token's user.
+func HandleUserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if EnforceScopes && !claims.HasScope("profile") {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="profile"`)
+		httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeInsufficientScope, "the token lacks the profile scope")
+		return
+	}
+	if notAUser(w, r, claims) {
+		return
+	}
+
+	user, err := Users.FindByUsername(r.Context(), claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenInvalid, "the token's user no longer exists")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	info := UserInfo{
+		Subject:           user.Username,
+		Name:              user.DisplayName,
+		PreferredUsername: user.Username,
+		Email:             user.Email,
+	}
+	if !user.UpdatedAt.IsZero() {
+		info.UpdatedAt = user.UpdatedAt.Unix()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// HandleRevocationStatus lets the server service check whether a token was
+// revoked before it accepts it.
+func HandleRevocationStatus(w http.ResponseWriter, r *http.Request) {
+	revoked, err := Sessions.IsRevoked(r.Context(), mux.Vars(r)["jti"])
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"revoked": revoked})
+}
+
+// clientIP is the address the request came from, as far as the trusted
+// proxies tell.
+func clientIP(r *http.Request) string {
+	return TrustedProxies.ClientIP(r)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"auth"
+)
+
+func TestLoginByUsernameOrEmail(t *testing.T) {
+	h := newTestService(t)
+	for _, req := range []LoginRequest{
+		{Username: "John Doe", Password: "password"},
+		{Identifier: "John Doe", Password: "password"},
+		{Identifier: "john.doe@example.com", Password: "password"},
+		{Login: "john.doe@example.com", Password: "password"},
+	} {
+		rec := serve(h, newRequest("POST", "/api/v1/login", req))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%+v: %d %s", req, rec.Code, rec.Body)
+			continue
+		}
+		var resp TokenResponse
+		decode(t, rec, &resp)
+		if sub := claimsOf(t, resp.AccessToken).Subject; sub != "John Doe" {
+			t.Errorf("%+v: logged in as %q", req, sub)
+		}
+	}
+
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Identifier: "nobody@example.com", Password: "password"}))
+	expectError(t, rec, http.StatusUnauthorized, "invalid_credentials")
+}
+
+// addUser stores a user with only a username and password.
+func addUser(t *testing.T, username, password string) {
+	t.Helper()
+	if err := Users.Create(context.Background(), User{Username: username, PasswordHash: mustHashPassword(password)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("userinfo: %d %s", rec.Code, rec.Body)
+	}
+	var info map[string]any
+	decode(t, rec, &info)
+	for claim, want := range map[string]any{"sub": "John Doe", "preferred_username": "John Doe", "email": "john.doe@example.com"} {
+		if info[claim] != want {
+			t.Errorf("%s = %v, want %v", claim, info[claim], want)
+		}
+	}
+	if _, ok := info["updated_at"].(float64); !ok {
+		t.Errorf("updated_at = %v, want epoch seconds", info["updated_at"])
+	}
+}
+
+func TestUserInfoOmitsMissingClaims(t *testing.T) {
+	h := newTestService(t)
+	addUser(t, "jane", "jane-password-1")
+	token := logIn(t, h, "jane", "jane-password-1").AccessToken
+
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+	var info map[string]any
+	decode(t, rec, &info)
+	if _, ok := info["email"]; ok {
+		t.Errorf("email present without one stored: %s", rec.Body)
+	}
+	for claim, v := range info {
+		if v == nil {
+			t.Errorf("%s is null", claim)
+		}
+	}
+}
+
+func TestUserInfoErrors(t *testing.T) {
+	h := newTestService(t, "-enforce-scopes")
+	for _, tc := range []struct {
+		name, token string
+		status      int
+		challenge   string
+	}{
+		{"no token", "", http.StatusUnauthorized, "Bearer"},
+		{"invalid token", "not-a-token", http.StatusUnauthorized, `Bearer error="invalid_token"`},
+	} {
+		req := newRequest("GET", "/api/v1/userinfo", nil)
+		if tc.token != "" {
+			withToken(req, tc.token)
+		}
+		rec := serve(h, req)
+		if rec.Code != tc.status || rec.Header().Get("WWW-Authenticate") != tc.challenge {
+			t.Errorf("%s: %d with WWW-Authenticate %q, want %d with %q", tc.name, rec.Code, rec.Header().Get("WWW-Authenticate"), tc.status, tc.challenge)
+		}
+	}
+
+	// without the profile scope
+	addUser(t, "jane", "jane-password-1")
+	token := logIn(t, h, "jane", "jane-password-1").AccessToken
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+	expectError(t, rec, http.StatusForbidden, "insufficient_scope")
+	if got := rec.Header().Get("WWW-Authenticate"); got != `Bearer error="insufficient_scope", scope="profile"` {
+		t.Errorf("WWW-Authenticate = %q", got)
+	}
+}
+
+func TestLoginCookieOnly(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args []string
+		path string
+	}{
+		"deployment": {[]string{"-cookie-only"}, "/api/v1/login"},
+		"request":    {nil, "/api/v1/login?cookie_only=true"},
+	} {
+		h := newTestService(t, tc.args...)
+		rec := serve(h, newRequest("POST", tc.path, LoginRequest{Username: "John Doe", Password: "password"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", name, rec.Code, rec.Body)
+		}
+		var body map[string]any
+		decode(t, rec, &body)
+		if _, ok := body["access_token"]; ok || body["expires_in"] == nil {
+			t.Errorf("%s: body %s, want only expires_in", name, rec.Body)
+		}
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != auth.CookieName || !cookies[0].HttpOnly {
+			t.Fatalf("%s: cookies %v, want one HttpOnly %s", name, cookies, auth.CookieName)
+		}
+		req := newRequest("GET", "/api/v1/userinfo", nil)
+		req.AddCookie(cookies[0])
+		if rec := serve(h, req); rec.Code != http.StatusOK {
+			t.Errorf("%s: the cookie does not authenticate: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestLoginTokenInBody(t *testing.T) {
+	h := newTestService(t)
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	if resp.AccessToken == "" {
+		t.Errorf("no access_token in %s", rec.Body)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies set by default: %v", cookies)
+	}
+}
+
+func TestLoginMissingFields(t *testing.T) {
+	h := newTestService(t)
+	for _, tc := range []struct {
+		name    string
+		req     LoginRequest
+		message string
+	}{
+		{"missing username", LoginRequest{Password: "password"}, "missing required fields: username"},
+		{"missing password", LoginRequest{Username: "John Doe"}, "missing required fields: password"},
+		{"both missing", LoginRequest{}, "missing required fields: username, password"},
+	} {
+		rec := serve(h, newRequest("POST", "/api/v1/login", tc.req))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tc.name, rec.Code)
+			continue
+		}
+		var body struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		decode(t, rec, &body)
+		if body.Error.Code != "invalid_request" || body.Error.Message != tc.message {
+			t.Errorf("%s: got %+v, want invalid_request %q", tc.name, body.Error, tc.message)
+		}
+	}
+	if n := len(Audit.(*MemoryAuditLogger).Events()); n != 0 {
+		t.Errorf("malformed logins were audited as attempts: %d events", n)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	h := newTestService(t, "-max-body-size", "128")
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	huge := `{"username":"` + strings.Repeat("a", 256) + `"}`
+	for _, req := range []*http.Request{
+		newRequest("POST", "/api/v1/login", huge),
+		newRequest("POST", "/api/v1/register", huge),
+		withToken(newRequest("POST", "/api/v1/password", huge), token),
+	} {
+		rec := serve(h, req)
+		if rec.Code != http.StatusRequestEntityTooLarge || errorCode(t, rec) != "request_too_large" {
+			t.Errorf("%s: %d %s, want 413 request_too_large", req.URL.Path, rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(h, newRequest("POST", "/api/v1/register", `{"username":"jane","password":"jane-password-1","admin":true}`))
+	expectError(t, rec, http.StatusBadRequest, "invalid_request")
+}
+
+// panickingUsers fails every lookup the way a broken store would.
+type panickingUsers struct{ UserStore }
+
+func (panickingUsers) FindByIdentifier(context.Context, string) (User, error) {
+	panic("store exploded")
+}
+
+func TestPanicInHandler(t *testing.T) {
+	h := newTestService(t)
+	users := Users
+	Users = panickingUsers{users}
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	expectError(t, rec, http.StatusInternalServerError, "internal_error")
+
+	Users = users
+	logIn(t, h, "John Doe", "password")
+}
+
+func TestLoginResponseScopeAndType(t *testing.T) {
+	h := newTestService(t)
+	resp := logIn(t, h, "John Doe", "password")
+	if resp.TokenType != "Bearer" {
+		t.Errorf("token_type = %q, want Bearer", resp.TokenType)
+	}
+	if resp.Scope != "profile greet:read" {
+		t.Errorf("scope = %q, want the user's granted scopes", resp.Scope)
+	}
+	if claims := claimsOf(t, resp.AccessToken); claims.Scope != resp.Scope {
+		t.Errorf("the token's scope %q differs from the response's", claims.Scope)
+	}
+
+	// the scheme stays case-insensitive
+	req := newRequest("GET", "/api/v1/userinfo", nil)
+	req.Header.Set("Authorization", "bearer "+resp.AccessToken)
+	if rec := serve(h, req); rec.Code != http.StatusOK {
+		t.Errorf("lower-case scheme: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestLoginIdentifierCase(t *testing.T) {
+	h := newTestService(t)
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Login: "John.Doe@Example.COM", Password: "password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("email in another case: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	if sub := claimsOf(t, resp.AccessToken).Subject; sub != "John Doe" {
+		t.Errorf("sub = %q, want the canonical username", sub)
+	}
+
+	// usernames are case-sensitive
+	rec = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Login: "john doe", Password: "password"}))
+	expectError(t, rec, http.StatusUnauthorized, "invalid_credentials")
+}
+
+func TestRegisterAmbiguousIdentifier(t *testing.T) {
+	h := newTestService(t)
+	// a username that looks like an email could clash with someone's address
+	for _, username := range []string{"john.doe@example.com", "jane@", " jane"} {
+		rec := serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: username, Password: "jane-password-1"}))
+		expectError(t, rec, http.StatusBadRequest, "invalid_request")
+	}
+	rec := serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Email: "not-an-email", Password: "jane-password-1"}))
+	expectError(t, rec, http.StatusBadRequest, "invalid_request")
+}
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	h := newTestService(t)
+	rec := serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Email: "JOHN.DOE@example.com", Password: "jane-password-1"}))
+	expectError(t, rec, http.StatusConflict, "email_taken")
+	rec = serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "John Doe", Email: "jd@example.com", Password: "jane-password-1"}))
+	expectError(t, rec, http.StatusConflict, "username_taken")
+
+	rec = serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Email: "jane@example.com", Password: "jane-password-1"}))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("registering: %d %s", rec.Code, rec.Body)
+	}
+	if sub := claimsOf(t, logIn(t, h, "jane@example.com", "jane-password-1").AccessToken).Subject; sub != "jane" {
+		t.Errorf("logged in by email as %q", sub)
+	}
+}
+
+func TestLoginIncludeProfile(t *testing.T) {
+	h := newTestService(t)
+	login := func(query string) map[string]json.RawMessage {
+		rec := serve(h, newRequest("POST", "/api/v1/login"+query, LoginRequest{Username: "admin", Password: "admin"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login%s: %d %s", query, rec.Code, rec.Body)
+		}
+		var body map[string]json.RawMessage
+		decode(t, rec, &body)
+		return body
+	}
+
+	for _, query := range []string{"?include=profile", "?include=scopes,profile"} {
+		body := login(query)
+		var user map[string]any
+		if err := json.Unmarshal(body["user"], &user); err != nil {
+			t.Fatalf("%s: no user object: %s", query, body["user"])
+		}
+		if user["username"] != "admin" || user["email"] != "admin@example.com" {
+			t.Errorf("%s: user = %v", query, user)
+		}
+		if roles, _ := user["roles"].([]any); len(roles) != 2 || roles[1] != "admin" {
+			t.Errorf("%s: roles = %v", query, user["roles"])
+		}
+		for field := range user {
+			if !slices.Contains([]string{"username", "display_name", "email", "roles"}, field) {
+				t.Errorf("%s: the user object has %q", query, field)
+			}
+		}
+		if raw := string(body["user"]); strings.Contains(raw, "$2a$") || strings.Contains(strings.ToLower(raw), "password") {
+			t.Errorf("%s: the user object leaks the password: %s", query, raw)
+		}
+	}
+
+	// by default the response is the plain token response
+	for _, query := range []string{"", "?include=scopes"} {
+		body := login(query)
+		if _, ok := body["user"]; ok {
+			t.Errorf("login%s has a user object", query)
+		}
+		for field := range body {
+			if !slices.Contains([]string{"access_token", "token_type", "expires_in", "refresh_token", "scope"}, field) {
+				t.Errorf("login%s has %q", query, field)
+			}
+		}
+	}
+}
+
+func TestLoginErrorFormat(t *testing.T) {
+	h := newTestService(t)
+	wrong := LoginRequest{Username: "John Doe", Password: "wrong"}
+
+	// API clients get a JSON error
+	rec := serve(h, newRequest("POST", "/api/v1/login", wrong))
+	expectError(t, rec, http.StatusUnauthorized, "invalid_credentials")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// curl gets the same status, in plain text
+	req := newRequest("POST", "/api/v1/login", wrong)
+	req.Header.Del("Accept")
+	rec = serve(h, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401 (%s)", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := rec.Body.String(); !strings.HasPrefix(body, "invalid_credentials") || strings.Contains(body, "{") {
+		t.Errorf("plain text body = %q", body)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"auth"
+	"auth/httpx"
+)
+
+// KeyRetirement is how long a rotated-out signing key keeps verifying, so
+// the tokens it signed can run out their lifetime.
+var KeyRetirement = 24 * time.Hour
+
+// KeyListing is a signing key as listed to admins, without its material.
+type KeyListing struct {
+	auth.KeyInfo
+	AgeSeconds int64 `json:"age_seconds"`
+}
+
+// HandleRotateKey generates a new signing key and signs with it from now on.
+// Only asymmetric keys can be rotated here: verifiers pick the new public key
+// up from the JWKS, whereas an HS256 secret would have to reach them some
+// other way.
+func HandleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if Keys.Method().Alg() == "HS256" {
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeRotationUnsupported,
+			"HS256 secrets are shared out of band; replace the secret file and send SIGHUP instead")
+		return
+	}
+
+	info, err := Keys.Rotate(KeyRetirement)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	audit(r, AuditEvent{Type: AuditKeyRotated, Subject: info.Kid, Actor: claims.Subject})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(listing(info))
+}
+
+// HandleListKeys lists the keys tokens are currently verified with.
+func HandleListKeys(w http.ResponseWriter, r *http.Request) {
+	keys := []KeyListing{}
+	for _, info := range Keys.Keys() {
+		keys = append(keys, listing(info))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func listing(info auth.KeyInfo) KeyListing {
+	return KeyListing{KeyInfo: info, AgeSeconds: int64(time.Since(info.CreatedAt).Seconds())}
+}
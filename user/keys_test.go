@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"auth"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// useEdDSAKeys switches the service to a freshly generated Ed25519 key.
+func useEdDSAKeys(t *testing.T) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if Keys, err = auth.LoadSigningKeyring("EdDSA", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listKeys(t *testing.T, h http.Handler, token string) []KeyListing {
+	t.Helper()
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/admin/keys", nil), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing keys: %d %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "PRIVATE") || strings.Contains(rec.Body.String(), `"d"`) {
+		t.Errorf("the listing holds key material: %s", rec.Body)
+	}
+	var keys []KeyListing
+	decode(t, rec, &keys)
+	return keys
+}
+
+func TestRotateKey(t *testing.T) {
+	h := newTestService(t)
+	useEdDSAKeys(t)
+	before := logIn(t, h, "admin", "admin").AccessToken
+
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/keys/rotate", nil), before))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("rotating: %d %s", rec.Code, rec.Body)
+	}
+	var rotated KeyListing
+	decode(t, rec, &rotated)
+	after := logIn(t, h, "admin", "admin").AccessToken
+
+	keys := listKeys(t, h, after)
+	if len(keys) != 2 || keys[0].Kid != rotated.Kid || !keys[0].Current || keys[1].RetiresAt == nil {
+		t.Errorf("keys after the rotation: %+v", keys)
+	}
+	// tokens from before and after the rotation both work
+	listKeys(t, h, before)
+	if kid := claimsKid(t, after); kid != rotated.Kid {
+		t.Errorf("new tokens are signed with %s, want %s", kid, rotated.Kid)
+	}
+	audited := false
+	for _, e := range Audit.(*MemoryAuditLogger).Events() {
+		audited = audited || e.Type == AuditKeyRotated && e.Subject == rotated.Kid && e.Actor == "admin"
+	}
+	if !audited {
+		t.Error("the rotation is not audited")
+	}
+}
+
+func TestRotateKeyHS256(t *testing.T) {
+	h := newTestService(t)
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/keys/rotate", nil), admin))
+	expectError(t, rec, http.StatusConflict, "rotation_unsupported")
+}
+
+// claimsKid returns the kid in the header of token.
+func claimsKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
@@ -1,22 +1,199 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
+	"auth"
+	"auth/config"
+	"auth/httpx"
+
+	"github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite"
 )
 
-var JwtKey []byte
+var (
+	Keys     *auth.Keyring
+	Users    UserStore
+	Clients  = map[string]Client{OurClient.ID: OurClient}
+	Sessions *SessionStore
+	APIKeys  APIKeyStore  = NewMemoryAPIKeyStore()
+	Refresh  RefreshStore = NewMemoryRefreshStore()
+	Audit    AuditLogger  = &MemoryAuditLogger{}
+
+	// EnforceScopes makes endpoints check the token's scope claim.
+	EnforceScopes bool
+	// Audiences are the services logins may ask a token for.
+	Audiences []string
+	// Audience is this service's own aud. Its routes accept tokens without
+	// an aud, as logins issue by default, and tokens naming it; empty
+	// accepts only the former.
+	Audience string
+	// RevocationFailOpen accepts tokens whose revocation status cannot be
+	// checked instead of refusing them.
+	RevocationFailOpen bool
+	// LegacyClaimsUntil ends the migration window after auth.ClaimsVersion
+	// went up, during which tokens in the previous claims format are
+	// accepted; zero means there is none.
+	LegacyClaimsUntil time.Time
+	// LoginLimiter throttles login attempts per client IP; nil disables it.
+	LoginLimiter auth.RateLimitStore
+	// ResolveLimiter throttles API key lookups per calling IP; nil disables
+	// it.
+	ResolveLimiter auth.RateLimitStore
+	// TrustedProxies may set X-Forwarded-For; nobody by default.
+	TrustedProxies httpx.TrustedProxies
+	// ServiceSecret must come with calls to the /internal routes; empty
+	// (dev mode only) lets anyone call them.
+	ServiceSecret string
+	// MaxBodySize caps JSON request bodies, in bytes.
+	MaxBodySize int64 = 1 << 20
+)
 
 func main() {
-	JwtKey = []byte("supersecretkey")
+	cfg := loadConfig()
+	if err := config.SetLogFormat(cfg.LogFormat); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("effective configuration: %v", cfg)
+
+	Keys = loadKeys(cfg)
+	Keys.ReloadOn(syscall.SIGHUP)
+	if cfg.JWEKeyFile != "" {
+		var err error
+		if Encryption, err = auth.LoadTokenEncryption(cfg.JWEKeyFile); err != nil {
+			log.Fatalf("loading token encryption key: %v", err)
+		}
+	}
+	Users = openUserStore(cfg.UserDB)
+	var rdb *redis.Client
+	if cfg.RedisAddr != "" {
+		rdb = redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+	}
+	Sessions = NewSessionStore(openRevocations(rdb))
+	if cfg.LoginRateLimit > 0 {
+		LoginLimiter = openRateLimiter(rdb, cfg.LoginRateLimit, time.Minute)
+	}
+	if cfg.ResolveRateLimit > 0 {
+		ResolveLimiter = openRateLimiter(rdb, cfg.ResolveRateLimit, time.Minute)
+	}
+	if cfg.ClientsFile != "" {
+		var err error
+		if Clients, err = loadClients(cfg.ClientsFile); err != nil {
+			log.Fatalf("loading clients: %v", err)
+		}
+	}
+	applyConfig(cfg)
+
+	if cfg.AuditLogFile != "" {
+		fileAudit, err := NewFileAuditLogger(cfg.AuditLogFile)
+		if err != nil {
+			log.Fatalf("opening audit log: %v", err)
+		}
+		Audit = fileAudit
+	}
+
+	r := newRouter(cfg)
+
+	Sessions.CollectEvery(time.Minute)
+
+	log.Printf("the service is listening on: %s", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, r))
+}
 
-	r := mux.NewRouter()
+// applyConfig sets the globals that come straight from the configuration,
+// without opening anything.
+func applyConfig(cfg Config) {
+	RevocationFailOpen = cfg.RevocationFailOpen
+	TokenTTL = cfg.TokenTTL
+	KeyRetirement = cfg.KeyRetirement
+	ClientTokenTTL = cfg.ClientTokenTTL
+	ActionTokenTTL = cfg.ActionTokenTTL
+	RefreshTokenTTL = cfg.RefreshTokenTTL
+	MaxSessionAge = cfg.MaxSessionAge
+	MaxSessions = cfg.MaxSessions
+	EvictOldestSession = cfg.SessionLimitPolicy == SessionLimitEvict
+	Passwords = PasswordPolicy{
+		MinLength:     cfg.PasswordMinLength,
+		RequireDigit:  cfg.PasswordDigit,
+		RequireUpper:  cfg.PasswordUpper,
+		RequireSymbol: cfg.PasswordSymbol,
+	}
+	CookieOnly = cfg.CookieOnly
+	EnforceScopes = cfg.EnforceScopes
+	LegacyClaimsUntil = cfg.LegacyClaimsUntil
+	Audiences = cfg.audiences()
+	Audience = cfg.Audience
+	MaxBodySize = cfg.MaxBodySize
+	TrustedProxies, _ = httpx.ParseTrustedProxies(cfg.TrustedProxies) // checked by validate
+	ServiceSecret = cfg.InternalSecret
+}
+
+// loadKeys reads the signing key for the configured algorithm: the HS256
+// secret (given directly or in a file) or the RS256/EdDSA private key file.
+// Without either the tutorial's hardcoded secret is used.
+func loadKeys(cfg Config) *auth.Keyring {
+	path := cfg.JWTKeyFile
+	if cfg.JWTAlg == "HS256" {
+		if cfg.JWTSecretFile == "" {
+			secret := cfg.JWTSecret
+			if secret == "" {
+				secret = auth.DefaultSecret
+			}
+			return auth.StaticKeyring([]byte(secret))
+		}
+		path = cfg.JWTSecretFile
+	}
+
+	keys, err := auth.LoadSigningKeyring(cfg.JWTAlg, path)
+	if err != nil {
+		log.Fatalf("loading keys: %v", err)
+	}
+	return keys
+}
+
+// openUserStore keeps users in the SQLite database at path, making sure
+// the tutorial users exist, or in memory when path is empty.
+func openUserStore(path string) UserStore {
+	if path == "" {
+		return NewMemoryUserStore(OurUser, OurAdmin)
+	}
 
-	r.HandleFunc("/login", HandleLogin).Methods("POST")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		log.Fatalf("opening user database: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		log.Fatalf("migrating user database: %v", err)
+	}
+
+	store := NewSQLUserStore(db)
+	for _, u := range []User{OurUser, OurAdmin} {
+		if err := store.Create(context.Background(), u); err != nil && !errors.Is(err, ErrUserExists) {
+			log.Fatalf("seeding user database: %v", err)
+		}
+	}
+	return store
+}
+
+// openRevocations shares revocations through Redis, or keeps them in memory
+// when there is no Redis client.
+func openRevocations(rdb *redis.Client) auth.RevocationStore {
+	if rdb == nil {
+		return auth.NewMemoryRevocationStore()
+	}
+	return auth.NewRedisRevocationStore(rdb)
+}
 
-	port := "8080"
-	log.Printf("the service is listening on: %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+// openRateLimiter is openRevocations for rate limit counters.
+func openRateLimiter(rdb *redis.Client, limit int, window time.Duration) auth.RateLimitStore {
+	if rdb == nil {
+		return auth.NewMemoryRateLimitStore(limit, window)
+	}
+	return auth.NewRedisRateLimitStore(rdb, limit, window)
 }
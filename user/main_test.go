@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth"
+)
+
+// testSecret signs the tokens of the service under test.
+const testSecret = "user-test-secret"
+
+// newTestService resets the service's globals to fresh in-memory stores
+// holding the tutorial users and returns its router, configured in dev mode
+// plus args, with the error codes it answers recorded (see recordCodes).
+func newTestService(t *testing.T, args ...string) http.Handler {
+	t.Helper()
+	cfg, _, err := parseConfig(append([]string{"-dev"}, args...), func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	Keys = auth.StaticKeyring([]byte(testSecret))
+	Encryption = nil
+	Users = NewMemoryUserStore(OurUser, OurAdmin)
+	Clients = map[string]Client{OurClient.ID: OurClient}
+	Sessions = NewSessionStore(auth.NewMemoryRevocationStore())
+	APIKeys = NewMemoryAPIKeyStore()
+	Refresh = NewMemoryRefreshStore()
+	Audit = &MemoryAuditLogger{}
+	TOTP = NewTOTPVerifier(time.Now)
+	LoginLimiter = nil
+	ResolveLimiter = nil
+	applyConfig(cfg)
+	return recordCodes(newRouter(cfg))
+}
+
+// newRequest builds a request asking for JSON, with body encoded as JSON
+// unless it is a string, which is sent as it is.
+func newRequest(method, path string, body any) *http.Request {
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = bytes.NewBufferString(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			panic(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Accept", "application/json")
+	if _, ok := body.(string); !ok && body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// withToken adds token to req as a bearer token.
+func withToken(req *http.Request, token string) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// logIn logs username in and returns the token response, failing the test
+// unless that works.
+func logIn(t *testing.T, h http.Handler, username, password string) TokenResponse {
+	t.Helper()
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: username, Password: password}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logging in %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	return resp
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
+
+// errorCode returns the code of a JSON error response.
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	decode(t, rec, &body)
+	return body.Error.Code
+}
+
+// expectError fails the test unless rec is an error with status and code.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, status, rec.Body)
+	}
+	if got := errorCode(t, rec); got != code {
+		t.Errorf("error code = %q, want %q", got, code)
+	}
+}
+
+// expectOK fails the test unless rec is a 200.
+func expectOK(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rec.Code, rec.Body)
+	}
+}
+
+// claimsOf parses a token issued by the service under test.
+func claimsOf(t *testing.T, token string) *auth.Claims {
+	t.Helper()
+	claims, err := auth.ParseToken(token, Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mfaTokenTTL bounds the time between the password and the TOTP code.
+const mfaTokenTTL = 5 * time.Minute
+
+var TOTP = NewTOTPVerifier(time.Now)
+
+// MFAChallenge answers a correct password of a user with two-factor login
+// enabled. The mfa token is no access token; it can only be exchanged at
+// /2fa/verify together with a TOTP code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// writeMFAChallenge issues a single-use mfa token for user. It carries the
+// audiences the access token will be for.
+func writeMFAChallenge(w http.ResponseWriter, r *http.Request, user User, audience []string) {
+	now := time.Now()
+	claims := auth.Claims{
+		Purpose: "mfa",
+		Version: auth.ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(mfaTokenTTL)),
+		},
+	}
+	if len(audience) > 0 {
+		claims.Audience = audience
+	}
+	signed, err := Keys.Sign(claims)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(MFAChallenge{
+		MFARequired: true,
+		MFAToken:    signed,
+		ExpiresIn:   int(mfaTokenTTL.Seconds()),
+	})
+}
+
+// HandleMFAVerify exchanges an mfa token and a TOTP code for an access
+// token.
+func HandleMFAVerify(w http.ResponseWriter, r *http.Request) {
+	if rateLimited(w, r) {
+		return
+	}
+	var req MFAVerifyRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+
+	claims, err := auth.ParsePurposeToken(req.MFAToken, Keys, "mfa")
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenInvalid, "invalid or expired mfa token")
+		return
+	}
+	revoked, err := Sessions.IsRevoked(r.Context(), claims.ID)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: checking revocation: ", err)
+		httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "revocations cannot be checked")
+		return
+	}
+	if revoked {
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenInvalid, "mfa token already used")
+		return
+	}
+
+	user, err := Users.FindByUsername(r.Context(), claims.Subject)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenInvalid, "invalid or expired mfa token")
+		return
+	}
+	if user.TOTPSecret == "" || !TOTP.Verify(user.Username, user.TOTPSecret, req.Code) {
+		audit(r, AuditEvent{Type: AuditLoginFailure, Subject: user.Username})
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidCode, "")
+		return
+	}
+
+	// the mfa token is single use
+	if err := Sessions.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	opts := tokenOptions{
+		CookieOnly: CookieOnly || r.URL.Query().Get("cookie_only") == "true",
+		Scope:      strings.Join(user.Scopes, " "),
+		Profile:    includesProfile(r),
+		Audience:   claims.Audience,
+	}
+	release, limited := sessionLimited(w, r, user)
+	if limited {
+		return
+	}
+	defer release()
+	if !issueWithRefresh(w, r, &opts, user) {
+		return
+	}
+	if writeToken(w, r, user, opts) {
+		audit(r, AuditEvent{Type: AuditLoginSuccess, Subject: user.Username})
+	}
+}
+
+// HandleTOTPEnroll starts two-factor enrollment for the caller. The secret
+// stays pending until confirmed through HandleTOTPActivate.
+func HandleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := callingUser(w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPSecret != "" {
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeMFAAlreadyEnabled, "")
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err == nil {
+		err = Users.UpdateTOTP(r.Context(), user.Username, "", secret)
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totpURI(user.Username, secret),
+	})
+}
+
+// HandleTOTPActivate turns two-factor login on once the caller proves their
+// authenticator produces codes for the pending secret.
+func HandleTOTPActivate(w http.ResponseWriter, r *http.Request) {
+	user, ok := callingUser(w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if user.TOTPPending == "" {
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeNoPendingEnrollment, "enroll first")
+		return
+	}
+	if !TOTP.Verify(user.Username, user.TOTPPending, req.Code) {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidCode, "")
+		return
+	}
+
+	if err := Users.UpdateTOTP(r.Context(), user.Username, user.TOTPPending, ""); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callingUser loads the user the request's token was issued to.
+func callingUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if notAUser(w, r, claims) {
+		return User{}, false
+	}
+	user, err := Users.FindByUsername(r.Context(), claims.Subject)
+	if errors.Is(err, ErrUserNotFound) {
+		httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeNotAUser, "only user accounts can do this")
+		return User{}, false
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return User{}, false
+	}
+	return user, true
+}
+
+// notAUser refuses client_credentials tokens on routes about the calling
+// user: a client id may well equal somebody's username.
+func notAUser(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if !claims.Client {
+		return false
+	}
+	httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeNotAUser, "only user accounts can do this")
+	return true
+}
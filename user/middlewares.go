@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
+)
+
+func jwtAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr, err := auth.BearerToken(r)
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			code, msg := auth.TokenError(err)
+			httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+			return
+		}
+
+		claims, err := parseToken(tokenStr)
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: invalid token: ", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			code, msg := auth.TokenError(err)
+			httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+			return
+		}
+
+		if err := checkAudience(claims); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			code, msg := auth.TokenError(err)
+			httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+			return
+		}
+		if legacyClaims(w, r, claims) {
+			return
+		}
+
+		revoked, err := Sessions.IsRevoked(r.Context(), claims.ID)
+		if err != nil {
+			httpx.Log(r.Context()).Println("ERROR: checking revocation: ", err)
+			if !RevocationFailOpen {
+				httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "revocations cannot be checked")
+				return
+			}
+		}
+		if revoked {
+			httpx.Log(r.Context()).Println("ERROR: revoked token")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenRevoked, "the token has been revoked")
+			return
+		}
+		if stale(w, r, claims) {
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	})
+}
+
+// parseToken decrypts the token if it is encrypted, then verifies it.
+func parseToken(tokenStr string) (*auth.Claims, error) {
+	tokenStr, err := Encryption.Unwrap(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	return auth.ParseToken(tokenStr, Keys)
+}
+
+// checkAudience accepts tokens without an aud, which logins issue unless
+// asked for others, and tokens naming Audience. A token minted only for
+// other services does not open the account routes here.
+func checkAudience(claims *auth.Claims) error {
+	if len(claims.Audience) == 0 {
+		return nil
+	}
+	return auth.CheckAudience(claims, Audience)
+}
+
+// legacyClaims refuses tokens in the previous claims format once the
+// migration window is over, and logs them while it lasts, to tell when
+// none are left.
+func legacyClaims(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	version := claims.Version
+	if err := auth.CheckClaimsVersion(claims, LegacyClaimsUntil); err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		code, msg := auth.TokenError(err)
+		httpx.WriteError(w, r, http.StatusUnauthorized, code, msg)
+		return true
+	}
+	if version < auth.ClaimsVersion {
+		httpx.Log(r.Context()).Printf("legacy claims: version %d token for %s", version, claims.Subject)
+	}
+	return false
+}
+
+// stale refuses user tokens issued before the user's last password change,
+// or for users that no longer exist.
+func stale(w http.ResponseWriter, r *http.Request, claims *auth.Claims) bool {
+	if claims.Client {
+		return false
+	}
+	user, err := Users.FindByUsername(r.Context(), claims.Subject)
+	if err == nil && user.TokenVersion == claims.TokenVersion {
+		return false
+	}
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusServiceUnavailable, httpx.CodeUnavailable, "token versions cannot be checked")
+		return true
+	}
+	httpx.Log(r.Context()).Println("ERROR: stale token for", claims.Subject)
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeTokenStale, "the token was issued before a password change")
+	return true
+}
+
+// requireRole only lets through requests whose token grants role. It must be
+// mounted after jwtAuthMiddleware.
+func requireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := auth.ClaimsFromContext(r.Context())
+			if !ok || !claims.HasRole(role) {
+				httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeInsufficientRole, "requires the "+role+" role")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auth"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// withRedisSessions backs the sessions with a miniredis the test can stop.
+func withRedisSessions(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	Sessions = NewSessionStore(auth.NewRedisRevocationStore(client))
+	return mr
+}
+
+func TestRevocationFailurePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		status int
+	}{
+		{"fail closed", nil, http.StatusServiceUnavailable},
+		{"fail open", []string{"-revocation-fail-open"}, http.StatusOK},
+	} {
+		h := newTestService(t, tc.args...)
+		mr := withRedisSessions(t)
+		token := logIn(t, h, "John Doe", "password").AccessToken
+		if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)); rec.Code != http.StatusOK {
+			t.Fatalf("%s: with Redis up: %d %s", tc.name, rec.Code, rec.Body)
+		}
+
+		mr.Close()
+		rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+		if rec.Code != tc.status {
+			t.Errorf("%s: with Redis down: %d %s, want %d", tc.name, rec.Code, rec.Body, tc.status)
+		}
+		if tc.status == http.StatusServiceUnavailable && errorCode(t, rec) != "unavailable" {
+			t.Errorf("%s: %s", tc.name, rec.Body)
+		}
+	}
+}
+
+func TestRedisRevocationsShared(t *testing.T) {
+	h := newTestService(t)
+	mr := withRedisSessions(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	if rec := serve(h, withToken(newRequest("POST", "/api/v1/logout", nil), token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", rec.Code, rec.Body)
+	}
+
+	// the entry another instance would see expires with the token
+	key := "revoked:" + claimsOf(t, token).ID
+	if ttl := mr.TTL(key); ttl <= TokenTTL-time.Minute || ttl > TokenTTL {
+		t.Errorf("TTL of %s = %v, want the token's remaining %v", key, ttl, TokenTTL)
+	}
+}
+
+func TestLegacyClaims(t *testing.T) {
+	// a login's token, re-signed in the previous claims format
+	legacyToken := func(h http.Handler) (current, legacy string) {
+		current = logIn(t, h, "John Doe", "password").AccessToken
+		claims := claimsOf(t, current)
+		claims.Version = 0
+		legacy, err := Keys.Sign(*claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return current, legacy
+	}
+	userinfo := func(h http.Handler, token string) *httptest.ResponseRecorder {
+		return serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+	}
+
+	h := newTestService(t, "-legacy-claims-until", time.Now().Add(time.Hour).Format(time.RFC3339))
+	current, legacy := legacyToken(h)
+	logs := captureLog(t)
+	for name, token := range map[string]string{"current": current, "legacy": legacy} {
+		if rec := userinfo(h, token); rec.Code != http.StatusOK {
+			t.Errorf("%s token during the window: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	if n := strings.Count(logs.String(), "legacy claims: version 0 token for John Doe"); n != 1 {
+		t.Errorf("the legacy token was logged %d times: %s", n, logs)
+	}
+
+	for name, args := range map[string][]string{
+		"closed window": {"-legacy-claims-until", time.Now().Add(-time.Hour).Format(time.RFC3339)},
+		"no window":     nil,
+	} {
+		h := newTestService(t, args...)
+		current, legacy := legacyToken(h)
+		if rec := userinfo(h, current); rec.Code != http.StatusOK {
+			t.Errorf("%s: current token: %d %s", name, rec.Code, rec.Body)
+		}
+		if rec := userinfo(h, legacy); rec.Code != http.StatusUnauthorized || errorCode(t, rec) != "legacy_claims" {
+			t.Errorf("%s: legacy token: %d %s", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestAudience(t *testing.T) {
+	// tokens as logins issue them, re-signed for the audience
+	tokenFor := func(h http.Handler, aud ...string) string {
+		claims := claimsOf(t, logIn(t, h, "John Doe", "password").AccessToken)
+		claims.Audience = aud
+		token, err := Keys.Sign(*claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	userinfo := func(h http.Handler, token string) *httptest.ResponseRecorder {
+		return serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token))
+	}
+
+	a := newTestService(t, "-jwt-audience", "service-a")
+	forA, forB, forNone := tokenFor(a, "service-a"), tokenFor(a, "service-b"), tokenFor(a)
+	expectOK(t, userinfo(a, forA))
+	expectOK(t, userinfo(a, forNone))
+	expectOK(t, userinfo(a, tokenFor(a, "service-b", "service-a")))
+	expectError(t, userinfo(a, forB), http.StatusUnauthorized, "wrong_audience")
+	// the account routes refuse it too
+	rec := serve(a, withToken(newRequest("POST", "/api/v1/logout", nil), forB))
+	expectError(t, rec, http.StatusUnauthorized, "wrong_audience")
+
+	b := newTestService(t, "-jwt-audience", "service-b")
+	forA, forB = tokenFor(b, "service-a"), tokenFor(b, "service-b")
+	expectOK(t, userinfo(b, forB))
+	expectError(t, userinfo(b, forA), http.StatusUnauthorized, "wrong_audience")
+
+	// without an audience of its own the service takes only its logins
+	none := newTestService(t)
+	forA, forNone = tokenFor(none, "service-a"), tokenFor(none)
+	expectOK(t, userinfo(none, forNone))
+	expectError(t, userinfo(none, forA), http.StatusUnauthorized, "wrong_audience")
+}
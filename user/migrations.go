@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order; never edit one that has shipped, append
+// a new one instead.
+var migrations = []string{
+	`CREATE TABLE users (
+		username      TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		email         TEXT UNIQUE,
+		roles         TEXT NOT NULL DEFAULT ''
+	)`,
+	`ALTER TABLE users ADD COLUMN scopes TEXT NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX users_email_nocase ON users (lower(email))`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN totp_pending TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0`,
+}
+
+// Migrate brings the database schema up to date.
+func Migrate(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
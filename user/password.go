@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is checked against when the user doesn't exist.
+var dummyHash = mustHashPassword("not a real password")
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func mustHashPassword(password string) string {
+	hash, err := HashPassword(password)
+	if err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	return hash
+}
+
+// HandleChangePassword sets a new password for the caller, who must know the
+// current one. Every token issued before, the caller's included, goes stale.
+func HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if rateLimited(w, r) {
+		return
+	}
+	var req PasswordChangeRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if req.NewPassword == "" {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "new_password is required")
+		return
+	}
+	user, ok := callingUser(w, r)
+	if !ok {
+		return
+	}
+	if !CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		httpx.WriteError(w, r, http.StatusForbidden, httpx.CodeInvalidPassword, "the current password is wrong")
+		return
+	}
+	if setPassword(w, r, user.Username, req.NewPassword) {
+		audit(r, AuditEvent{Type: AuditPasswordSet, Subject: user.Username})
+	}
+}
+
+// HandleResetPassword lets admins set any user's password.
+func HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req PasswordResetRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	if req.NewPassword == "" {
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "new_password is required")
+		return
+	}
+	username := mux.Vars(r)["username"]
+	if setPassword(w, r, username, req.NewPassword) {
+		claims, _ := auth.ClaimsFromContext(r.Context())
+		audit(r, AuditEvent{Type: AuditPasswordSet, Subject: username, Actor: claims.Subject})
+	}
+}
+
+// setPassword stores the new password, answering 204 on success and 422
+// when Passwords refuses it.
+func setPassword(w http.ResponseWriter, r *http.Request, username, password string) bool {
+	if weakPassword(w, r, password) {
+		return false
+	}
+	hash, err := HashPassword(password)
+	if err == nil {
+		err = Users.UpdatePassword(r.Context(), username, hash)
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		httpx.WriteError(w, r, http.StatusNotFound, httpx.CodeUserNotFound, "")
+		return false
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return false
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// unknownTokenVersion is reported for users that do not exist. No token
+// carries a negative version, so the caller refuses all of theirs.
+const unknownTokenVersion = -1
+
+// HandleTokenVersion tells other services a user's current token version,
+// so they can refuse stale tokens. Unknown users are answered like known
+// ones, with a version no token has, so the route does not tell which
+// usernames exist.
+func HandleTokenVersion(w http.ResponseWriter, r *http.Request) {
+	version := unknownTokenVersion
+	user, err := Users.FindByUsername(r.Context(), mux.Vars(r)["username"])
+	if err == nil {
+		version = user.TokenVersion
+	} else if !errors.Is(err, ErrUserNotFound) {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"token_version": version})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const newPassword = "correct horse battery staple"
+
+func tokenVersion(t *testing.T, h http.Handler, username string) int {
+	t.Helper()
+	rec := serve(h, newRequest("GET", "/api/v1/internal/users/"+url.PathEscape(username)+"/token-version", nil))
+	var body struct {
+		TokenVersion int `json:"token_version"`
+	}
+	decode(t, rec, &body)
+	return body.TokenVersion
+}
+
+func TestPasswordChangeStalesTokens(t *testing.T) {
+	h := newTestService(t)
+	old := logIn(t, h, "John Doe", "password").AccessToken
+	if v := tokenVersion(t, h, "John Doe"); v != 0 {
+		t.Fatalf("token version before any change = %d", v)
+	}
+
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/password", PasswordChangeRequest{CurrentPassword: "password", NewPassword: newPassword}), old))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("changing the password: %d %s", rec.Code, rec.Body)
+	}
+	if v := tokenVersion(t, h, "John Doe"); v != 1 {
+		t.Errorf("token version after the change = %d, want 1", v)
+	}
+	expectError(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), old)), http.StatusUnauthorized, "token_stale")
+
+	fresh := logIn(t, h, "John Doe", newPassword).AccessToken
+	if v := claimsOf(t, fresh).TokenVersion; v != 1 {
+		t.Errorf("token_version claim = %d, want 1", v)
+	}
+	if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), fresh)); rec.Code != http.StatusOK {
+		t.Errorf("a token issued after the change: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasswordChangeNeedsCurrentPassword(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/password", PasswordChangeRequest{CurrentPassword: "wrong", NewPassword: newPassword}), token))
+	expectError(t, rec, http.StatusForbidden, "invalid_password")
+	if v := tokenVersion(t, h, "John Doe"); v != 0 {
+		t.Errorf("a refused change bumped the token version to %d", v)
+	}
+}
+
+func TestAdminResetStalesTokens(t *testing.T) {
+	h := newTestService(t)
+	john := logIn(t, h, "John Doe", "password").AccessToken
+	admin := logIn(t, h, "admin", "admin").AccessToken
+
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/users/John%20Doe/password", PasswordResetRequest{NewPassword: newPassword}), admin))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("resetting the password: %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), john)), http.StatusUnauthorized, "token_stale")
+	logIn(t, h, "John Doe", newPassword)
+	// the admin's own tokens are untouched
+	if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), admin)); rec.Code != http.StatusOK {
+		t.Errorf("the admin's token after resetting someone else's password: %d", rec.Code)
+	}
+}
+
+func TestTokenVersionOfUnknownUser(t *testing.T) {
+	h := newTestService(t)
+	if v := tokenVersion(t, h, "nobody"); v != unknownTokenVersion {
+		t.Errorf("token version of an unknown user = %d, want %d", v, unknownTokenVersion)
+	}
+}
+
+func TestUsersFromBeforeTokenVersions(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// a database last migrated before token_version existed
+	if _, err := db.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations[:len(migrations)-1] {
+		if _, err := db.Exec(m); err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1)
+	}
+	if _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", "old-timer", OurUser.PasswordHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSQLUserStore(db)
+	user, err := store.FindByUsername(ctx, "old-timer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TokenVersion != 0 {
+		t.Errorf("token version of a user from before the field = %d, want 0", user.TokenVersion)
+	}
+
+	// and tokens carrying no token_version claim stay good for them
+	h := newTestService(t)
+	Users = store
+	token := logIn(t, h, "old-timer", "password").AccessToken
+	if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)); rec.Code != http.StatusOK {
+		t.Errorf("a token of a user from before the field: %d %s", rec.Code, rec.Body)
+	}
+	if err := store.UpdatePassword(ctx, "old-timer", OurUser.PasswordHash); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.FindByUsername(ctx, "old-timer"); user.TokenVersion != 1 {
+		t.Errorf("token version after a password change = %d, want 1", user.TokenVersion)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+
+	"auth/httpx"
+)
+
+// A PasswordRequirement is one rule a new password failed.
+type PasswordRequirement struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// PasswordChecker decides whether a new password may be set, returning the
+// requirements it does not meet. Deployments with rules of their own (a
+// breached-password list, say) replace Passwords with theirs.
+type PasswordChecker interface {
+	Check(password string) []PasswordRequirement
+}
+
+// PasswordPolicy is the configurable PasswordChecker.
+type PasswordPolicy struct {
+	MinLength     int // in characters, not bytes
+	RequireDigit  bool
+	RequireUpper  bool
+	RequireSymbol bool
+}
+
+// Passwords checks every password set at registration, change or reset.
+var Passwords PasswordChecker = PasswordPolicy{MinLength: 8}
+
+// MaxPasswordBytes is the longest password bcrypt hashes; it refuses longer
+// ones rather than ignore the rest. It holds whatever Passwords is.
+const MaxPasswordBytes = 72
+
+func (p PasswordPolicy) Check(password string) []PasswordRequirement {
+	var digit, upper, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+
+	var unmet []PasswordRequirement
+	if utf8.RuneCountInString(password) < p.MinLength {
+		unmet = append(unmet, PasswordRequirement{"min_length", fmt.Sprintf("at least %d characters", p.MinLength)})
+	}
+	if p.RequireDigit && !digit {
+		unmet = append(unmet, PasswordRequirement{"digit", "a digit"})
+	}
+	if p.RequireUpper && !upper {
+		unmet = append(unmet, PasswordRequirement{"upper", "an upper-case letter"})
+	}
+	if p.RequireSymbol && !symbol {
+		unmet = append(unmet, PasswordRequirement{"symbol", "a symbol or punctuation mark"})
+	}
+	return unmet
+}
+
+// weakPassword answers 422 with the unmet requirements when Passwords
+// refuses password, and 400 password_too_long when it cannot be hashed.
+func weakPassword(w http.ResponseWriter, r *http.Request, password string) bool {
+	if len(password) > MaxPasswordBytes {
+		httpx.WriteErrorDetails(w, r, http.StatusBadRequest, httpx.CodePasswordTooLong,
+			fmt.Sprintf("the password must not exceed %d bytes", MaxPasswordBytes),
+			map[string]any{"unmet": []PasswordRequirement{{"max_length", fmt.Sprintf("at most %d bytes", MaxPasswordBytes)}}})
+		return true
+	}
+	unmet := Passwords.Check(password)
+	if len(unmet) == 0 {
+		return false
+	}
+	message := "the password needs "
+	for i, req := range unmet {
+		if i > 0 {
+			message += ", "
+		}
+		message += req.Description
+	}
+	httpx.WriteErrorDetails(w, r, http.StatusUnprocessableEntity, httpx.CodeWeakPassword, message,
+		map[string]any{"unmet": unmet})
+	return true
+}
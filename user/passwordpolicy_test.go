@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// strict is the policy the tests below configure.
+var strict = []string{"-password-min-length", "10", "-password-require-digit", "-password-require-upper", "-password-require-symbol"}
+
+// unmetIDs returns the ids of the unmet requirements in a password error.
+func unmetIDs(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Unmet []PasswordRequirement `json:"unmet"`
+		} `json:"error"`
+	}
+	decode(t, rec, &body)
+	var ids []string
+	for _, req := range body.Error.Unmet {
+		if req.Description == "" {
+			t.Errorf("requirement %q has no description", req.ID)
+		}
+		ids = append(ids, req.ID)
+	}
+	return ids
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, RequireDigit: true, RequireUpper: true, RequireSymbol: true}
+	for password, want := range map[string][]string{
+		"Correct-horse-1": nil,
+		"Sh0rt!":          {"min_length"},
+		"correcthorse":    {"digit", "upper", "symbol"},
+		"CORRECTHORSE1":   {"symbol"},
+		"ÄÖÜßäöü-ÄÖ-1":    nil, // characters, not bytes
+		"":                {"min_length", "digit", "upper", "symbol"},
+	} {
+		var got []string
+		for _, req := range policy.Check(password) {
+			got = append(got, req.ID)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Check(%q) = %v, want %v", password, got, want)
+		}
+	}
+	if unmet := (PasswordPolicy{}).Check("a"); len(unmet) != 0 {
+		t.Errorf("the empty policy refuses a password: %v", unmet)
+	}
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	register := func(h http.Handler, password string) *httptest.ResponseRecorder {
+		return serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Password: password}))
+	}
+	for name, tc := range map[string]struct {
+		password string
+		unmet    []string
+	}{
+		"too short":     {"Sh0rt!", []string{"min_length"}},
+		"no class":      {"correcthorsebattery", []string{"digit", "upper", "symbol"}},
+		"only a symbol": {"correct-horse-battery", []string{"digit", "upper"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newTestService(t, strict...)
+			rec := register(h, tc.password)
+			expectError(t, rec, http.StatusUnprocessableEntity, "weak_password")
+			if got := unmetIDs(t, rec); !slices.Equal(got, tc.unmet) {
+				t.Errorf("unmet = %v, want %v", got, tc.unmet)
+			}
+			if _, err := Users.FindByUsername(t.Context(), "jane"); err == nil {
+				t.Error("the user was registered anyway")
+			}
+		})
+	}
+
+	h := newTestService(t, strict...)
+	if rec := register(h, "Correct-horse-1"); rec.Code != http.StatusCreated {
+		t.Fatalf("registering with a good password: %d %s", rec.Code, rec.Body)
+	}
+	logIn(t, h, "jane", "Correct-horse-1")
+}
+
+func TestChangePasswordPolicy(t *testing.T) {
+	h := newTestService(t, strict...)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	change := func(password string) *httptest.ResponseRecorder {
+		return serve(h, withToken(newRequest("POST", "/api/v1/password", PasswordChangeRequest{CurrentPassword: "password", NewPassword: password}), token))
+	}
+
+	rec := change("correct horse battery staple")
+	expectError(t, rec, http.StatusUnprocessableEntity, "weak_password")
+	if got := unmetIDs(t, rec); !slices.Equal(got, []string{"digit", "upper", "symbol"}) {
+		t.Errorf("unmet = %v", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"message":"the password needs a digit, an upper-case letter, a symbol or punctuation mark"`) {
+		t.Errorf("the message does not list the requirements: %s", rec.Body)
+	}
+	logIn(t, h, "John Doe", "password")
+
+	if rec := change("Correct-horse-1"); rec.Code != http.StatusNoContent {
+		t.Fatalf("changing to a good password: %d %s", rec.Code, rec.Body)
+	}
+	logIn(t, h, "John Doe", "Correct-horse-1")
+}
+
+func TestPasswordTooLong(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	change := func(password string) *httptest.ResponseRecorder {
+		return serve(h, withToken(newRequest("POST", "/api/v1/password", PasswordChangeRequest{CurrentPassword: "password", NewPassword: password}), token))
+	}
+
+	// bcrypt would ignore everything past the 72nd byte
+	rec := change(strings.Repeat("x", MaxPasswordBytes+1))
+	expectError(t, rec, http.StatusBadRequest, "password_too_long")
+	if got := unmetIDs(t, rec); !slices.Equal(got, []string{"max_length"}) {
+		t.Errorf("unmet = %v", got)
+	}
+	// the limit is in bytes: 25 three-byte characters are 75
+	expectError(t, change(strings.Repeat("€", 25)), http.StatusBadRequest, "password_too_long")
+
+	long := strings.Repeat("x", MaxPasswordBytes)
+	if rec := change(long); rec.Code != http.StatusNoContent {
+		t.Fatalf("changing to a %d-byte password: %d %s", MaxPasswordBytes, rec.Code, rec.Body)
+	}
+	logIn(t, h, "John Doe", long)
+	rec = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: long[:MaxPasswordBytes-1]}))
+	expectError(t, rec, http.StatusUnauthorized, "invalid_credentials")
+
+	rec = serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Password: strings.Repeat("x", MaxPasswordBytes+1)}))
+	expectError(t, rec, http.StatusBadRequest, "password_too_long")
+}
+
+// checkerFunc is a PasswordChecker of a function.
+type checkerFunc func(string) []PasswordRequirement
+
+func (f checkerFunc) Check(password string) []PasswordRequirement { return f(password) }
+
+func TestCustomPasswordChecker(t *testing.T) {
+	h := newTestService(t)
+	Passwords = checkerFunc(func(password string) []PasswordRequirement {
+		if password == "Correct-horse-1" {
+			return []PasswordRequirement{{"not_breached", "not a known breached password"}}
+		}
+		return nil
+	})
+	rec := serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Password: "Correct-horse-1"}))
+	expectError(t, rec, http.StatusUnprocessableEntity, "weak_password")
+	if got := unmetIDs(t, rec); !slices.Equal(got, []string{"not_breached"}) {
+		t.Errorf("unmet = %v", got)
+	}
+	// the checker replaces the configured policy
+	if rec := serve(h, newRequest("POST", "/api/v1/register", RegisterRequest{Username: "jane", Password: "a"})); rec.Code != http.StatusCreated {
+		t.Errorf("registering with a password the checker allows: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"auth/httpx"
+)
+
+// maxDisplayName is the longest display name accepted, in characters.
+const maxDisplayName = 64
+
+// HandleGetProfile returns the caller's profile.
+func HandleGetProfile(w http.ResponseWriter, r *http.Request) {
+	user, ok := callingUser(w, r)
+	if !ok {
+		return
+	}
+	writeProfile(w, user)
+}
+
+// HandleUpdateProfile changes the profile fields present in the body.
+// Tokens issued afterwards carry the new display name; existing ones keep
+// the old one until they expire.
+func HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var req ProfileUpdate
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+	switch {
+	case req.Username != nil:
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "the username cannot be changed")
+		return
+	case req.Password != nil:
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "the password cannot be changed here")
+		return
+	case req.Email != nil && *req.Email != "" && !validEmail(*req.Email):
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, "email is not a valid address")
+		return
+	case req.DisplayName != nil && !validDisplayName(*req.DisplayName):
+		httpx.WriteError(w, r, http.StatusBadRequest, httpx.CodeInvalidRequest, fmt.Sprintf("display name must be at most %d characters, without control characters or surrounding spaces", maxDisplayName))
+		return
+	}
+
+	user, ok := callingUser(w, r)
+	if !ok {
+		return
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+
+	err := Users.Update(r.Context(), user)
+	if err == nil {
+		user, err = Users.FindByUsername(r.Context(), user.Username)
+	}
+	if errors.Is(err, ErrEmailTaken) {
+		httpx.WriteError(w, r, http.StatusConflict, httpx.CodeEmailTaken, "")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+	writeProfile(w, user)
+}
+
+func validDisplayName(name string) bool {
+	if strings.TrimSpace(name) != name || utf8.RuneCountInString(name) > maxDisplayName {
+		return false
+	}
+	return strings.IndexFunc(name, unicode.IsControl) < 0
+}
+
+func writeProfile(w http.ResponseWriter, user User) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Profile{
+		Username:    user.Username,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func getProfile(t *testing.T, h http.Handler, token string) Profile {
+	t.Helper()
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/profile", nil), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("getting the profile: %d %s", rec.Code, rec.Body)
+	}
+	var p Profile
+	decode(t, rec, &p)
+	return p
+}
+
+func TestGetProfile(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	p := getProfile(t, h, token)
+	if p.Username != "John Doe" || p.Email != "john.doe@example.com" {
+		t.Errorf("profile = %+v", p)
+	}
+}
+
+func TestUpdateProfilePartially(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	before := getProfile(t, h, token)
+
+	rec := serve(h, withToken(newRequest("PATCH", "/api/v1/profile", map[string]string{"display_name": "Johnny"}), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("updating the name: %d %s", rec.Code, rec.Body)
+	}
+	p := getProfile(t, h, token)
+	if p.DisplayName != "Johnny" || p.Email != before.Email {
+		t.Errorf("after changing only the name: %+v", p)
+	}
+	if p.UpdatedAt.Before(before.UpdatedAt) || !p.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("timestamps went from %v/%v to %v/%v", before.CreatedAt, before.UpdatedAt, p.CreatedAt, p.UpdatedAt)
+	}
+
+	rec = serve(h, withToken(newRequest("PATCH", "/api/v1/profile", map[string]string{"email": "john@example.org"}), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("updating the email: %d %s", rec.Code, rec.Body)
+	}
+	if p := getProfile(t, h, token); p.DisplayName != "Johnny" || p.Email != "john@example.org" {
+		t.Errorf("after changing only the email: %+v", p)
+	}
+}
+
+func TestUpdateProfileValidation(t *testing.T) {
+	tests := map[string]string{
+		"email format":     `{"email": "not-an-address"}`,
+		"name too long":    `{"display_name": "` + strings.Repeat("x", maxDisplayName+1) + `"}`,
+		"name with spaces": `{"display_name": " John "}`,
+		"username":         `{"username": "someone-else"}`,
+		"password":         `{"password": "hunter22"}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			h := newTestService(t)
+			token := logIn(t, h, "John Doe", "password").AccessToken
+			before := getProfile(t, h, token)
+
+			req := withToken(newRequest("PATCH", "/api/v1/profile", body), token)
+			req.Header.Set("Content-Type", "application/json")
+			expectError(t, serve(h, req), http.StatusBadRequest, "invalid_request")
+			if after := getProfile(t, h, token); after != before {
+				t.Errorf("a refused update changed the profile: %+v", after)
+			}
+		})
+	}
+	// the password is unchanged too
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	serve(h, withToken(newRequest("PATCH", "/api/v1/profile", map[string]string{"password": "hunter22"}), token))
+	logIn(t, h, "John Doe", "password")
+}
+
+func TestUpdatedNameInLaterTokens(t *testing.T) {
+	h := newTestService(t)
+	old := logIn(t, h, "John Doe", "password").AccessToken
+
+	rec := serve(h, withToken(newRequest("PATCH", "/api/v1/profile", map[string]string{"display_name": "Johnny"}), old))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("updating the name: %d %s", rec.Code, rec.Body)
+	}
+	if name := claimsOf(t, old).Name; name == "Johnny" {
+		t.Error("the token issued before the change carries the new name")
+	}
+	if name := claimsOf(t, logIn(t, h, "John Doe", "password").AccessToken).Name; name != "Johnny" {
+		t.Errorf("name claim after the change = %q, want Johnny", name)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auth/httpx"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+)
+
+// RefreshTokenTTL is how long a refresh token can be exchanged for new
+// tokens.
+var RefreshTokenTTL = 7 * 24 * time.Hour
+
+// MaxSessionAge is how long after the login a family's refresh tokens stop
+// working, however recently they were issued; zero means no cap.
+var MaxSessionAge time.Duration
+
+// RefreshToken is the stored state of one refresh token. Each refresh
+// replaces the token with a new one of the same family; presenting a
+// replaced token again means it leaked, and the whole family is revoked.
+type RefreshToken struct {
+	ID           string // hex SHA-256 of the token; the token itself is not kept
+	Family       string
+	Username     string
+	TokenVersion int // the user's at login; a later password change ends the family
+	Audience     []string
+	SessionStart time.Time // the family's login; see MaxSessionAge
+	ExpiresAt    time.Time
+	Used         bool // replaced by a newer token of the family
+}
+
+// RefreshStore keeps refresh token state.
+type RefreshStore interface {
+	Save(ctx context.Context, t RefreshToken) error
+	// Get fails with ErrRefreshTokenNotFound for unknown tokens and tokens
+	// of a revoked family.
+	Get(ctx context.Context, id string) (RefreshToken, error)
+	// Rotate marks old as used and saves next, failing with
+	// ErrRefreshTokenUsed if old was used already.
+	Rotate(ctx context.Context, oldID string, next RefreshToken) error
+	RevokeFamily(ctx context.Context, family string) error
+}
+
+// MemoryRefreshStore is a RefreshStore for a single instance. Expired
+// tokens, used ones included, and the revocations of families that can
+// have no live token left are dropped on every write.
+type MemoryRefreshStore struct {
+	mu      sync.Mutex
+	tokens  map[string]RefreshToken
+	revoked map[string]time.Time // families, until their last token expires
+}
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{tokens: make(map[string]RefreshToken), revoked: make(map[string]time.Time)}
+}
+
+// prune must be called with s.mu held.
+func (s *MemoryRefreshStore) prune() {
+	now := time.Now()
+	for id, t := range s.tokens {
+		if now.After(t.ExpiresAt) {
+			delete(s.tokens, id)
+		}
+	}
+	for family, until := range s.revoked {
+		if now.After(until) {
+			delete(s.revoked, family)
+		}
+	}
+}
+
+func (s *MemoryRefreshStore) isRevoked(family string) bool {
+	_, ok := s.revoked[family]
+	return ok
+}
+
+func (s *MemoryRefreshStore) Save(ctx context.Context, t RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *MemoryRefreshStore) Get(ctx context.Context, id string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || s.isRevoked(t.Family) {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryRefreshStore) Rotate(ctx context.Context, oldID string, next RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	old, ok := s.tokens[oldID]
+	if !ok || s.isRevoked(old.Family) {
+		return ErrRefreshTokenNotFound
+	}
+	if old.Used {
+		return ErrRefreshTokenUsed
+	}
+	old.Used = true
+	s.tokens[oldID] = old
+	s.tokens[next.ID] = next
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(ctx context.Context, family string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	// a token of the family may still be issued from one in flight
+	s.revoked[family] = time.Now().Add(RefreshTokenTTL)
+	for id, t := range s.tokens {
+		if t.Family == family {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// newRefreshToken returns a random refresh token for user and its state,
+// for access tokens to audience, in the family whose login was at started.
+// An empty family starts a new one.
+func newRefreshToken(user User, family string, audience []string, started time.Time) (string, RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", RefreshToken{}, err
+	}
+	if family == "" {
+		family = newTokenID()
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	return value, RefreshToken{
+		ID:           hashRefreshToken(value),
+		Family:       family,
+		Username:     user.Username,
+		TokenVersion: user.TokenVersion,
+		Audience:     audience,
+		SessionStart: started,
+		ExpiresAt:    time.Now().Add(RefreshTokenTTL),
+	}, nil
+}
+
+func hashRefreshToken(value string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// withRefreshToken starts a refresh token family for user and adds its
+// first token to opts.
+func withRefreshToken(ctx context.Context, opts *tokenOptions, user User) error {
+	value, rt, err := newRefreshToken(user, "", opts.Audience, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := Refresh.Save(ctx, rt); err != nil {
+		return err
+	}
+	opts.RefreshToken, opts.Family = value, rt.Family
+	return nil
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token.
+func HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if !httpx.DecodeJSON(w, r, &req, MaxBodySize) {
+		return
+	}
+
+	id := hashRefreshToken(req.RefreshToken)
+	old, err := Refresh.Get(r.Context(), id)
+	if err == nil && MaxSessionAge > 0 && time.Since(old.SessionStart) > MaxSessionAge {
+		if err := Refresh.RevokeFamily(r.Context(), old.Family); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+		}
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeSessionExpired, "the session has reached its maximum age; log in again")
+		return
+	}
+	if err == nil && time.Now().After(old.ExpiresAt) {
+		err = ErrRefreshTokenNotFound
+	}
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidGrant, "invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	user, err := Users.FindByUsername(r.Context(), old.Username)
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidGrant, "invalid or expired refresh token")
+		return
+	}
+
+	if user.TokenVersion != old.TokenVersion {
+		if err := Refresh.RevokeFamily(r.Context(), old.Family); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+		}
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidGrant, "the password was changed since this refresh token was issued")
+		return
+	}
+
+	value, next, err := newRefreshToken(user, old.Family, old.Audience, old.SessionStart)
+	if err == nil {
+		err = Refresh.Rotate(r.Context(), id, next)
+	}
+	if errors.Is(err, ErrRefreshTokenUsed) {
+		httpx.Log(r.Context()).Println("ERROR: refresh token reuse, revoking family", old.Family)
+		if err := Refresh.RevokeFamily(r.Context(), old.Family); err != nil {
+			httpx.Log(r.Context()).Println("ERROR: ", err)
+		}
+		httpx.WriteError(w, r, http.StatusUnauthorized, httpx.CodeInvalidGrant, "refresh token was already used")
+		return
+	}
+	if err != nil {
+		httpx.Log(r.Context()).Println("ERROR: ", err)
+		httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+		return
+	}
+
+	writeToken(w, r, user, tokenOptions{
+		Scope:        strings.Join(user.Scopes, " "),
+		Audience:     old.Audience,
+		RefreshToken: value,
+		Family:       next.Family,
+	})
+}
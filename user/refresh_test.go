@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func refresh(h http.Handler, token string) *httptest.ResponseRecorder {
+	return serve(h, newRequest("POST", "/api/v1/refresh", RefreshRequest{RefreshToken: token}))
+}
+
+// mustRefresh exchanges token and returns the new token response.
+func mustRefresh(t *testing.T, h http.Handler, token string) TokenResponse {
+	t.Helper()
+	rec := refresh(h, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refreshing: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	return resp
+}
+
+func testRefreshToken(id, family string) RefreshToken {
+	return RefreshToken{ID: id, Family: family, Username: "John Doe", SessionStart: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+func TestMemoryRefreshStoreSave(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryRefreshStore()
+	if err := s.Save(ctx, testRefreshToken("t1", "f1")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "t1")
+	if err != nil || got.Family != "f1" || got.Username != "John Doe" || got.Used {
+		t.Errorf("Get = %+v, %v", got, err)
+	}
+	if _, err := s.Get(ctx, "t2"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("unknown token: err = %v, want ErrRefreshTokenNotFound", err)
+	}
+
+	// expired tokens go on the next write
+	expired := testRefreshToken("old", "f0")
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	s.Save(ctx, expired)
+	s.Save(ctx, testRefreshToken("t3", "f3"))
+	if _, err := s.Get(ctx, "old"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("an expired token is still stored: %v", err)
+	}
+}
+
+func TestMemoryRefreshStoreRotate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryRefreshStore()
+	s.Save(ctx, testRefreshToken("t1", "f1"))
+
+	if err := s.Rotate(ctx, "t1", testRefreshToken("t2", "f1")); err != nil {
+		t.Fatal(err)
+	}
+	if old, _ := s.Get(ctx, "t1"); !old.Used {
+		t.Error("the rotated token is not marked used")
+	}
+	if _, err := s.Get(ctx, "t2"); err != nil {
+		t.Errorf("the new token: %v", err)
+	}
+	if err := s.Rotate(ctx, "t1", testRefreshToken("t3", "f1")); !errors.Is(err, ErrRefreshTokenUsed) {
+		t.Errorf("rotating a used token: err = %v, want ErrRefreshTokenUsed", err)
+	}
+	if err := s.Rotate(ctx, "nope", testRefreshToken("t4", "f1")); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("rotating an unknown token: err = %v, want ErrRefreshTokenNotFound", err)
+	}
+}
+
+func TestMemoryRefreshStoreRevokeFamily(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryRefreshStore()
+	s.Save(ctx, testRefreshToken("t1", "f1"))
+	s.Rotate(ctx, "t1", testRefreshToken("t2", "f1"))
+	s.Save(ctx, testRefreshToken("other", "f2"))
+
+	if err := s.RevokeFamily(ctx, "f1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"t1", "t2"} {
+		if _, err := s.Get(ctx, id); !errors.Is(err, ErrRefreshTokenNotFound) {
+			t.Errorf("%s of the revoked family: err = %v", id, err)
+		}
+	}
+	if _, err := s.Get(ctx, "other"); err != nil {
+		t.Errorf("another family: %v", err)
+	}
+
+	// a token of the family saved after the revocation, by a refresh that
+	// was in flight, is dead too
+	s.Save(ctx, testRefreshToken("late", "f1"))
+	if _, err := s.Get(ctx, "late"); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("a late token of the revoked family: err = %v", err)
+	}
+	if err := s.Rotate(ctx, "late", testRefreshToken("later", "f1")); !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Errorf("rotating a late token: err = %v", err)
+	}
+}
+
+func TestRefreshWithinLimits(t *testing.T) {
+	h := newTestService(t, "-refresh-token-ttl", "1h", "-max-session-age", "24h")
+	login := logIn(t, h, "John Doe", "password")
+
+	resp := mustRefresh(t, h, login.RefreshToken)
+	if resp.RefreshToken == "" || resp.RefreshToken == login.RefreshToken {
+		t.Fatalf("no new refresh token: %+v", resp)
+	}
+	if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), resp.AccessToken)); rec.Code != http.StatusOK {
+		t.Errorf("the refreshed access token: %d %s", rec.Code, rec.Body)
+	}
+	stored, err := Refresh.Get(t.Context(), hashRefreshToken(resp.RefreshToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(stored.ExpiresAt); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("the new refresh token is valid for %v, want an hour", ttl)
+	}
+	mustRefresh(t, h, resp.RefreshToken)
+}
+
+func TestRefreshPastTTL(t *testing.T) {
+	h := newTestService(t, "-refresh-token-ttl", "50ms")
+	login := logIn(t, h, "John Doe", "password")
+	time.Sleep(60 * time.Millisecond)
+	expectError(t, refresh(h, login.RefreshToken), http.StatusUnauthorized, "invalid_grant")
+}
+
+func TestRefreshPastSessionAge(t *testing.T) {
+	const maxAge = 300 * time.Millisecond
+	h := newTestService(t, "-refresh-token-ttl", "1h", "-max-session-age", maxAge.String())
+	login := logIn(t, h, "John Doe", "password")
+	start := time.Now() // no earlier than the session's
+
+	time.Sleep(maxAge / 2)
+	resp := mustRefresh(t, h, login.RefreshToken)
+
+	// the refresh token is fresh, but the login it continues is too old
+	time.Sleep(maxAge - time.Since(start) + 20*time.Millisecond)
+	expectError(t, refresh(h, resp.RefreshToken), http.StatusUnauthorized, "session_expired")
+	// and the family is gone
+	expectError(t, refresh(h, resp.RefreshToken), http.StatusUnauthorized, "invalid_grant")
+
+	// logging in again starts a new session
+	mustRefresh(t, h, logIn(t, h, "John Doe", "password").RefreshToken)
+}
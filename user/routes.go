@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"auth"
+	"auth/httpx"
+
+	"github.com/gorilla/mux"
+)
+
+// newRouter mounts the API under /api/v1. With legacy routes on, the same
+// routes are also served at their old unversioned paths, flagged as
+// deprecated. Debug endpoints and plain HTTP are only served in dev mode. A
+// known path requested with the wrong method gets 405.
+func newRouter(cfg Config) http.Handler {
+	r := mux.NewRouter()
+	// mux reports some method mismatches as not found, so both get checked
+	r.NotFoundHandler = httpx.MethodNotAllowed(func(req *http.Request) bool {
+		var m mux.RouteMatch
+		return r.Match(req, &m) && m.MatchErr == nil
+	}, http.HandlerFunc(httpx.NotFound))
+	r.MethodNotAllowedHandler = r.NotFoundHandler
+	registerV1(r.PathPrefix("/api/v1").Subrouter())
+	r.Handle("/.well-known/jwks.json", auth.JWKSHandler(Keys)).Methods("GET")
+
+	if cfg.Dev {
+		r.Handle("/debug/decode", auth.DecodeHandler(Keys)).Methods("POST")
+	}
+
+	if cfg.LegacyRoutes {
+		old := r.NewRoute().Subrouter()
+		old.Use(httpx.Deprecated("/api/v1"))
+		registerV1(old)
+	}
+	h := httpx.Timeout(cfg.RequestTimeout)(r)
+	if !cfg.Dev {
+		h = httpx.RequireHTTPS(TrustedProxies, serviceCall)(h)
+	}
+	return httpx.Standard(httpx.SecurityHeaders(cfg.HSTSMaxAge)(h))
+}
+
+// serviceCall lets services on this host, such as the server with its
+// default USER_SERVICE_URL, reach the internal routes and the JWKS over
+// plain HTTP. Everyone else needs HTTPS for those too.
+func serviceCall(r *http.Request) bool {
+	p := r.URL.Path
+	service := p == "/.well-known/jwks.json" || strings.HasPrefix(p, "/api/v1/internal/") || strings.HasPrefix(p, "/internal/")
+	return service && httpx.FromLoopback(r)
+}
+
+func registerV1(r *mux.Router) {
+	r.Handle("/login", httpx.NoStore(http.HandlerFunc(HandleLogin))).Methods("POST")
+	r.HandleFunc("/register", HandleRegister).Methods("POST")
+	r.Handle("/refresh", httpx.NoStore(http.HandlerFunc(HandleRefresh))).Methods("POST")
+	r.Handle("/2fa/verify", httpx.NoStore(http.HandlerFunc(HandleMFAVerify))).Methods("POST")
+	r.Handle("/token", httpx.NoStore(http.HandlerFunc(HandleToken))).Methods("POST")
+	r.Handle("/password/reset", auth.RequireAction(Keys, Sessions, ActionPasswordReset)(http.HandlerFunc(HandleActionPasswordReset))).Methods("POST")
+
+	// called by other services, with the shared secret; keep every internal
+	// route here
+	internal := r.PathPrefix("/internal").Subrouter()
+	internal.Use(auth.RequireServiceSecret(ServiceSecret))
+	internal.HandleFunc("/revoked/{jti}", HandleRevocationStatus).Methods("GET")
+	internal.HandleFunc("/apikeys/resolve", HandleResolveAPIKey).Methods("POST")
+	internal.HandleFunc("/users/{username}/token-version", HandleTokenVersion).Methods("GET")
+
+	authed := r.NewRoute().Subrouter()
+	authed.Use(jwtAuthMiddleware)
+	authed.HandleFunc("/logout", HandleLogout).Methods("POST")
+	authed.HandleFunc("/userinfo", HandleUserInfo).Methods("GET")
+	authed.HandleFunc("/profile", HandleGetProfile).Methods("GET")
+	authed.HandleFunc("/profile", HandleUpdateProfile).Methods("PATCH")
+	authed.HandleFunc("/password", HandleChangePassword).Methods("POST")
+	authed.HandleFunc("/sessions", HandleListSessions).Methods("GET")
+	authed.HandleFunc("/sessions/{jti}", HandleRevokeSession).Methods("DELETE")
+	authed.Handle("/2fa/enroll", httpx.NoStore(http.HandlerFunc(HandleTOTPEnroll))).Methods("POST")
+	authed.HandleFunc("/2fa/activate", HandleTOTPActivate).Methods("POST")
+
+	admin := authed.PathPrefix("/admin").Subrouter()
+	admin.Use(requireRole("admin"))
+	admin.Handle("/tokens", httpx.NoStore(http.HandlerFunc(HandleMintToken))).Methods("POST")
+	admin.Handle("/apikeys", httpx.NoStore(http.HandlerFunc(HandleCreateAPIKey))).Methods("POST")
+	admin.HandleFunc("/apikeys", HandleListAPIKeys).Methods("GET")
+	admin.HandleFunc("/apikeys/{id}", HandleRevokeAPIKey).Methods("DELETE")
+	admin.HandleFunc("/users/{username}/password", HandleResetPassword).Methods("POST")
+	admin.Handle("/users/{username}/action-tokens", httpx.NoStore(http.HandlerFunc(HandleMintActionToken))).Methods("POST")
+	admin.HandleFunc("/keys", HandleListKeys).Methods("GET")
+	admin.HandleFunc("/keys/rotate", HandleRotateKey).Methods("POST")
+}
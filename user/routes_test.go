@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"auth"
+	"auth/httpx"
+)
+
+func TestLegacyLoginRoute(t *testing.T) {
+	h := newTestService(t)
+	rec := serve(h, newRequest("POST", "/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy login: %d %s", rec.Code, rec.Body)
+	}
+	if d := rec.Header().Get("Deprecation"); d != "true" {
+		t.Errorf("Deprecation = %q on the legacy route, want true", d)
+	}
+	if d := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"})).Header().Get("Deprecation"); d != "" {
+		t.Errorf("the versioned route is deprecated: %q", d)
+	}
+
+	h = newTestService(t, "-legacy-routes=false")
+	rec = serve(h, newRequest("POST", "/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	expectError(t, rec, http.StatusNotFound, "not_found")
+}
+
+func TestLoginSecurityHeaders(t *testing.T) {
+	h := newTestService(t)
+	login := func(tlsConn bool) http.Header {
+		req := newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"})
+		if tlsConn {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := serve(h, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login: %d %s", rec.Code, rec.Body)
+		}
+		return rec.Header()
+	}
+
+	header := login(false)
+	for name, want := range map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "no-store",
+		"Pragma":                 "no-cache",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if got := header.Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Strict-Transport-Security over plain HTTP: %q", got)
+	}
+	if got := login(true).Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("Strict-Transport-Security over TLS = %q", got)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h := newTestService(t)
+	for _, path := range []string{"/api/v1/login", "/login"} {
+		rec := serve(h, newRequest("GET", path, nil))
+		expectError(t, rec, http.StatusMethodNotAllowed, "method_not_allowed")
+		if allow := rec.Header().Get("Allow"); allow != "POST" {
+			t.Errorf("Allow = %q on %s, want POST", allow, path)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+	}
+	// a path with several methods lists them all
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	rec := serve(h, withToken(newRequest("PUT", "/api/v1/profile", nil), token))
+	expectError(t, rec, http.StatusMethodNotAllowed, "method_not_allowed")
+	if allow := rec.Header().Get("Allow"); allow != "GET, PATCH" {
+		t.Errorf("Allow = %q on the profile, want GET, PATCH", allow)
+	}
+	expectError(t, serve(h, newRequest("GET", "/api/v1/nope", nil)), http.StatusNotFound, "not_found")
+}
+
+// captureLog collects what the standard logger writes during the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestUnmatchedRequestsLogged(t *testing.T) {
+	h := newTestService(t)
+	logs := captureLog(t)
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		code, allow  string
+	}{
+		{"GET", "/api/v1/login", http.StatusMethodNotAllowed, "method_not_allowed", "POST"},
+		{"GET", "/api/v1/nope", http.StatusNotFound, "not_found", ""},
+	} {
+		rec := serve(h, newRequest(tt.method, tt.path, nil))
+		expectError(t, rec, tt.status, tt.code)
+		if allow := rec.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+		id := rec.Header().Get(httpx.RequestIDHeader)
+		if id == "" {
+			t.Errorf("%s %s: no request ID", tt.method, tt.path)
+		}
+		if want := fmt.Sprintf("request_id=%s %s %s %d", id, tt.method, tt.path, tt.status); !strings.Contains(logs.String(), want) {
+			t.Errorf("log lacks %q:\n%s", want, logs)
+		}
+	}
+}
+
+func TestRequireHTTPS(t *testing.T) {
+	production := []string{"-dev=false", "-internal-secret", "s3cret", "-trusted-proxies", "10.0.0.0/8"}
+	login := func(h http.Handler, remote, proto string) *httptest.ResponseRecorder {
+		req := newRequest("POST", "/api/v1/login?cookie_only=true", LoginRequest{Username: "John Doe", Password: "password"})
+		req.RemoteAddr = remote
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		return serve(h, req)
+	}
+
+	h := newTestService(t, production...)
+	rec := login(h, "10.1.2.3:1234", "https")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login forwarded over https: %d %s", rec.Code, rec.Body)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("cookies %v, want one Secure", cookies)
+	}
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"cleartext":         login(h, "203.0.113.7:1234", ""),
+		"untrusted forward": login(h, "203.0.113.7:1234", "https"),
+	} {
+		expectError(t, rec, http.StatusBadRequest, "https_required")
+		if c := rec.Header().Get("Set-Cookie"); c != "" {
+			t.Errorf("%s: a cookie was set over cleartext: %s", name, c)
+		}
+	}
+
+	// services on this host reach the internal routes without TLS
+	internal := func(remote string) *httptest.ResponseRecorder {
+		req := newRequest("GET", "/api/v1/internal/users/admin/token-version", nil)
+		req.RemoteAddr = remote
+		req.Header.Set(auth.ServiceSecretHeader, "s3cret")
+		return serve(h, req)
+	}
+	if rec := internal("127.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("internal route from this host: %d %s", rec.Code, rec.Body)
+	}
+	expectError(t, internal("203.0.113.7:1234"), http.StatusBadRequest, "https_required")
+
+	// dev mode takes plain HTTP
+	h = newTestService(t)
+	if rec := login(h, "203.0.113.7:1234", ""); rec.Code != http.StatusOK {
+		t.Errorf("cleartext login in dev mode: %d %s", rec.Code, rec.Body)
+	}
+}
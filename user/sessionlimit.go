@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"auth/httpx"
+)
+
+// MaxSessions caps the logins a user may have active at once; zero means
+// no cap.
+var MaxSessions int
+
+// EvictOldestSession makes a login over MaxSessions end the oldest one
+// instead of being refused.
+var EvictOldestSession = true
+
+// Session limit policies, as configured.
+const (
+	SessionLimitEvict  = "evict"
+	SessionLimitReject = "reject"
+)
+
+// sessionLimited makes room for one more login of user, answering 429 when
+// the policy refuses it. A login counts once, however often it refreshed.
+// Unless it answered, it returns holding the user's login lock; the caller
+// calls release once the new session is recorded, so that concurrent
+// logins cannot all count the same free slot.
+func sessionLimited(w http.ResponseWriter, r *http.Request, user User) (release func(), limited bool) {
+	if MaxSessions <= 0 {
+		return func() {}, false
+	}
+	release = loginLocks.lock(user.Username)
+	logins := Sessions.Logins(user.Username)
+	if len(logins) < MaxSessions {
+		return release, false
+	}
+	if !EvictOldestSession {
+		release()
+		httpx.WriteError(w, r, http.StatusTooManyRequests, httpx.CodeTooManySessions, "log out of another session first")
+		return nil, true
+	}
+
+	for _, login := range logins[:len(logins)-MaxSessions+1] {
+		if err := endLogin(r, login); err != nil {
+			release()
+			httpx.Log(r.Context()).Println("ERROR: evicting session: ", err)
+			httpx.WriteError(w, r, http.StatusInternalServerError, httpx.CodeInternalError, "")
+			return nil, true
+		}
+	}
+	return release, false
+}
+
+// loginLocks serializes the logins of each user under MaxSessions.
+var loginLocks = userLocks{locks: map[string]*userLock{}}
+
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	waiters int // holders and those waiting, to know when to drop it
+}
+
+// lock locks username's mutex and returns its unlock.
+func (l *userLocks) lock(username string) func() {
+	l.mu.Lock()
+	ul, ok := l.locks[username]
+	if !ok {
+		ul = &userLock{}
+		l.locks[username] = ul
+	}
+	ul.waiters++
+	l.mu.Unlock()
+
+	ul.Lock()
+	return func() {
+		ul.Unlock()
+		l.mu.Lock()
+		if ul.waiters--; ul.waiters == 0 {
+			delete(l.locks, username)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// endLogin revokes a login's access tokens and its refresh tokens.
+func endLogin(r *http.Request, login []Session) error {
+	for _, sess := range login {
+		if err := Sessions.Revoke(r.Context(), sess.JTI, sess.ExpiresAt); err != nil {
+			return err
+		}
+		audit(r, AuditEvent{Type: AuditTokenRevoked, Subject: sess.Username, JTI: sess.JTI})
+	}
+	if family := login[0].Family; family != "" {
+		return Refresh.RevokeFamily(r.Context(), family)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionLimitEvictsOldest(t *testing.T) {
+	h := newTestService(t, "-max-sessions", "2", "-session-limit-policy", "evict")
+	first := logIn(t, h, "John Doe", "password")
+	// a refreshed login still counts once
+	rec := serve(h, newRequest("POST", "/api/v1/refresh", RefreshRequest{RefreshToken: first.RefreshToken}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refreshing: %d %s", rec.Code, rec.Body)
+	}
+	var refreshed TokenResponse
+	decode(t, rec, &refreshed)
+	second := logIn(t, h, "John Doe", "password")
+	admin := logIn(t, h, "admin", "admin")
+
+	for _, token := range []string{first.AccessToken, refreshed.AccessToken, second.AccessToken, admin.AccessToken} {
+		expectOK(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)))
+	}
+
+	third := logIn(t, h, "John Doe", "password")
+	for _, token := range []string{first.AccessToken, refreshed.AccessToken} {
+		expectError(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)), http.StatusUnauthorized, "token_revoked")
+	}
+	rec = serve(h, newRequest("POST", "/api/v1/refresh", RefreshRequest{RefreshToken: refreshed.RefreshToken}))
+	if rec.Code == http.StatusOK {
+		t.Error("the evicted login's refresh token still works")
+	}
+	for _, token := range []string{second.AccessToken, third.AccessToken, admin.AccessToken} {
+		expectOK(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)))
+	}
+	if sessions := listSessions(t, h, third.AccessToken); len(sessions) != 2 {
+		t.Errorf("got %d sessions, want 2: %+v", len(sessions), sessions)
+	}
+}
+
+func TestSessionLimitRejects(t *testing.T) {
+	h := newTestService(t, "-max-sessions", "2", "-session-limit-policy", "reject")
+	first := logIn(t, h, "John Doe", "password").AccessToken
+	second := logIn(t, h, "John Doe", "password").AccessToken
+
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	expectError(t, rec, http.StatusTooManyRequests, "too_many_sessions")
+	for _, token := range []string{first, second} {
+		expectOK(t, serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)))
+	}
+	// other users are not held back
+	logIn(t, h, "admin", "admin")
+
+	// logging out makes room
+	if rec := serve(h, withToken(newRequest("POST", "/api/v1/logout", nil), first)); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", rec.Code, rec.Body)
+	}
+	logIn(t, h, "John Doe", "password")
+}
+
+// slowRefreshStore takes a while to save refresh tokens.
+type slowRefreshStore struct{ RefreshStore }
+
+func (s slowRefreshStore) Save(ctx context.Context, t RefreshToken) error {
+	time.Sleep(20 * time.Millisecond)
+	return s.RefreshStore.Save(ctx, t)
+}
+
+func TestSessionLimitConcurrentLogins(t *testing.T) {
+	const logins, limit = 8, 3
+	for _, policy := range []string{SessionLimitReject, SessionLimitEvict} {
+		t.Run(policy, func(t *testing.T) {
+			// bcrypt is slow under -race; the logins must not time out
+			h := newTestService(t, "-max-sessions", strconv.Itoa(limit), "-session-limit-policy", policy, "-request-timeout", "0")
+			// widen the gap between counting the sessions and recording the new one
+			Refresh = slowRefreshStore{Refresh}
+			codes := make([]int, logins)
+			var wg sync.WaitGroup
+			for i := range codes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					codes[i] = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"})).Code
+				}()
+			}
+			wg.Wait()
+
+			ok := 0
+			for _, code := range codes {
+				if code == http.StatusOK {
+					ok++
+				}
+			}
+			want := logins
+			if policy == SessionLimitReject {
+				want = limit
+			}
+			if ok != want {
+				t.Errorf("%d logins succeeded, want %d: %v", ok, want, codes)
+			}
+			if n := len(Sessions.Logins("John Doe")); n != limit {
+				t.Errorf("%d active logins, want %d", n, limit)
+			}
+		})
+	}
+}
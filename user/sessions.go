@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"auth"
+)
+
+// Session describes one issued access token.
+type Session struct {
+	JTI       string    `json:"jti"`
+	Username  string    `json:"username"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Family    string    `json:"-"` // refresh token family, if any
+}
+
+// SessionStore tracks issued tokens; revoked ones go to its revocation store.
+type SessionStore struct {
+	mu          sync.Mutex
+	sessions    map[string]Session
+	revocations auth.RevocationStore
+}
+
+func NewSessionStore(revocations auth.RevocationStore) *SessionStore {
+	return &SessionStore{
+		sessions:    make(map[string]Session),
+		revocations: revocations,
+	}
+}
+
+func (s *SessionStore) Add(sess Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sess.JTI] = sess
+}
+
+func (s *SessionStore) Get(jti string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[jti]
+	if !ok || time.Now().After(sess.ExpiresAt) {
+		return Session{}, false
+	}
+	return sess, true
+}
+
+// Active returns the user's unexpired sessions, oldest first.
+func (s *SessionStore) Active(username string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	active := []Session{}
+	for _, sess := range s.sessions {
+		if sess.Username == username && now.Before(sess.ExpiresAt) {
+			active = append(active, sess)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].IssuedAt.Before(active[j].IssuedAt) })
+	return active
+}
+
+// Logins groups the user's active sessions by login, oldest login first:
+// the sessions of one refresh token family stem from the same login.
+func (s *SessionStore) Logins(username string) [][]Session {
+	var logins [][]Session
+	byFamily := map[string]int{}
+	for _, sess := range s.Active(username) {
+		if i, ok := byFamily[sess.Family]; ok && sess.Family != "" {
+			logins[i] = append(logins[i], sess)
+			continue
+		}
+		byFamily[sess.Family] = len(logins)
+		logins = append(logins, []Session{sess})
+	}
+	return logins
+}
+
+// Revoke ends the session so its token (expiring at exp) stops being
+// accepted. The token is revoked even if this instance never saw the
+// session.
+func (s *SessionStore) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	s.mu.Lock()
+	delete(s.sessions, jti)
+	s.mu.Unlock()
+	return s.revocations.Revoke(ctx, jti, exp)
+}
+
+func (s *SessionStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.revocations.IsRevoked(ctx, jti)
+}
+
+func (s *SessionStore) Consume(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	s.mu.Lock()
+	delete(s.sessions, jti)
+	s.mu.Unlock()
+	return s.revocations.Consume(ctx, jti, exp)
+}
+
+// CollectExpired drops sessions whose tokens have expired; an expired token
+// is rejected anyway, so there is nothing left to track.
+func (s *SessionStore) CollectExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for jti, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			delete(s.sessions, jti)
+		}
+	}
+}
+
+// CollectEvery runs CollectExpired in the background every interval.
+func (s *SessionStore) CollectEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.CollectExpired()
+		}
+	}()
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("ERROR: ", err)
+	}
+	return hex.EncodeToString(b)
+}
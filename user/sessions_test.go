@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func listSessions(t *testing.T, h http.Handler, token string) []Session {
+	t.Helper()
+	rec := serve(h, withToken(newRequest("GET", "/api/v1/sessions", nil), token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing sessions: %d %s", rec.Code, rec.Body)
+	}
+	var sessions []Session
+	decode(t, rec, &sessions)
+	return sessions
+}
+
+func TestListSessions(t *testing.T) {
+	h := newTestService(t)
+	first := logIn(t, h, "John Doe", "password").AccessToken
+	second := logIn(t, h, "John Doe", "password").AccessToken
+	logIn(t, h, "admin", "admin")
+
+	sessions := listSessions(t, h, second)
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2: %+v", len(sessions), sessions)
+	}
+	if sessions[0].JTI != claimsOf(t, first).ID || sessions[1].JTI != claimsOf(t, second).ID {
+		t.Errorf("sessions are not the user's two logins, oldest first: %+v", sessions)
+	}
+	for _, s := range sessions {
+		if s.Username != "John Doe" || s.IP == "" {
+			t.Errorf("unexpected session %+v", s)
+		}
+	}
+}
+
+func TestRevokeOwnSession(t *testing.T) {
+	h := newTestService(t)
+	first := logIn(t, h, "John Doe", "password").AccessToken
+	second := logIn(t, h, "John Doe", "password").AccessToken
+
+	rec := serve(h, withToken(newRequest("DELETE", "/api/v1/sessions/"+claimsOf(t, first).ID, nil), second))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("revoking: %d %s", rec.Code, rec.Body)
+	}
+	if sessions := listSessions(t, h, second); len(sessions) != 1 {
+		t.Errorf("got %d sessions after revoking one, want 1", len(sessions))
+	}
+
+	// the revoked token is refused here and reported revoked to other services
+	rec = serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), first))
+	expectError(t, rec, http.StatusUnauthorized, "token_revoked")
+	rec = serve(h, newRequest("GET", "/api/v1/internal/revoked/"+claimsOf(t, first).ID, nil))
+	var status struct {
+		Revoked bool `json:"revoked"`
+	}
+	decode(t, rec, &status)
+	if !status.Revoked {
+		t.Errorf("revocation status of the revoked token: %s", rec.Body)
+	}
+}
+
+func TestRevokeOtherUsersSession(t *testing.T) {
+	h := newTestService(t)
+	john := logIn(t, h, "John Doe", "password").AccessToken
+	admin := logIn(t, h, "admin", "admin").AccessToken
+
+	rec := serve(h, withToken(newRequest("DELETE", "/api/v1/sessions/"+claimsOf(t, admin).ID, nil), john))
+	expectError(t, rec, http.StatusForbidden, "forbidden")
+	if sessions := listSessions(t, h, admin); len(sessions) != 1 {
+		t.Errorf("the admin's session is gone after a forbidden revocation")
+	}
+
+	// admins may end anybody's
+	rec = serve(h, withToken(newRequest("DELETE", "/api/v1/sessions/"+claimsOf(t, john).ID, nil), admin))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("admin revoking: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRevokeUnknownSession(t *testing.T) {
+	h := newTestService(t)
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	rec := serve(h, withToken(newRequest("DELETE", "/api/v1/sessions/nope", nil), token))
+	expectError(t, rec, http.StatusNotFound, "session_not_found")
+}
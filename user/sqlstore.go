@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// SQLUserStore is a UserStore backed by a database/sql database. Queries use
+// ? placeholders (SQLite, MySQL).
+type SQLUserStore struct {
+	db *sql.DB
+}
+
+// NewSQLUserStore returns a store for db; run Migrate on db first.
+func NewSQLUserStore(db *sql.DB) *SQLUserStore {
+	return &SQLUserStore{db: db}
+}
+
+// roles are stored comma-separated, scopes space-separated as in the claim,
+// timestamps as unix seconds (0 when unknown)
+const userColumns = "username, password_hash, email, roles, scopes, totp_secret, totp_pending, display_name, created_at, updated_at, token_version"
+
+func (s *SQLUserStore) FindByUsername(ctx context.Context, username string) (User, error) {
+	return s.scan(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func (s *SQLUserStore) FindByEmail(ctx context.Context, email string) (User, error) {
+	return s.scan(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE lower(email) = lower(?)", email))
+}
+
+func (s *SQLUserStore) FindByIdentifier(ctx context.Context, identifier string) (User, error) {
+	u, err := s.FindByUsername(ctx, identifier)
+	if !errors.Is(err, ErrUserNotFound) {
+		return u, err
+	}
+	return s.FindByEmail(ctx, identifier)
+}
+
+func (s *SQLUserStore) Create(ctx context.Context, u User) error {
+	if u.Email != "" {
+		if _, err := s.FindByEmail(ctx, u.Email); err == nil {
+			return ErrEmailTaken
+		}
+	}
+	u = stamped(u)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		u.Username, u.PasswordHash, nullString(u.Email), strings.Join(u.Roles, ","), strings.Join(u.Scopes, " "),
+		u.TOTPSecret, u.TOTPPending, u.DisplayName, u.CreatedAt.Unix(), u.UpdatedAt.Unix(), u.TokenVersion)
+	if err != nil {
+		// the constraint error differs per driver, so look for the clash
+		if _, findErr := s.FindByUsername(ctx, u.Username); findErr == nil {
+			return ErrUserExists
+		}
+		if _, findErr := s.FindByEmail(ctx, u.Email); u.Email != "" && findErr == nil {
+			return ErrEmailTaken
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SQLUserStore) Update(ctx context.Context, u User) error {
+	if u.Email != "" {
+		if other, err := s.FindByEmail(ctx, u.Email); err == nil && other.Username != u.Username {
+			return ErrEmailTaken
+		}
+	}
+	return s.update(ctx, "UPDATE users SET email = ?, display_name = ?, updated_at = ? WHERE username = ?",
+		nullString(u.Email), u.DisplayName, timestamp().Unix(), u.Username)
+}
+
+func (s *SQLUserStore) UpdatePassword(ctx context.Context, username, passwordHash string) error {
+	return s.update(ctx, "UPDATE users SET password_hash = ?, token_version = token_version + 1 WHERE username = ?", passwordHash, username)
+}
+
+func (s *SQLUserStore) UpdateTOTP(ctx context.Context, username, secret, pending string) error {
+	return s.update(ctx, "UPDATE users SET totp_secret = ?, totp_pending = ? WHERE username = ?", secret, pending, username)
+}
+
+// update runs a query changing one user, reporting ErrUserNotFound when
+// there is no such user.
+func (s *SQLUserStore) update(ctx context.Context, query string, args ...any) error {
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLUserStore) scan(row *sql.Row) (User, error) {
+	var u User
+	var email sql.NullString
+	var roles, scopes string
+	var created, updated int64
+	err := row.Scan(&u.Username, &u.PasswordHash, &email, &roles, &scopes, &u.TOTPSecret, &u.TOTPPending,
+		&u.DisplayName, &created, &updated, &u.TokenVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	u.Email = email.String
+	if roles != "" {
+		u.Roles = strings.Split(roles, ",")
+	}
+	u.Scopes = strings.Fields(scopes)
+	u.CreatedAt, u.UpdatedAt = unixTime(created).UTC(), unixTime(updated).UTC()
+	return u, nil
+}
+
+// nullString stores empty strings as NULL so unique columns allow many.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
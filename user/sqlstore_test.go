@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func newTestSQLStore(t *testing.T) *SQLUserStore {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1) // every connection would get a database of its own
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLUserStore(db)
+}
+
+func TestSQLUserStoreLookup(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLStore(t)
+	if err := s.Create(ctx, OurUser); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, find := range map[string]func() (User, error){
+		"username":   func() (User, error) { return s.FindByUsername(ctx, "John Doe") },
+		"email":      func() (User, error) { return s.FindByEmail(ctx, "john.doe@example.com") },
+		"identifier": func() (User, error) { return s.FindByIdentifier(ctx, "john.doe@example.com") },
+	} {
+		u, err := find()
+		if err != nil {
+			t.Errorf("by %s: %v", name, err)
+			continue
+		}
+		if u.Username != OurUser.Username || u.PasswordHash != OurUser.PasswordHash || len(u.Roles) != len(OurUser.Roles) {
+			t.Errorf("by %s: got %+v", name, u)
+		}
+	}
+
+	if _, err := s.FindByUsername(ctx, "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("missing username: err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.FindByIdentifier(ctx, "nobody@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("missing identifier: err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestSQLUserStoreDuplicates(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLStore(t)
+	if err := s.Create(ctx, OurUser); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, User{Username: OurUser.Username, PasswordHash: "x"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("duplicate username: err = %v, want ErrUserExists", err)
+	}
+	if err := s.Create(ctx, User{Username: "jane", Email: OurUser.Email, PasswordHash: "x"}); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("duplicate email: err = %v, want ErrEmailTaken", err)
+	}
+	if _, err := s.FindByUsername(ctx, "jane"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("the rejected user was stored: %v", err)
+	}
+}
+
+func TestSQLUserStoreUpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLStore(t)
+	if err := s.Create(ctx, OurUser); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdatePassword(ctx, OurUser.Username, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.FindByUsername(ctx, OurUser.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.PasswordHash != "new-hash" || u.TokenVersion != OurUser.TokenVersion+1 {
+		t.Errorf("after the update: hash %q, token version %d", u.PasswordHash, u.TokenVersion)
+	}
+	if err := s.UpdatePassword(ctx, "nobody", "new-hash"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("updating a missing user: err = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+	ErrEmailTaken   = errors.New("email already in use")
+)
+
+// UserStore keeps the users that are allowed to log in. Usernames are
+// case-sensitive, emails are not; no two users share an email.
+type UserStore interface {
+	FindByUsername(ctx context.Context, username string) (User, error)
+	FindByEmail(ctx context.Context, email string) (User, error)
+	// FindByIdentifier matches identifier against usernames, then emails.
+	FindByIdentifier(ctx context.Context, identifier string) (User, error)
+	// Create fails with ErrUserExists or ErrEmailTaken on a clash. It
+	// stamps CreatedAt and UpdatedAt unless they are set.
+	Create(ctx context.Context, u User) error
+	// Update saves u's profile fields (email, display name) and stamps
+	// UpdatedAt. It fails with ErrUserNotFound, or ErrEmailTaken when
+	// another user has the email.
+	Update(ctx context.Context, u User) error
+	// UpdatePassword also bumps the user's TokenVersion in the same write,
+	// so tokens issued before stop being accepted.
+	UpdatePassword(ctx context.Context, username, passwordHash string) error
+	// UpdateTOTP replaces the user's active and pending TOTP secrets.
+	UpdateTOTP(ctx context.Context, username, secret, pending string) error
+}
+
+// MemoryUserStore is a UserStore kept in memory.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+func NewMemoryUserStore(users ...User) *MemoryUserStore {
+	s := &MemoryUserStore{users: make(map[string]User)}
+	for _, u := range users {
+		s.users[u.Username] = stamped(u)
+	}
+	return s
+}
+
+// stamped fills in a new user's timestamps, to the second as the SQL store
+// keeps them.
+func stamped(u User) User {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = timestamp()
+	}
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = u.CreatedAt
+	}
+	return u
+}
+
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (s *MemoryUserStore) FindByUsername(ctx context.Context, username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *MemoryUserStore) FindByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.findByEmail(email)
+}
+
+func (s *MemoryUserStore) findByEmail(email string) (User, error) {
+	for _, u := range s.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryUserStore) FindByIdentifier(ctx context.Context, identifier string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.users[identifier]; ok {
+		return u, nil
+	}
+	return s.findByEmail(identifier)
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.Username]; ok {
+		return ErrUserExists
+	}
+	if u.Email != "" {
+		if _, err := s.findByEmail(u.Email); err == nil {
+			return ErrEmailTaken
+		}
+	}
+	s.users[u.Username] = stamped(u)
+	return nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[u.Username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Email != "" {
+		if other, err := s.findByEmail(u.Email); err == nil && other.Username != u.Username {
+			return ErrEmailTaken
+		}
+	}
+	old.Email, old.DisplayName, old.UpdatedAt = u.Email, u.DisplayName, timestamp()
+	s.users[u.Username] = old
+	return nil
+}
+
+func (s *MemoryUserStore) UpdatePassword(ctx context.Context, username, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.PasswordHash = passwordHash
+	u.TokenVersion++
+	s.users[username] = u
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateTOTP(ctx context.Context, username, secret, pending string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.TOTPSecret, u.TOTPPending = secret, pending
+	s.users[username] = u
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"auth"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenTTL is how long issued access tokens stay valid.
+var TokenTTL = 24 * time.Hour
+
+// Encryption wraps issued access tokens in a JWE; nil leaves them signed
+// only.
+var Encryption *auth.TokenEncryption
+
+// CookieOnly makes every login deliver the token as a cookie only.
+var CookieOnly bool
+
+var ErrNotBeforeAfterExpiry = errors.New("not_before is after the token expiry")
+
+// tokenOptions tune a single token issuance.
+type tokenOptions struct {
+	NotBefore  time.Time     // when set, delays the moment the token becomes usable
+	CookieOnly bool          // deliver the token only as an HttpOnly cookie
+	TTL        time.Duration // overrides TokenTTL when set
+	Scope      string        // space-delimited scopes to grant
+	Client     bool          // the subject is a client, not a user
+	Audience   []string      // the services the token is for, if any
+	Profile    bool          // embed the user's profile in the response
+
+	RefreshToken string // returned alongside the access token, if set
+	Family       string // refresh token family the session belongs to
+}
+
+func (o tokenOptions) ttl() time.Duration {
+	if o.TTL > 0 {
+		return o.TTL
+	}
+	return TokenTTL
+}
+
+// issueToken signs an access token for user and records it as a session.
+// Clients are issued tokens as a user named after their id.
+func issueToken(r *http.Request, user User, opts tokenOptions) (string, error) {
+	now := time.Now()
+	claims := auth.Claims{
+		Name:         user.DisplayName,
+		Roles:        user.Roles,
+		Scope:        opts.Scope,
+		Client:       opts.Client,
+		TokenVersion: user.TokenVersion,
+		Version:      auth.ClaimsVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   user.Username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(opts.ttl())),
+		},
+	}
+	if len(opts.Audience) > 0 {
+		claims.Audience = opts.Audience
+	}
+	if !opts.NotBefore.IsZero() {
+		if opts.NotBefore.After(claims.ExpiresAt.Time) {
+			return "", ErrNotBeforeAfterExpiry
+		}
+		claims.NotBefore = jwt.NewNumericDate(opts.NotBefore)
+	}
+
+	signed, err := Keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+	if Encryption != nil {
+		if signed, err = Encryption.Encrypt(signed); err != nil {
+			return "", err
+		}
+	}
+
+	Sessions.Add(Session{
+		JTI:       claims.ID,
+		Username:  user.Username,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		IssuedAt:  now, // not the iat claim, which drops the sub-second part sessions are ordered by
+		ExpiresAt: claims.ExpiresAt.Time,
+		Family:    opts.Family,
+	})
+	return signed, nil
+}
+
+// unixTime converts an optional NumericDate-style timestamp.
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"auth"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// expectNotBefore fails the test unless token is not usable before nbf and
+// carries it as its nbf claim.
+func expectNotBefore(t *testing.T, token string, nbf int64) {
+	t.Helper()
+	if _, err := auth.ParseToken(token, Keys); !errors.Is(err, jwt.ErrTokenNotValidYet) {
+		t.Fatalf("parsing a token before its nbf: %v", err)
+	}
+	claims, err := auth.ParseToken(token, Keys, jwt.WithTimeFunc(func() time.Time { return time.Unix(nbf, 0) }))
+	if err != nil {
+		t.Fatalf("parsing the token at its nbf: %v", err)
+	}
+	if claims.NotBefore.Unix() != nbf {
+		t.Errorf("nbf = %v, want %d", claims.NotBefore, nbf)
+	}
+}
+
+func TestLoginNotBefore(t *testing.T) {
+	h := newTestService(t)
+	nbf := time.Now().Add(10 * time.Minute).Unix()
+
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password", NotBefore: nbf}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logging in: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	expectNotBefore(t, resp.AccessToken, nbf)
+
+	if claims := claimsOf(t, logIn(t, h, "John Doe", "password").AccessToken); claims.NotBefore != nil {
+		t.Errorf("a login without not_before got nbf %v", claims.NotBefore)
+	}
+}
+
+func TestMintTokenNotBefore(t *testing.T) {
+	h := newTestService(t)
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	nbf := time.Now().Add(10 * time.Minute).Unix()
+
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/admin/tokens", MintRequest{Subject: "John Doe", NotBefore: nbf}), admin))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("minting: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	expectNotBefore(t, resp.AccessToken, nbf)
+}
+
+func TestNotBeforeAfterExpiry(t *testing.T) {
+	h := newTestService(t)
+	admin := logIn(t, h, "admin", "admin").AccessToken
+	nbf := time.Now().Add(TokenTTL + time.Hour).Unix()
+
+	rec := serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password", NotBefore: nbf}))
+	expectError(t, rec, http.StatusUnprocessableEntity, "invalid_request")
+	rec = serve(h, withToken(newRequest("POST", "/api/v1/admin/tokens", MintRequest{Subject: "John Doe", NotBefore: nbf}), admin))
+	expectError(t, rec, http.StatusUnprocessableEntity, "invalid_request")
+}
+
+func TestLoginAudience(t *testing.T) {
+	h := newTestService(t, "-audiences", "service-a,service-b")
+	login := func(aud ...string) *httptest.ResponseRecorder {
+		return serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password", Audience: aud}))
+	}
+
+	rec := login("service-a")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login for service-a: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	if aud := claimsOf(t, resp.AccessToken).Audience; len(aud) != 1 || aud[0] != "service-a" {
+		t.Errorf("aud = %v, want [service-a]", aud)
+	}
+	if err := auth.CheckAudience(claimsOf(t, resp.AccessToken), "service-b"); err == nil {
+		t.Error("a token for service-a is accepted by service-b")
+	}
+
+	expectError(t, login("service-c"), http.StatusBadRequest, "invalid_target")
+	if aud := claimsOf(t, logIn(t, h, "John Doe", "password").AccessToken).Audience; len(aud) != 0 {
+		t.Errorf("aud = %v without asking for one", aud)
+	}
+}
+
+func TestLoginMultipleAudiences(t *testing.T) {
+	h := newTestService(t, "-audiences", "service-a,service-b,service-c")
+	login := func(body any) TokenResponse {
+		t.Helper()
+		rec := serve(h, newRequest("POST", "/api/v1/login", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login with %v: %d %s", body, rec.Code, rec.Body)
+		}
+		var resp TokenResponse
+		decode(t, rec, &resp)
+		return resp
+	}
+	expectAudience := func(token string, want ...string) {
+		t.Helper()
+		claims := claimsOf(t, token)
+		if !slices.Equal(claims.Audience, want) {
+			t.Errorf("aud = %v, want %v", claims.Audience, want)
+		}
+		for _, service := range want {
+			if err := auth.CheckAudience(claims, service); err != nil {
+				t.Errorf("%s refuses a token for %v: %v", service, want, err)
+			}
+		}
+		if err := auth.CheckAudience(claims, "service-d"); err == nil {
+			t.Errorf("service-d accepts a token for %v", want)
+		}
+	}
+
+	resp := login(LoginRequest{Username: "John Doe", Password: "password", Audience: []string{"service-a", "service-b"}})
+	expectAudience(resp.AccessToken, "service-a", "service-b")
+	// the refreshed token is for the same services
+	rec := serve(h, newRequest("POST", "/api/v1/refresh", RefreshRequest{RefreshToken: resp.RefreshToken}))
+	decode(t, rec, &resp)
+	expectAudience(resp.AccessToken, "service-a", "service-b")
+
+	// a single string still works
+	resp = login(`{"username": "John Doe", "password": "password", "audience": "service-c"}`)
+	expectAudience(resp.AccessToken, "service-c")
+
+	// the password grant repeats the field
+	rec = postLoginForm(h, url.Values{"grant_type": {"password"}, "username": {"John Doe"}, "password": {"password"}, "audience": {"service-b", "service-c"}})
+	decode(t, rec, &resp)
+	expectAudience(resp.AccessToken, "service-b", "service-c")
+
+	// each member must be known
+	rec = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password", Audience: []string{"service-a", "service-d"}}))
+	expectError(t, rec, http.StatusBadRequest, "invalid_target")
+}
+
+func TestEncryptedTokens(t *testing.T) {
+	h := newTestService(t)
+	path := filepath.Join(t.TempDir(), "jwe.key")
+	if err := os.WriteFile(path, []byte(strings.Repeat("ab", 32)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if Encryption, err = auth.LoadTokenEncryption(path); err != nil {
+		t.Fatal(err)
+	}
+
+	token := logIn(t, h, "John Doe", "password").AccessToken
+	if !auth.IsEncrypted(token) {
+		t.Fatalf("the login issued a plain token: %s", token)
+	}
+	if _, err := auth.ParseToken(token, Keys); err == nil {
+		t.Error("the encrypted token parses without decrypting it")
+	}
+	inner, err := Encryption.Unwrap(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub := claimsOf(t, inner).Subject; sub != "John Doe" {
+		t.Errorf("subject inside = %q", sub)
+	}
+	if rec := serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), token)); rec.Code != http.StatusOK {
+		t.Errorf("the service refuses its own encrypted token: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 test secret "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to our 6 digits
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"} {
+		if got, err := totpCode(rfcSecret, unix/30); err != nil || got != want {
+			t.Errorf("code at %d = %q, %v; want %q", unix, got, err, want)
+		}
+	}
+}
+
+// codeAt returns the code for secret at t, failing the test if it can't.
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totpCode(secret, at.Unix()/int64(totpStep/time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPVerifier(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	for _, tc := range []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"current step", now, true},
+		{"one step behind", now.Add(-totpStep), true},
+		{"one step ahead", now.Add(totpStep), true},
+		{"two steps behind", now.Add(-2 * totpStep), false},
+		{"two steps ahead", now.Add(2 * totpStep), false},
+	} {
+		v := NewTOTPVerifier(func() time.Time { return now })
+		if got := v.Verify("alice", rfcSecret, codeAt(t, rfcSecret, tc.at)); got != tc.ok {
+			t.Errorf("%s: accepted = %v, want %v", tc.name, got, tc.ok)
+		}
+	}
+
+	v := NewTOTPVerifier(func() time.Time { return now })
+	if v.Verify("alice", rfcSecret, "000000") {
+		t.Error("a wrong code was accepted")
+	}
+	code := codeAt(t, rfcSecret, now)
+	if !v.Verify("alice", rfcSecret, code) {
+		t.Fatal("the current code was refused")
+	}
+	if v.Verify("alice", rfcSecret, code) {
+		t.Error("a code was accepted twice")
+	}
+	if v.Verify("alice", rfcSecret, codeAt(t, rfcSecret, now.Add(-totpStep))) {
+		t.Error("an older code was accepted after a newer one")
+	}
+	if !v.Verify("bob", rfcSecret, code) {
+		t.Error("a code used by one user was refused for another")
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	h := newTestService(t)
+	now := time.Now()
+	TOTP = NewTOTPVerifier(func() time.Time { return now })
+	token := logIn(t, h, "John Doe", "password").AccessToken
+
+	rec := serve(h, withToken(newRequest("POST", "/api/v1/2fa/enroll", nil), token))
+	var enrolled struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}
+	decode(t, rec, &enrolled)
+	if !strings.HasPrefix(enrolled.URI, "otpauth://totp/") || !strings.Contains(enrolled.URI, "secret="+enrolled.Secret) {
+		t.Fatalf("enrollment: %s", rec.Body)
+	}
+	rec = serve(h, withToken(newRequest("POST", "/api/v1/2fa/activate", TOTPCodeRequest{Code: "000000"}), token))
+	expectError(t, rec, http.StatusBadRequest, "invalid_code")
+	rec = serve(h, withToken(newRequest("POST", "/api/v1/2fa/activate", TOTPCodeRequest{Code: codeAt(t, enrolled.Secret, now)}), token))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("activation: %d %s", rec.Code, rec.Body)
+	}
+
+	// the password alone now only gets an mfa token
+	now = now.Add(totpStep)
+	rec = serve(h, newRequest("POST", "/api/v1/login", LoginRequest{Username: "John Doe", Password: "password"}))
+	var challenge MFAChallenge
+	decode(t, rec, &challenge)
+	if rec.Code != http.StatusUnauthorized || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login with 2fa on: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(h, withToken(newRequest("GET", "/api/v1/userinfo", nil), challenge.MFAToken))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("the mfa token works as an access token: %d", rec.Code)
+	}
+
+	verify := func(code string) *httptest.ResponseRecorder {
+		return serve(h, newRequest("POST", "/api/v1/2fa/verify", MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: code}))
+	}
+	expectError(t, verify("000000"), http.StatusUnauthorized, "invalid_code")
+	code := codeAt(t, enrolled.Secret, now)
+	rec = verify(code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verify: %d %s", rec.Code, rec.Body)
+	}
+	var resp TokenResponse
+	decode(t, rec, &resp)
+	if claimsOf(t, resp.AccessToken).Subject != "John Doe" {
+		t.Errorf("verify issued %s", rec.Body)
+	}
+	expectError(t, verify(code), http.StatusUnauthorized, "token_invalid") // the mfa token is spent
+}
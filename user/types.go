@@ -1,11 +1,135 @@
 package main
 
+import (
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
 type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"` // bcrypt
+	Email        string    `json:"email,omitempty"`
+	DisplayName  string    `json:"display_name,omitempty"` // the name claim
+	Roles        []string  `json:"roles,omitempty"`
+	Scopes       []string  `json:"scopes,omitempty"` // granted in the scope claim at login
+	TOTPSecret   string    `json:"-"`                // set once two-factor login is active
+	TOTPPending  string    `json:"-"`                // enrolled but not yet confirmed
+	TokenVersion int       `json:"-"`                // bumped on password changes, see auth.Claims
+	CreatedAt    time.Time `json:"created_at"`       // zero for users older than the field
+	UpdatedAt    time.Time `json:"updated_at"`       // last profile change
+}
+
+type LoginRequest struct {
+	Login      string `json:"login"`      // username or email
+	Identifier string `json:"identifier"` // same as login, kept for earlier clients
+	Username   string `json:"username"`   // older clients send this instead of login
+	Password   string `json:"password"`
+	NotBefore  int64  `json:"not_before,omitempty"` // unix seconds
+
+	// Audience names the services the token is for: one as a string or
+	// several as a list.
+	Audience jwt.ClaimStrings `json:"audience,omitempty"`
+}
+
+type RegisterRequest struct {
 	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
 	Password string `json:"password"`
 }
 
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	NewPassword string `json:"new_password"`
+}
+
+type ActionTokenRequest struct {
+	Action string `json:"action"`
+}
+
+type ActionTokenResponse struct {
+	Token     string `json:"token"`
+	Action    string `json:"action"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// Profile is the part of a user they can see and edit through /profile.
+type Profile struct {
+	Username    string    `json:"username"`
+	Email       string    `json:"email,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ProfileUpdate changes the fields that are set; an empty string clears
+// one. Username and password are only decoded to refuse them clearly.
+type ProfileUpdate struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Username    *string `json:"username"`
+	Password    *string `json:"password"`
+}
+
+type MintRequest struct {
+	Subject   string `json:"subject"`
+	NotBefore int64  `json:"not_before,omitempty"` // unix seconds
+}
+
+// TokenResponse is the body of a successful token request.
+type TokenResponse struct {
+	AccessToken  string     `json:"access_token"`
+	TokenType    string     `json:"token_type"`
+	ExpiresIn    int        `json:"expires_in"`
+	RefreshToken string     `json:"refresh_token,omitempty"`
+	Scope        string     `json:"scope,omitempty"`
+	User         *LoginUser `json:"user,omitempty"` // with ?include=profile
+}
+
+// LoginUser is the profile a login embeds on request, so clients need no
+// second round trip. It holds nothing secret.
+type LoginUser struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+}
+
+// UserInfo is the OpenID Connect userinfo response. Claims we don't know
+// are left out rather than sent as null.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	UpdatedAt         int64  `json:"updated_at,omitempty"` // epoch seconds, as OIDC has it
+}
+
 var OurUser = User{
-	Username: "John Doe",
-	Password: "password", // yes I know, very strong password
+	Username:     "John Doe",
+	PasswordHash: mustHashPassword("password"), // yes I know, very strong password
+	Email:        "john.doe@example.com",
+	Roles:        []string{"user"},
+	Scopes:       []string{"profile", "greet:read"},
+}
+
+var OurAdmin = User{
+	Username:     "admin",
+	PasswordHash: mustHashPassword("admin"),
+	Email:        "admin@example.com",
+	Roles:        []string{"user", "admin"},
+	Scopes:       []string{"profile", "greet:read"},
 }